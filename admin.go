@@ -0,0 +1,131 @@
+package geecache
+
+import (
+	"encoding/json"
+	"geecache/consistence"
+	"net/http"
+	"strconv"
+)
+
+// 管理接口
+//
+//	GET    /_geecache_admin/peers                     查看当前节点列表
+//	POST   /_geecache_admin/peers?peer=addr           添加节点
+//	DELETE /_geecache_admin/peers?peer=addr           删除节点
+//	POST   /_geecache_admin/leave?peer=addr           节点主动退出, 只接受该节点自己发出的请求
+//	GET    /_geecache_admin/ring[?key=k&replicas=n]   查看哈希环及key的归属
+func (p *HTTPPool) serveAdmin(w http.ResponseWriter, r *http.Request) {
+	p.Log("%s %s", r.Method, r.URL.Path)
+
+	switch r.URL.Path[len(p.adminPath):] {
+	case "peers":
+		p.servePeers(w, r)
+	case "ring":
+		p.serveRing(w, r)
+	case "leave":
+		p.serveLeave(w, r)
+	default:
+		http.Error(w, "not found", http.StatusNotFound)
+	}
+}
+
+func (p *HTTPPool) servePeers(w http.ResponseWriter, r *http.Request) {
+	switch r.Method {
+	case http.MethodGet:
+	case http.MethodPost, http.MethodDelete:
+		peer, err := parsePeer(r.URL.Query().Get("peer"))
+		if err != nil {
+			http.Error(w, "bad peer: "+err.Error(), http.StatusBadRequest)
+			return
+		}
+		if r.Method == http.MethodPost {
+			p.AddPeer(peer)
+		} else {
+			p.RemovePeer(peer)
+		}
+	default:
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(p.Peers())
+}
+
+func (p *HTTPPool) serveLeave(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	peer, err := parsePeer(r.URL.Query().Get("peer"))
+	if err != nil {
+		http.Error(w, "bad peer: "+err.Error(), http.StatusBadRequest)
+		return
+	}
+	if !sentByPeer(r, peer) {
+		http.Error(w, "leave must be announced by the leaving peer", http.StatusForbidden)
+		return
+	}
+	p.peerLeft(peer)
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// 哈希环信息
+type RingInfo struct {
+	Self     string             `json:"self"`
+	Version  uint64             `json:"version"`            // 节点视图版本号, 每次变更加1
+	Members  []string           `json:"members"`            // 全部节点
+	Healthy  []string           `json:"healthy"`            // 参与选择的节点
+	Shares   map[string]float64 `json:"shares,omitempty"`   // 每个节点负责的key空间百分比
+	Key      string             `json:"key,omitempty"`      // 查询的key
+	Owner    string             `json:"owner,omitempty"`    // key的所有者
+	Replicas []string           `json:"replicas,omitempty"` // key的副本节点, 按优先级排序
+}
+
+// 哈希环信息, key不为空时同时返回key的所有者及n个副本节点
+func (p *HTTPPool) Ring(key string, n int) RingInfo {
+	state := p.peers.Load()
+	info := RingInfo{
+		Self:    p.self,
+		Version: state.version,
+		Members: state.members(),
+		Healthy: state.picker.Nodes(),
+	}
+
+	if sr, ok := state.picker.(consistence.ShareReporter); ok {
+		info.Shares = make(map[string]float64)
+		for node, share := range sr.Shares() {
+			info.Shares[node] = share * 100
+		}
+	}
+
+	if key != "" {
+		info.Key = key
+		info.Owner = ownerOf(state.picker, key)
+		if rp, ok := state.picker.(consistence.ReplicaPicker); ok && n > 0 {
+			info.Replicas = rp.GetNodes(key, n)
+		} else if info.Owner != "" {
+			info.Replicas = []string{info.Owner}
+		}
+	}
+	return info
+}
+
+func (p *HTTPPool) serveRing(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	n := 1
+	if s := r.URL.Query().Get("replicas"); s != "" {
+		var err error
+		if n, err = strconv.Atoi(s); err != nil || n < 1 {
+			http.Error(w, "bad replicas: "+s, http.StatusBadRequest)
+			return
+		}
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(p.Ring(r.URL.Query().Get("key"), n))
+}
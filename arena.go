@@ -0,0 +1,383 @@
+package geecache
+
+import (
+	"encoding/binary"
+	"geecache/consistence"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// 条目头部: hash(8) + 过期时间(8) + key长度(2) + value长度(4) + 压缩算法编号(1)
+const arenaHeaderSize = 23
+
+// 默认的arena总大小
+const defaultArenaSize = 64 << 20
+
+// 将key和value存储在预分配的大块内存中的缓存(类似BigCache/FreeCache)
+//
+// 每个分片是一个环形缓冲区, 索引为map[uint64]uint32, 不包含指针,
+// GC不需要扫描缓存内容. 空间不足时按写入顺序淘汰最早的条目.
+type arenaCache struct {
+	parts []*arenaShard
+
+	mu          sync.Mutex
+	compressors []Compressor // 压缩算法编号表, 编号为下标加1
+}
+
+type arenaShard struct {
+	mu       sync.Mutex
+	index    map[uint64]uint32 // key的哈希值 -> 条目在buf中的偏移
+	buf      []byte
+	head     int  // 最早的条目
+	tail     int  // 下一个条目写入的位置
+	wrapAt   int  // 回绕时buf尾部数据结束的位置
+	wrapped  bool // 数据是否跨越buf尾部
+	capacity int  // 最大缓存数量, 为0时不限制
+
+	hits      atomic.Int64
+	misses    atomic.Int64
+	evictions atomic.Int64
+}
+
+// capacity为最大缓存数量, size为总字节数, 均平均分配给各分片
+func newArenaCache(capacity int64, size int64, shards int) *arenaCache {
+	if shards <= 0 {
+		shards = defaultCacheShards
+	}
+	if size <= 0 {
+		size = defaultArenaSize
+	}
+	if capacity > 0 && int64(shards) > capacity {
+		shards = int(capacity)
+	}
+
+	c := &arenaCache{parts: make([]*arenaShard, shards)}
+	for i := range c.parts {
+		n := capacity / int64(shards)
+		if int64(i) < capacity%int64(shards) {
+			n++
+		}
+		c.parts[i] = &arenaShard{
+			index:    make(map[uint64]uint32),
+			buf:      make([]byte, size/int64(shards)),
+			capacity: int(n),
+		}
+	}
+	return c
+}
+
+func (c *arenaCache) shard(hash uint64) *arenaShard {
+	return c.parts[hash%uint64(len(c.parts))]
+}
+
+// 压缩算法的编号, 0表示未压缩
+func (c *arenaCache) compressorID(comp Compressor) byte {
+	if comp == nil {
+		return 0
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	for i, known := range c.compressors {
+		if known == comp {
+			return byte(i + 1)
+		}
+	}
+	if len(c.compressors) == 255 {
+		return 0
+	}
+	c.compressors = append(c.compressors, comp)
+	return byte(len(c.compressors))
+}
+
+func (c *arenaCache) compressor(id byte) Compressor {
+	if id == 0 {
+		return nil
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.compressors[id-1]
+}
+
+func (c *arenaCache) add(key string, value ByteView, second int64) {
+	if len(key) > 0xffff {
+		return
+	}
+	id := c.compressorID(value.c)
+	if value.c != nil && id == 0 {
+		// 压缩算法编号已用完, 保存解压后的数据, 无法解压时不写入
+		decoded, err := value.decode()
+		if err != nil {
+			return
+		}
+		value = ByteView{b: decoded.raw()}
+	}
+
+	var expireAt int64
+	if second > 0 {
+		expireAt = time.Now().Add(time.Duration(second) * time.Second).Unix()
+	}
+
+	hash := consistence.DefaultHash([]byte(key))
+	s := c.shard(hash)
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.put(hash, key, value.b, id, expireAt)
+}
+
+func (c *arenaCache) get(key string) (ByteView, bool) {
+	hash := consistence.DefaultHash([]byte(key))
+	s := c.shard(hash)
+
+	s.mu.Lock()
+	value, id, ok := s.lookup(hash, key)
+	s.mu.Unlock()
+
+	if !ok {
+		s.misses.Add(1)
+		return ByteView{}, false
+	}
+	s.hits.Add(1)
+	return ByteView{b: value, c: c.compressor(id)}, true
+}
+
+func (c *arenaCache) expireAt(key string) (int64, bool) {
+	hash := consistence.DefaultHash([]byte(key))
+	s := c.shard(hash)
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	off, ok := s.index[hash]
+	if !ok || s.key(int(off)) != key {
+		return 0, false
+	}
+	return int64(binary.LittleEndian.Uint64(s.buf[off+8:])), true
+}
+
+// 只删除索引, 条目占用的空间在淘汰时回收
+func (c *arenaCache) remove(key string) {
+	hash := consistence.DefaultHash([]byte(key))
+	s := c.shard(hash)
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if off, ok := s.index[hash]; ok && s.key(int(off)) == key {
+		delete(s.index, hash)
+	}
+}
+
+// 最近写入的n个key, 各分片轮流取最近写入的key
+func (c *arenaCache) hotKeys(n int) []string {
+	perShard := make([][]string, len(c.parts))
+	for i, s := range c.parts {
+		s.mu.Lock()
+		perShard[i] = s.recentKeys(n)
+		s.mu.Unlock()
+	}
+	return interleaveKeys(perShard, n)
+}
+
+// 逐个分片复制条目后再调用fn, 避免fn执行期间持有分片的锁
+func (c *arenaCache) each(fn func(key string, value ByteView, expireAt int64) bool) {
+	now := time.Now().Unix()
+	for _, s := range c.parts {
+		s.mu.Lock()
+		entries := s.entries(now)
+		s.mu.Unlock()
+
+		for _, e := range entries {
+			if !fn(e.key, ByteView{b: e.value, c: c.compressor(e.id)}, e.expireAt) {
+				return
+			}
+		}
+	}
+}
+
+// 释放全部内存, 之后写入因空间不足被忽略
+func (c *arenaCache) close() {
+	for _, s := range c.parts {
+		s.mu.Lock()
+		s.index = make(map[uint64]uint32)
+		s.buf = nil
+		s.head, s.tail, s.wrapAt, s.wrapped = 0, 0, 0, false
+		s.mu.Unlock()
+	}
+}
+
+func (c *arenaCache) stats() CacheStats {
+	stats := CacheStats{Shards: len(c.parts)}
+	for _, s := range c.parts {
+		s.mu.Lock()
+		stats.Entries += int64(len(s.index))
+		s.mu.Unlock()
+		stats.Hits += s.hits.Load()
+		stats.Misses += s.misses.Load()
+		stats.Evictions += s.evictions.Load()
+	}
+	return stats
+}
+
+func (s *arenaShard) entrySize(off int) int {
+	keyLen := int(binary.LittleEndian.Uint16(s.buf[off+16:]))
+	valLen := int(binary.LittleEndian.Uint32(s.buf[off+18:]))
+	return arenaHeaderSize + keyLen + valLen
+}
+
+func (s *arenaShard) key(off int) string {
+	keyLen := int(binary.LittleEndian.Uint16(s.buf[off+16:]))
+	return string(s.buf[off+arenaHeaderSize : off+arenaHeaderSize+keyLen])
+}
+
+func (s *arenaShard) empty() bool {
+	return !s.wrapped && s.head == s.tail
+}
+
+// 写入条目, 空间或数量不足时淘汰最早的条目
+func (s *arenaShard) put(hash uint64, key string, value []byte, id byte, expireAt int64) {
+	n := arenaHeaderSize + len(key) + len(value)
+	if n > len(s.buf) {
+		return
+	}
+	// 已存在的旧条目成为垃圾, 淘汰时跳过
+	delete(s.index, hash)
+	for s.capacity > 0 && len(s.index) >= s.capacity {
+		s.evictOldest()
+	}
+
+	for {
+		if !s.wrapped {
+			if s.tail+n <= len(s.buf) {
+				break
+			}
+			if s.empty() {
+				s.head, s.tail = 0, 0
+				continue
+			}
+			s.wrapAt, s.tail, s.wrapped = s.tail, 0, true
+			continue
+		}
+		if s.tail+n <= s.head {
+			break
+		}
+		s.evictOldest()
+	}
+
+	off := s.tail
+	binary.LittleEndian.PutUint64(s.buf[off:], hash)
+	binary.LittleEndian.PutUint64(s.buf[off+8:], uint64(expireAt))
+	binary.LittleEndian.PutUint16(s.buf[off+16:], uint16(len(key)))
+	binary.LittleEndian.PutUint32(s.buf[off+18:], uint32(len(value)))
+	s.buf[off+22] = id
+	copy(s.buf[off+arenaHeaderSize:], key)
+	copy(s.buf[off+arenaHeaderSize+len(key):], value)
+
+	s.index[hash] = uint32(off)
+	s.tail += n
+}
+
+// 淘汰最早写入的条目
+func (s *arenaShard) evictOldest() {
+	if s.empty() {
+		return
+	}
+	off := s.head
+	hash := binary.LittleEndian.Uint64(s.buf[off:])
+	if cur, ok := s.index[hash]; ok && int(cur) == off {
+		delete(s.index, hash)
+		s.evictions.Add(1)
+	}
+
+	s.head += s.entrySize(off)
+	if s.wrapped && s.head == s.wrapAt {
+		s.head, s.wrapped = 0, false
+	}
+	if s.empty() {
+		s.head, s.tail = 0, 0
+	}
+}
+
+// 查找条目, 返回value的副本
+func (s *arenaShard) lookup(hash uint64, key string) ([]byte, byte, bool) {
+	off, ok := s.index[hash]
+	if !ok {
+		return nil, 0, false
+	}
+	o := int(off)
+	keyLen := int(binary.LittleEndian.Uint16(s.buf[o+16:]))
+	if keyLen != len(key) || string(s.buf[o+arenaHeaderSize:o+arenaHeaderSize+keyLen]) != key {
+		return nil, 0, false
+	}
+	if t := int64(binary.LittleEndian.Uint64(s.buf[o+8:])); t != 0 && t < time.Now().Unix() {
+		delete(s.index, hash)
+		return nil, 0, false
+	}
+
+	valLen := int(binary.LittleEndian.Uint32(s.buf[o+18:]))
+	start := o + arenaHeaderSize + keyLen
+	return cloneBytes(s.buf[start : start+valLen]), s.buf[o+22], true
+}
+
+// 最近写入的n个有效key, n小于等于0时返回全部
+func (s *arenaShard) recentKeys(n int) []string {
+	var offs []int
+	visit := func(from, to int) {
+		for off := from; off < to; off += s.entrySize(off) {
+			hash := binary.LittleEndian.Uint64(s.buf[off:])
+			if cur, ok := s.index[hash]; ok && int(cur) == off {
+				offs = append(offs, off)
+			}
+		}
+	}
+	if s.wrapped {
+		visit(s.head, s.wrapAt)
+		visit(0, s.tail)
+	} else {
+		visit(s.head, s.tail)
+	}
+
+	keys := make([]string, 0, len(offs))
+	for i := len(offs) - 1; i >= 0 && (n <= 0 || len(keys) < n); i-- {
+		keys = append(keys, s.key(offs[i]))
+	}
+	return keys
+}
+
+type arenaEntry struct {
+	key      string
+	value    []byte
+	id       byte
+	expireAt int64
+}
+
+// 全部未过期的有效条目, 按写入顺序
+func (s *arenaShard) entries(now int64) []arenaEntry {
+	var entries []arenaEntry
+	visit := func(from, to int) {
+		for off := from; off < to; off += s.entrySize(off) {
+			hash := binary.LittleEndian.Uint64(s.buf[off:])
+			if cur, ok := s.index[hash]; !ok || int(cur) != off {
+				continue
+			}
+			expireAt := int64(binary.LittleEndian.Uint64(s.buf[off+8:]))
+			if expireAt != 0 && expireAt < now {
+				continue
+			}
+			keyLen := int(binary.LittleEndian.Uint16(s.buf[off+16:]))
+			valLen := int(binary.LittleEndian.Uint32(s.buf[off+18:]))
+			start := off + arenaHeaderSize + keyLen
+			entries = append(entries, arenaEntry{
+				key:      s.key(off),
+				value:    cloneBytes(s.buf[start : start+valLen]),
+				id:       s.buf[off+22],
+				expireAt: expireAt,
+			})
+		}
+	}
+	if s.wrapped {
+		visit(s.head, s.wrapAt)
+		visit(0, s.tail)
+	} else {
+		visit(s.head, s.tail)
+	}
+	return entries
+}
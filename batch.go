@@ -0,0 +1,181 @@
+package geecache
+
+import (
+	"fmt"
+	"sync"
+)
+
+// 批量加载时的最大并发数
+const batchConcurrency = 16
+
+// 发往同一节点的批量请求
+type peerBatch struct {
+	client  BatchNodeClient
+	keys    []string
+	clients []NodeClient // 每个key选中的客户端, 请求结束后释放负载
+}
+
+// 知道远程节点地址的客户端, 选择算法每次可能返回新的客户端对象, 按地址合并请求
+type addressedClient interface {
+	peerAddr() string
+}
+
+// 批量请求的分组依据, 优先使用节点地址
+func batchKey(client NodeClient) interface{} {
+	if ac, ok := client.(addressedClient); ok {
+		return ac.peerAddr()
+	}
+	return client
+}
+
+// 批量获取缓存值, 返回每个key的值或错误
+//
+// 未命中的key按所属节点分组, 每个节点只发送一次批量请求, 属于自己的key并行从数据源加载.
+func (g *CacheGroup) GetMany(keys []string) (map[string]ByteView, map[string]error) {
+	values := make(map[string]ByteView, len(keys))
+	errs := make(map[string]error)
+
+	var mu sync.Mutex
+	setResult := func(key string, value ByteView, err error) {
+		mu.Lock()
+		defer mu.Unlock()
+		if err != nil {
+			errs[key] = err
+			return
+		}
+		values[key] = value
+	}
+
+	if g.closed.Load() {
+		for _, key := range keys {
+			errs[key] = ErrGroupClosed
+		}
+		return values, errs
+	}
+
+	// 命中本地缓存的key直接返回, 其余按所属节点分组
+	batches := make(map[interface{}]*peerBatch)
+	var singles []string
+	seen := make(map[string]struct{}, len(keys))
+	for _, key := range keys {
+		if _, ok := seen[key]; ok {
+			continue
+		}
+		seen[key] = struct{}{}
+
+		if key == "" {
+			errs[key] = fmt.Errorf("key is required")
+			continue
+		}
+		v, ok, err := g.lookupCache(key)
+		if err != nil {
+			errs[key] = err
+			continue
+		}
+		if ok {
+			g.metrics.CacheHit(g.name)
+			values[key] = v
+			continue
+		}
+		g.metrics.CacheMiss(g.name)
+
+		client := g.pickClients(key)[0]
+		if bc, ok := client.(BatchNodeClient); ok {
+			id := batchKey(client)
+			b := batches[id]
+			if b == nil {
+				b = &peerBatch{client: bc}
+				batches[id] = b
+			}
+			b.keys = append(b.keys, key)
+			b.clients = append(b.clients, client)
+			continue
+		}
+		// 逐个加载时会重新选择节点
+		releaseLoad(client)
+		singles = append(singles, key)
+	}
+
+	var wg sync.WaitGroup
+	for _, b := range batches {
+		wg.Add(1)
+		go func(b *peerBatch) {
+			defer wg.Done()
+
+			got, keyErrs, err := b.client.GetCacheValues(g.name, b.keys)
+			for _, client := range b.clients {
+				releaseLoad(client)
+			}
+			var retry []string
+			for _, key := range b.keys {
+				switch {
+				case err != nil:
+					retry = append(retry, key)
+				case keyErrs[key] != nil:
+					setResult(key, ByteView{}, keyErrs[key])
+				default:
+					if v, ok := got[key]; ok {
+						setResult(key, ByteView{b: v}, nil)
+					} else {
+						retry = append(retry, key)
+					}
+				}
+			}
+			// 批量请求失败的key逐个走常规的加载流程(其他副本或数据源)
+			parallel(retry, func(key string) {
+				v, err := g.load(key)
+				setResult(key, v, err)
+			})
+		}(b)
+	}
+
+	parallel(singles, func(key string) {
+		v, err := g.load(key)
+		setResult(key, v, err)
+	})
+	wg.Wait()
+
+	return values, errs
+}
+
+// 处理其他节点的批量请求, 不再转发
+func (g *CacheGroup) getManyForPeer(keys []string) (map[string]ByteView, map[string]error) {
+	values := make(map[string]ByteView, len(keys))
+	errs := make(map[string]error)
+
+	var mu sync.Mutex
+	parallel(keys, func(key string) {
+		v, err := g.getForPeer(key)
+
+		mu.Lock()
+		defer mu.Unlock()
+		if err != nil {
+			errs[key] = err
+			return
+		}
+		values[key] = v
+	})
+	return values, errs
+}
+
+// 以最多batchConcurrency个协程并行处理keys
+func parallel(keys []string, fn func(key string)) {
+	if len(keys) == 0 {
+		return
+	}
+
+	sem := make(chan struct{}, batchConcurrency)
+	var wg sync.WaitGroup
+	for _, key := range keys {
+		wg.Add(1)
+		sem <- struct{}{}
+		go func(key string) {
+			defer func() {
+				<-sem
+				wg.Done()
+			}()
+			fn(key)
+		}(key)
+	}
+	wg.Wait()
+}
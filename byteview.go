@@ -1,16 +1,96 @@
 package geecache
 
+import (
+	"bytes"
+	"fmt"
+	"io"
+)
+
 // 缓存对象
 type ByteView struct {
-	b []byte // 存储真实的缓存值, 选择byte类型是为了能够支持任意的数据类型的存储, 如: 字符串、图片等
+	b []byte     // 存储真实的缓存值, 选择byte类型是为了能够支持任意的数据类型的存储, 如: 字符串、图片等
+	c Compressor // 不为nil时b为压缩后的数据
+	d []byte     // 从缓存读取时解压后的数据, 不写入缓存
 }
 
 func (v ByteView) ByteSlice() []byte {
-	return cloneBytes(v.b)
+	if v.c != nil && v.d == nil {
+		return v.raw()
+	}
+	return cloneBytes(v.raw())
 }
 
 func (v ByteView) String() string {
-	return string(v.b)
+	return string(v.raw())
+}
+
+// 以下只读方法不复制数据. 从缓存读取的压缩值在读取时已解压一次, 这些方法不会重复解压
+
+// 值的长度
+func (v ByteView) Len() int {
+	return len(v.raw())
+}
+
+// 第i个字节
+func (v ByteView) At(i int) byte {
+	return v.raw()[i]
+}
+
+// [from, to)区间的只读视图
+func (v ByteView) Slice(from, to int) ByteView {
+	return ByteView{b: v.raw()[from:to]}
+}
+
+// 将值写入w, 实现io.WriterTo
+func (v ByteView) WriteTo(w io.Writer) (int64, error) {
+	n, err := w.Write(v.raw())
+	return int64(n), err
+}
+
+// 返回读取值的io.ReadSeeker
+func (v ByteView) Reader() io.ReadSeeker {
+	return bytes.NewReader(v.raw())
+}
+
+// 与另一个值比较是否相等
+func (v ByteView) Equal(other ByteView) bool {
+	return bytes.Equal(v.raw(), other.raw())
+}
+
+// 与b比较是否相等
+func (v ByteView) EqualBytes(b []byte) bool {
+	return bytes.Equal(v.raw(), b)
+}
+
+// 解压并保留解压后的数据, 之后的读取不再解压, 压缩数据仍用于节点间传输
+func (v ByteView) decode() (ByteView, error) {
+	if v.c == nil || v.d != nil {
+		return v, nil
+	}
+	d, err := v.c.Decompress(v.b)
+	if err != nil {
+		return ByteView{}, fmt.Errorf("decompress %s: %w", v.c.Name(), err)
+	}
+	v.d = d
+	return v, nil
+}
+
+// 解压后的数据, 未压缩时直接返回b, 调用方不能修改
+//
+// 从缓存读取的值都已通过decode解压, 解压失败时调用方得到的是错误而不是空值;
+// 其他压缩的值需要先通过decode校验, 否则解压失败时返回nil.
+func (v ByteView) raw() []byte {
+	if v.c == nil {
+		return v.b
+	}
+	if v.d != nil {
+		return v.d
+	}
+	b, err := v.c.Decompress(v.b)
+	if err != nil {
+		return nil
+	}
+	return b
 }
 
 func cloneBytes(b []byte) []byte {
@@ -3,13 +3,147 @@ package geecache
 import (
 	"geecache/lru"
 	"sync"
+	"sync/atomic"
 	"time"
 )
 
+const (
+	defaultCacheShards = 32 // 默认分片数量
+	readBufferSize     = 64 // 每个分片记录访问的缓冲区大小
+)
+
+// 缓存存储
+type store interface {
+	add(key string, value ByteView, second int64) // second秒后过期, 为0时不过期
+	get(key string) (ByteView, bool)
+	expireAt(key string) (int64, bool) // key的过期时间(unix秒), 为0时不过期
+	remove(key string)
+	hotKeys(n int) []string // 最近访问的n个key
+	// 遍历未过期的缓存值, expireAt为过期时间(unix秒), 为0时不过期; fn返回false时停止
+	each(fn func(key string, value ByteView, expireAt int64) bool)
+	stats() CacheStats
+	close() // 停止后台任务并释放缓存, 之后写入被忽略
+}
+
+// 缓存存储方式
+type StorageMode int
+
+const (
+	StorageLRU   StorageMode = iota // 默认, 分片LRU, 值以对象形式存储
+	StorageArena                    // 预分配的大块内存, 减少GC扫描, 按写入顺序淘汰
+)
+
+var (
+	_ store = (*cache)(nil)
+	_ store = (*arenaCache)(nil)
+)
+
+// 并发缓存, 按key的哈希值分为多个独立加锁的分片
+//
+// 读取只访问sync.Map, 不加锁; 访问记录写入有损的缓冲区,
+// 缓冲区满时由抢到锁的读取者(或下一次写入)批量更新LRU顺序.
 type cache struct {
-	mu       sync.Mutex
-	lru      *lru.LRUCache
-	capacity int64
+	capacity        int64         // 总容量, 平均分配给各分片, 为0时不限制
+	shards          int           // 分片数量, 为0时使用默认值
+	cleanupInterval time.Duration // 过期key的清理周期, 为0时使用默认值
+	fifo            bool          // 读取不更新访问顺序, 按写入顺序淘汰
+
+	once   sync.Once
+	parts  []*cacheShard
+	stopCh chan struct{}
+	closed atomic.Bool
+}
+
+type cacheShard struct {
+	items sync.Map // key -> *cacheItem, 读取路径
+	reads readBuffer
+
+	mu  sync.Mutex    // 保护lru, 写入及更新访问顺序时持有
+	lru *lru.LRUCache // 维护访问顺序和容量, 值为*cacheItem
+
+	hits      atomic.Int64
+	misses    atomic.Int64
+	evictions atomic.Int64
+}
+
+type cacheItem struct {
+	key      string
+	value    ByteView
+	expireAt atomic.Int64 // 过期时间(unix秒), 为0时不过期
+}
+
+func (it *cacheItem) expired(now int64) bool {
+	t := it.expireAt.Load()
+	return t != 0 && t < now
+}
+
+// 有损的访问记录缓冲区, 满了之后的记录直接丢弃
+type readBuffer struct {
+	pos   atomic.Uint32
+	slots [readBufferSize]atomic.Pointer[cacheItem]
+}
+
+// 记录一次访问, 返回缓冲区是否已满
+func (b *readBuffer) record(item *cacheItem) bool {
+	i := b.pos.Add(1) - 1
+	if i >= readBufferSize {
+		return true
+	}
+	b.slots[i].Store(item)
+	return i == readBufferSize-1
+}
+
+// 缓存统计, 为所有分片的总和
+type CacheStats struct {
+	Shards    int   // 分片数量
+	Entries   int64 // 当前缓存数量
+	Hits      int64 // 命中次数
+	Misses    int64 // 未命中次数
+	Evictions int64 // 因容量不足被淘汰的数量
+}
+
+func (c *cache) init() {
+	c.once.Do(func() {
+		n := c.shards
+		if n <= 0 {
+			n = defaultCacheShards
+		}
+		// 保证每个分片至少能缓存一个key
+		if c.capacity > 0 && int64(n) > c.capacity {
+			n = int(c.capacity)
+		}
+
+		c.parts = make([]*cacheShard, n)
+		for i := range c.parts {
+			capacity := c.capacity / int64(n)
+			if int64(i) < c.capacity%int64(n) {
+				capacity++
+			}
+			s := &cacheShard{}
+			s.lru = lru.NewCache(capacity, func(key string, _ lru.Value) {
+				s.items.Delete(key)
+				s.evictions.Add(1)
+			})
+			c.parts[i] = s
+		}
+		c.stopCh = make(chan struct{})
+		interval := c.cleanupInterval
+		if interval <= 0 {
+			interval = defaultCleanupInterval
+		}
+		go c.startExpiryCleanup(interval)
+	})
+}
+
+// 根据key选择分片, 使用FNV-1a避免分配内存
+func (c *cache) shard(key string) *cacheShard {
+	c.init()
+	var h uint32 = 2166136261
+	for i := 0; i < len(key); i++ {
+		h ^= uint32(key[i])
+		h *= 16777619
+	}
+	return c.parts[h%uint32(len(c.parts))]
 }
 
 // 定时清除过期key的任务协程
@@ -20,44 +154,205 @@ func (c *cache) startExpiryCleanup(interval time.Duration) {
 	for {
 		select {
 		case <-ticker.C:
-			c.mu.Lock()
-			c.lru.CleanupExpiredKeys()
-			c.mu.Unlock()
+			for _, s := range c.parts {
+				s.cleanup()
+			}
+		case <-c.stopCh:
+			return
 		}
 	}
 }
 
-func (c *cache) add(key string, value ByteView) {
-	c.mu.Lock()
+// 写入和设置过期时间在同一次加锁中完成, 避免为已被淘汰或删除的key设置过期时间
+func (c *cache) add(key string, value ByteView, second int64) {
+	s := c.shard(key)
+	item := &cacheItem{key: key, value: value}
+	if second > 0 {
+		item.expireAt.Store(time.Now().Add(time.Duration(second) * time.Second).Unix())
+	}
 
-	if c.lru == nil {
-		c.lru = lru.NewCache(c.capacity, nil)
-		go c.startExpiryCleanup(10 * time.Minute)
+	s.mu.Lock()
+	if c.closed.Load() {
+		s.mu.Unlock()
+		return
+	}
+	s.drain()
+	s.lru.Add(key, item)
+	// 覆盖写入时清除之前的过期时间
+	if second > 0 {
+		s.lru.Expire(key, second)
+	} else {
+		s.lru.Persist(key)
 	}
-	c.lru.Add(key, value)
-	c.mu.Unlock()
+	s.items.Store(key, item)
+	s.mu.Unlock()
 }
 
+// 读取不加锁, 只有缓冲区满且锁空闲时才更新访问顺序
 func (c *cache) get(key string) (value ByteView, ok bool) {
-	c.mu.Lock()
-	defer c.mu.Unlock()
-	if c.lru == nil {
+	s := c.shard(key)
+	v, ok := s.items.Load(key)
+	if !ok {
+		s.misses.Add(1)
 		return
 	}
+	item := v.(*cacheItem)
+	if item.expired(time.Now().Unix()) {
+		s.misses.Add(1)
+		return value, false
+	}
+	s.hits.Add(1)
+
+	if !c.fifo && s.reads.record(item) && s.mu.TryLock() {
+		s.drain()
+		s.mu.Unlock()
+	}
+	return item.value, true
+}
+
+func (c *cache) expireAt(key string) (int64, bool) {
+	v, ok := c.shard(key).items.Load(key)
+	if !ok {
+		return 0, false
+	}
+	return v.(*cacheItem).expireAt.Load(), true
+}
+
+func (c *cache) remove(key string) {
+	s := c.shard(key)
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.lru.Remove(key)
+	s.items.Delete(key)
+}
+
+// 将缓冲区中的访问记录更新到LRU, 调用方需持有s.mu
+func (s *cacheShard) drain() {
+	n := s.reads.pos.Load()
+	if n > readBufferSize {
+		n = readBufferSize
+	}
+	for i := uint32(0); i < n; i++ {
+		item := s.reads.slots[i].Swap(nil)
+		if item == nil {
+			continue
+		}
+		// 只处理仍在缓存中的同一个值, 已被替换或淘汰的记录直接丢弃
+		if v, ok := s.items.Load(item.key); !ok || v.(*cacheItem) != item {
+			continue
+		}
+		if _, ok := s.lru.Get(item.key); !ok {
+			// LRU发现已过期并删除
+			s.items.Delete(item.key)
+		}
+	}
+	s.reads.pos.Store(0)
+}
+
+// 清除过期key, LRU按抽样清除, sync.Map中过期的key全部删除
+func (s *cacheShard) cleanup() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.drain()
+	s.lru.CleanupExpiredKeys()
+	now := time.Now().Unix()
+	s.items.Range(func(key, v any) bool {
+		if v.(*cacheItem).expired(now) {
+			s.items.Delete(key)
+		}
+		return true
+	})
+}
 
-	if v, ok := c.lru.Get(key); ok {
-		return v.(ByteView), ok
+// 最近访问的n个key, 各分片轮流取最近访问的key
+func (c *cache) hotKeys(n int) []string {
+	c.init()
+	perShard := make([][]string, len(c.parts))
+	for i, s := range c.parts {
+		s.mu.Lock()
+		s.drain()
+		perShard[i] = s.lru.Keys(n)
+		s.mu.Unlock()
+	}
+	return interleaveKeys(perShard, n)
+}
+
+// 各分片的key轮流取一个合并, 避免热点key全部来自第一个分片
+func interleaveKeys(perShard [][]string, n int) []string {
+	keys := make([]string, 0)
+	for i := 0; n <= 0 || len(keys) < n; i++ {
+		var found bool
+		for _, shardKeys := range perShard {
+			if i >= len(shardKeys) {
+				continue
+			}
+			found = true
+			keys = append(keys, shardKeys[i])
+			if n > 0 && len(keys) >= n {
+				break
+			}
+		}
+		if !found {
+			break
+		}
 	}
+	return keys
+}
 
-	return
+// 遍历sync.Map, 不加锁, 遍历期间的写入不一定可见
+func (c *cache) each(fn func(key string, value ByteView, expireAt int64) bool) {
+	c.init()
+	now := time.Now().Unix()
+	for _, s := range c.parts {
+		ok := true
+		s.items.Range(func(_, v any) bool {
+			item := v.(*cacheItem)
+			if item.expired(now) {
+				return true
+			}
+			ok = fn(item.key, item.value, item.expireAt.Load())
+			return ok
+		})
+		if !ok {
+			return
+		}
+	}
 }
 
-func (c *cache) expire(key string, second int64) {
-	c.mu.Lock()
-	defer c.mu.Unlock()
-	if c.lru == nil {
+func (c *cache) close() {
+	c.init()
+	if c.closed.Swap(true) {
 		return
 	}
+	close(c.stopCh)
 
-	c.lru.Expire(key, second)
+	for _, s := range c.parts {
+		s.mu.Lock()
+		s.lru = lru.NewCache(0, nil)
+		s.items.Range(func(key, _ any) bool {
+			s.items.Delete(key)
+			return true
+		})
+		s.reads.pos.Store(0)
+		for i := range s.reads.slots {
+			s.reads.slots[i].Store(nil)
+		}
+		s.mu.Unlock()
+	}
+}
+
+func (c *cache) stats() CacheStats {
+	c.init()
+	stats := CacheStats{Shards: len(c.parts)}
+	for _, s := range c.parts {
+		s.mu.Lock()
+		stats.Entries += s.lru.Len()
+		s.mu.Unlock()
+		stats.Hits += s.hits.Load()
+		stats.Misses += s.misses.Load()
+		stats.Evictions += s.evictions.Load()
+	}
+	return stats
 }
@@ -0,0 +1,323 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"geecache/wal"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+// 服务配置, 优先级: 默认值 < 配置文件 < 环境变量 < 命令行参数
+type Config struct {
+	Self      string        `json:"self"`                 // 本节点地址, 如http://localhost:8001
+	Listen    string        `json:"listen,omitempty"`     // 监听地址, 为空时使用self的host:port
+	API       string        `json:"api,omitempty"`        // API服务地址, 为空时不启动
+	Peers     []string      `json:"peers,omitempty"`      // 固定的节点列表
+	PeersFile string        `json:"peers_file,omitempty"` // 节点列表文件, 每行一个地址
+	Gossip    string        `json:"gossip,omitempty"`     // gossip监听地址, 如127.0.0.1:7001
+	Seeds     []string      `json:"seeds,omitempty"`      // gossip种子节点
+	Groups    []GroupConfig `json:"groups"`
+
+	ShutdownTimeout Duration `json:"shutdown_timeout,omitempty"` // 优雅退出的最长等待时间, 默认15s
+
+	SnapshotDir      string   `json:"snapshot_dir,omitempty"`      // 快照目录, 启动时从中恢复, 退出时写入
+	SnapshotInterval Duration `json:"snapshot_interval,omitempty"` // 定期快照的周期, 为0时只在退出时写入
+
+	WALDir  string `json:"wal_dir,omitempty"`  // 预写日志目录, 每个group使用其中的子目录, 为空时不记录, 需要同时配置snapshot_dir
+	WALSync string `json:"wal_sync,omitempty"` // fsync策略: always、everysec(默认)或never
+}
+
+type GroupConfig struct {
+	Name     string       `json:"name"`
+	Capacity int64        `json:"capacity,omitempty"` // 最大缓存数量, 为0时不限制
+	TTL      Duration     `json:"ttl,omitempty"`      // 过期时间, 为0时使用默认值
+	Source   SourceConfig `json:"source"`
+}
+
+// 数据源配置
+//
+//	static: 配置文件中的data
+//	file:   path指向的JSON文件, 内容为key到value的映射
+//	http:   GET url, url中的{key}替换为key, 404表示不存在
+type SourceConfig struct {
+	Type string            `json:"type"`
+	Data map[string]string `json:"data,omitempty"`
+	Path string            `json:"path,omitempty"`
+	URL  string            `json:"url,omitempty"`
+}
+
+const defaultShutdownTimeout = 15 * time.Second
+
+// JSON中以字符串表示的时间间隔, 如"10m"
+type Duration time.Duration
+
+func (d Duration) MarshalJSON() ([]byte, error) {
+	return json.Marshal(time.Duration(d).String())
+}
+
+func (d *Duration) UnmarshalJSON(b []byte) error {
+	var s string
+	if err := json.Unmarshal(b, &s); err != nil {
+		return fmt.Errorf("duration must be a string like \"10m\": %s", b)
+	}
+	v, err := time.ParseDuration(s)
+	if err != nil {
+		return err
+	}
+	*d = Duration(v)
+	return nil
+}
+
+// 没有配置文件时的默认配置, 三个本地节点和scores示例数据
+func defaultConfig() *Config {
+	return &Config{
+		Self: "http://localhost:8001",
+		Peers: []string{
+			"http://localhost:8001",
+			"http://localhost:8002",
+			"http://localhost:8003",
+		},
+		Groups: []GroupConfig{{
+			Name:     "scores",
+			Capacity: 2 << 10,
+			Source: SourceConfig{
+				Type: "static",
+				Data: map[string]string{
+					"Tom":  "630",
+					"Jack": "589",
+					"Sam":  "567",
+				},
+			},
+		}},
+	}
+}
+
+// 读取配置文件, path为空时使用默认配置, 之后应用环境变量
+func loadConfig(path string) (*Config, error) {
+	conf := defaultConfig()
+	if path != "" {
+		f, err := os.Open(path)
+		if err != nil {
+			return nil, err
+		}
+		defer f.Close()
+
+		conf = &Config{}
+		dec := json.NewDecoder(f)
+		dec.DisallowUnknownFields()
+		if err := dec.Decode(conf); err != nil {
+			return nil, fmt.Errorf("parse %s: %v", path, err)
+		}
+	}
+	conf.applyEnv(os.LookupEnv)
+	if conf.ShutdownTimeout == 0 {
+		conf.ShutdownTimeout = Duration(defaultShutdownTimeout)
+	}
+	return conf, nil
+}
+
+// 环境变量覆盖配置, 列表以逗号分隔
+func (c *Config) applyEnv(lookup func(string) (string, bool)) {
+	str := func(name string, dst *string) {
+		if v, ok := lookup(name); ok {
+			*dst = v
+		}
+	}
+	list := func(name string, dst *[]string) {
+		if v, ok := lookup(name); ok {
+			*dst = splitList(v)
+		}
+	}
+
+	str("GEECACHE_SELF", &c.Self)
+	str("GEECACHE_LISTEN", &c.Listen)
+	str("GEECACHE_API", &c.API)
+	list("GEECACHE_PEERS", &c.Peers)
+	str("GEECACHE_PEERS_FILE", &c.PeersFile)
+	str("GEECACHE_GOSSIP", &c.Gossip)
+	list("GEECACHE_SEEDS", &c.Seeds)
+	str("GEECACHE_SNAPSHOT_DIR", &c.SnapshotDir)
+	str("GEECACHE_WAL_DIR", &c.WALDir)
+	str("GEECACHE_WAL_SYNC", &c.WALSync)
+}
+
+func splitList(s string) []string {
+	var items []string
+	for _, item := range strings.Split(s, ",") {
+		if item = strings.TrimSpace(item); item != "" {
+			items = append(items, item)
+		}
+	}
+	return items
+}
+
+func (c *Config) validate() error {
+	if _, err := hostPort(c.Self); err != nil {
+		return fmt.Errorf("self: %v", err)
+	}
+	if c.API != "" {
+		if _, err := hostPort(c.API); err != nil {
+			return fmt.Errorf("api: %v", err)
+		}
+	}
+	var hasSelf bool
+	for _, peer := range c.Peers {
+		if _, err := hostPort(peer); err != nil {
+			return fmt.Errorf("peer %q: %v", peer, err)
+		}
+		hasSelf = hasSelf || strings.TrimSuffix(peer, "/") == strings.TrimSuffix(c.Self, "/")
+	}
+	// 固定的节点列表中没有自己时, 本节点不会负责任何key
+	if len(c.Peers) > 0 && !hasSelf {
+		return fmt.Errorf("peers must include self %s", c.Self)
+	}
+	var discoveries int
+	for _, set := range []bool{len(c.Peers) > 0, c.PeersFile != "", c.Gossip != ""} {
+		if set {
+			discoveries++
+		}
+	}
+	if discoveries > 1 {
+		return fmt.Errorf("only one of peers, peers_file and gossip can be set")
+	}
+
+	if c.ShutdownTimeout < 0 {
+		return fmt.Errorf("shutdown_timeout must not be negative")
+	}
+	if c.SnapshotInterval < 0 {
+		return fmt.Errorf("snapshot_interval must not be negative")
+	}
+	if c.SnapshotInterval > 0 && c.SnapshotDir == "" {
+		return fmt.Errorf("snapshot_interval requires snapshot_dir")
+	}
+	if c.WALDir != "" && c.SnapshotDir == "" {
+		return fmt.Errorf("wal_dir requires snapshot_dir")
+	}
+	if _, err := c.walConfig(""); err != nil {
+		return err
+	}
+	if len(c.Groups) == 0 {
+		return fmt.Errorf("at least one group is required")
+	}
+	names := make(map[string]bool, len(c.Groups))
+	for _, g := range c.Groups {
+		if g.Name == "" {
+			return fmt.Errorf("group name is required")
+		}
+		if names[g.Name] {
+			return fmt.Errorf("duplicate group %q", g.Name)
+		}
+		names[g.Name] = true
+		if g.Capacity < 0 {
+			return fmt.Errorf("group %s: capacity must not be negative", g.Name)
+		}
+		if g.TTL < 0 {
+			return fmt.Errorf("group %s: ttl must not be negative", g.Name)
+		}
+		if err := g.Source.validate(); err != nil {
+			return fmt.Errorf("group %s: %v", g.Name, err)
+		}
+	}
+	return nil
+}
+
+func (s SourceConfig) validate() error {
+	switch s.Type {
+	case "static":
+	case "file":
+		if s.Path == "" {
+			return fmt.Errorf("file source requires path")
+		}
+	case "http":
+		if !strings.Contains(s.URL, "{key}") {
+			return fmt.Errorf("http source url must contain {key}")
+		}
+	default:
+		return fmt.Errorf("unknown source type %q", s.Type)
+	}
+	return nil
+}
+
+// group的预写日志配置, 日志位于wal_dir下以group名称命名的子目录
+func (c *Config) walConfig(group string) (wal.Config, error) {
+	conf := wal.DefaultConfig(filepath.Join(c.WALDir, url.PathEscape(group)))
+	if c.WALSync != "" {
+		sync, err := wal.ParseSyncPolicy(c.WALSync)
+		if err != nil {
+			return conf, fmt.Errorf("wal_sync: %v", err)
+		}
+		conf.Sync = sync
+	}
+	return conf, nil
+}
+
+// 监听地址, 配置了listen时优先使用
+func (c *Config) listenAddr() string {
+	if c.Listen != "" {
+		return c.Listen
+	}
+	addr, _ := hostPort(c.Self)
+	return addr
+}
+
+// 从http://host:port形式的地址中取出host:port
+func hostPort(addr string) (string, error) {
+	u, err := url.Parse(addr)
+	if err != nil {
+		return "", err
+	}
+	if u.Scheme != "http" && u.Scheme != "https" || u.Host == "" {
+		return "", fmt.Errorf("address must look like http://host:port: %q", addr)
+	}
+	return u.Host, nil
+}
+
+// 按配置创建数据源
+func (s SourceConfig) getter() (func(key string) ([]byte, error), error) {
+	switch s.Type {
+	case "static":
+		return mapGetter(s.Data), nil
+	case "file":
+		b, err := os.ReadFile(s.Path)
+		if err != nil {
+			return nil, err
+		}
+		data := make(map[string]string)
+		if err := json.Unmarshal(b, &data); err != nil {
+			return nil, fmt.Errorf("parse %s: %v", s.Path, err)
+		}
+		return mapGetter(data), nil
+	case "http":
+		client := &http.Client{Timeout: 5 * time.Second}
+		return func(key string) ([]byte, error) {
+			res, err := client.Get(strings.ReplaceAll(s.URL, "{key}", url.QueryEscape(key)))
+			if err != nil {
+				return nil, err
+			}
+			defer res.Body.Close()
+			if res.StatusCode == http.StatusNotFound {
+				return nil, fmt.Errorf("%s not exist", key)
+			}
+			if res.StatusCode != http.StatusOK {
+				return nil, fmt.Errorf("source returned: %v", res.Status)
+			}
+			return io.ReadAll(res.Body)
+		}, nil
+	}
+	return nil, fmt.Errorf("unknown source type %q", s.Type)
+}
+
+func mapGetter(data map[string]string) func(key string) ([]byte, error) {
+	return func(key string) ([]byte, error) {
+		if v, ok := data[key]; ok {
+			return []byte(v), nil
+		}
+		return nil, fmt.Errorf("%s not exist", key)
+	}
+}
@@ -0,0 +1,108 @@
+package main
+
+import (
+	"encoding/json"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestApplyEnv(t *testing.T) {
+	env := map[string]string{
+		"GEECACHE_SELF":         "http://10.0.0.1:8001",
+		"GEECACHE_PEERS":        " http://10.0.0.1:8001, ,http://10.0.0.2:8001 ",
+		"GEECACHE_API":          "",
+		"GEECACHE_SNAPSHOT_DIR": "/var/lib/geecache",
+		"GEECACHE_WAL_SYNC":     "always",
+	}
+	conf := defaultConfig()
+	conf.API = "http://localhost:9999"
+	conf.applyEnv(func(name string) (string, bool) {
+		v, ok := env[name]
+		return v, ok
+	})
+
+	if conf.Self != "http://10.0.0.1:8001" {
+		t.Errorf("self = %s", conf.Self)
+	}
+	if expect := []string{"http://10.0.0.1:8001", "http://10.0.0.2:8001"}; !reflect.DeepEqual(conf.Peers, expect) {
+		t.Errorf("peers = %v, expect %v", conf.Peers, expect)
+	}
+	// 设置为空字符串的环境变量同样覆盖配置
+	if conf.API != "" {
+		t.Errorf("api = %s, expect cleared", conf.API)
+	}
+	if conf.SnapshotDir != "/var/lib/geecache" || conf.WALSync != "always" {
+		t.Errorf("snapshot_dir = %s, wal_sync = %s", conf.SnapshotDir, conf.WALSync)
+	}
+	// 未设置的环境变量不影响配置
+	if conf.Gossip != "" || len(conf.Groups) != 1 {
+		t.Errorf("unset variables changed the config: %+v", conf)
+	}
+}
+
+func TestValidate(t *testing.T) {
+	if err := defaultConfig().validate(); err != nil {
+		t.Fatalf("default config: %v", err)
+	}
+
+	cases := map[string]struct {
+		modify func(c *Config)
+		expect string
+	}{
+		"bad self":             {func(c *Config) { c.Self = "localhost:8001" }, "self"},
+		"bad peer":             {func(c *Config) { c.Peers = append(c.Peers, "10.0.0.2") }, "peer"},
+		"peers without self":   {func(c *Config) { c.Peers = c.Peers[1:] }, "include self"},
+		"two discoveries":      {func(c *Config) { c.Gossip = "127.0.0.1:7001" }, "only one"},
+		"interval without dir": {func(c *Config) { c.SnapshotInterval = Duration(time.Minute) }, "snapshot_dir"},
+		"bad wal sync":         {func(c *Config) { c.WALSync = "sometimes" }, "wal_sync"},
+		"wal without snapshot": {func(c *Config) { c.WALDir = "/var/lib/geecache/wal" }, "snapshot_dir"},
+		"no group":             {func(c *Config) { c.Groups = nil }, "at least one group"},
+		"duplicate group":      {func(c *Config) { c.Groups = append(c.Groups, c.Groups[0]) }, "duplicate"},
+		"negative ttl":         {func(c *Config) { c.Groups[0].TTL = -1 }, "ttl"},
+		"bad source":           {func(c *Config) { c.Groups[0].Source = SourceConfig{Type: "http", URL: "http://db"} }, "{key}"},
+	}
+	for name, tc := range cases {
+		conf := defaultConfig()
+		tc.modify(conf)
+		if err := conf.validate(); err == nil || !strings.Contains(err.Error(), tc.expect) {
+			t.Errorf("%s: expect error containing %q, got %v", name, tc.expect, err)
+		}
+	}
+
+	// 节点地址末尾的/不影响匹配
+	conf := defaultConfig()
+	conf.Peers[0] += "/"
+	if err := conf.validate(); err != nil {
+		t.Errorf("peer with trailing slash: %v", err)
+	}
+	// 使用其他发现方式时不检查
+	conf = defaultConfig()
+	conf.Peers, conf.PeersFile = nil, "peers.txt"
+	if err := conf.validate(); err != nil {
+		t.Errorf("peers file: %v", err)
+	}
+}
+
+func TestDurationJSON(t *testing.T) {
+	var conf GroupConfig
+	if err := json.Unmarshal([]byte(`{"name":"scores","ttl":"1m30s"}`), &conf); err != nil {
+		t.Fatal(err)
+	}
+	if time.Duration(conf.TTL) != 90*time.Second {
+		t.Fatalf("ttl = %v", time.Duration(conf.TTL))
+	}
+
+	b, err := json.Marshal(conf.TTL)
+	if err != nil || string(b) != `"1m30s"` {
+		t.Fatalf("marshal = %s, %v", b, err)
+	}
+
+	for _, bad := range []string{`90`, `"90"`, `"soon"`} {
+		var d Duration
+		if err := json.Unmarshal([]byte(bad), &d); err == nil {
+			t.Errorf("%s should be rejected, got %v", bad, time.Duration(d))
+		}
+	}
+}
@@ -1,50 +1,153 @@
 package main
 
 /*
-$ curl http://localhost:9999/_geecache/scores/Tom
+$ curl http://localhost:9999/api?key=Tom
 630
 
-$ curl http://localhost:9999/_geecache/scores/kkk
+$ curl "http://localhost:9999/api?group=scores&key=kkk"
 kkk not exist
+
+$ curl -X POST "http://localhost:8001/_geecache_admin/peers?peer=http://localhost:8004"
+["http://localhost:8001","http://localhost:8002","http://localhost:8003","http://localhost:8004"]
+
+$ curl "http://localhost:8001/_geecache_admin/ring?key=Tom&replicas=2"
+{"self":"http://localhost:8001","version":2,"members":[...],"healthy":[...],"shares":{...},"key":"Tom","owner":...,"replicas":[...]}
+
+$ curl -X PUT --data 700 "http://localhost:9999/api?key=Tom"
+$ curl -X DELETE "http://localhost:9999/api?key=Tom"
+
+$ go run ./cmd -config geecache.json -print-config
+$ GEECACHE_SELF=http://localhost:8002 go run ./cmd -config geecache.json
 */
 
 import (
+	"context"
+	"encoding/json"
 	"flag"
 	"fmt"
 	"geecache"
+	"geecache/discovery"
+	"geecache/membership"
+	"io"
 	"log"
 	"net/http"
+	"os"
+	"os/signal"
+	"strconv"
+	"syscall"
+	"time"
 )
 
-var db = map[string]string{
-	"Tom":  "630",
-	"Jack": "589",
-	"Sam":  "567",
-}
+// 按配置创建所有group, 并从快照和预写日志中恢复
+func createCacheGroups(conf *Config) []*geecache.CacheGroup {
+	if conf.SnapshotDir != "" {
+		if err := os.MkdirAll(conf.SnapshotDir, 0o755); err != nil {
+			log.Fatal(err)
+		}
+	}
 
-func createCacheGroup() *geecache.CacheGroup {
-	return geecache.NewGroup("scores", 2<<10, geecache.GetterFunc(
-		func(key string) ([]byte, error) {
-			log.Println("[SlowDB] search key", key)
-			if v, ok := db[key]; ok {
-				return []byte(v), nil
-			}
-			return nil, fmt.Errorf("%s not exist", key)
-		}))
+	groups := make([]*geecache.CacheGroup, 0, len(conf.Groups))
+	for _, gc := range conf.Groups {
+		get, err := gc.Source.getter()
+		if err != nil {
+			log.Fatalf("group %s: %v", gc.Name, err)
+		}
+		name := gc.Name
+		getter := geecache.GetterFunc(func(key string) ([]byte, error) {
+			log.Printf("[SlowDB] %s search key %s", name, key)
+			return get(key)
+		})
+
+		opts := []geecache.GroupOption{geecache.WithCapacity(gc.Capacity)}
+		if gc.TTL > 0 {
+			opts = append(opts, geecache.WithTTL(time.Duration(gc.TTL)))
+		}
+		if conf.SnapshotDir != "" {
+			opts = append(opts, geecache.WithSnapshotDir(conf.SnapshotDir, time.Duration(conf.SnapshotInterval)))
+		}
+		if conf.WALDir != "" {
+			walConf, _ := conf.walConfig(gc.Name)
+			opts = append(opts, geecache.WithWAL(walConf))
+		}
+		g, err := geecache.NewGroup(gc.Name, getter, opts...)
+		if err != nil {
+			log.Fatal(err)
+		}
+		if err := g.Recover(); err != nil {
+			log.Printf("group %s: recover: %v", gc.Name, err)
+		}
+		groups = append(groups, g)
+	}
+	return groups
 }
 
-func startCacheServer(addr string, addrs []string, gee *geecache.CacheGroup) {
-	server := geecache.NewHTTPPool(addr)
-	server.Set(addrs...)
-	gee.RegisterServer(server)
-	log.Println("geecache is running at", addr)
-	log.Fatal(http.ListenAndServe(addr[7:], server))
+// 按配置选择节点来源: gossip、节点列表文件或固定的节点列表, 返回的函数用于取消服务发现
+func newCachePool(conf *Config, groups []*geecache.CacheGroup) (*geecache.HTTPPool, func()) {
+	pool, err := geecache.NewHTTPPool(conf.Self)
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	var d discovery.Discovery
+	switch {
+	case conf.Gossip != "":
+		d = discovery.NewGossip(membership.DefaultConfig(conf.Self, conf.Gossip), conf.Seeds...)
+	case conf.PeersFile != "":
+		d = discovery.NewFile(conf.PeersFile, 0)
+	default:
+		pool.Set(conf.Peers...)
+	}
+	cancel := func() {}
+	if d != nil {
+		if cancel, err = pool.Subscribe(d); err != nil {
+			log.Fatal(err)
+		}
+	}
+
+	pool.StartHealthCheck(geecache.DefaultHealthConfig())
+	pool.EnableHandoff(geecache.DefaultHandoffConfig())
+	for _, g := range groups {
+		g.RegisterServer(pool)
+	}
+	return pool, cancel
 }
 
-func startAPIServer(apiAddr string, gee *geecache.CacheGroup) {
-	http.Handle("/api", http.HandlerFunc(
+// GET|PUT|DELETE /api?key=k[&group=g], group默认为第一个group, PUT的请求体为写入的值
+func newAPIServer(apiAddr string, groups []*geecache.CacheGroup) *http.Server {
+	listen, _ := hostPort(apiAddr)
+	mux := http.NewServeMux()
+	mux.Handle("/api", http.HandlerFunc(
 		func(w http.ResponseWriter, r *http.Request) {
+			gee := groups[0]
+			if name := r.URL.Query().Get("group"); name != "" {
+				if gee = geecache.GetCacheGroup(name); gee == nil {
+					http.Error(w, "no such group: "+name, http.StatusNotFound)
+					return
+				}
+			}
+
 			key := r.URL.Query().Get("key")
+			switch r.Method {
+			case http.MethodPut:
+				body, err := io.ReadAll(r.Body)
+				if err == nil {
+					err = gee.Set(key, body)
+				}
+				if err != nil {
+					http.Error(w, err.Error(), http.StatusInternalServerError)
+					return
+				}
+				w.WriteHeader(http.StatusNoContent)
+				return
+			case http.MethodDelete:
+				if err := gee.Delete(key); err != nil {
+					http.Error(w, err.Error(), http.StatusInternalServerError)
+					return
+				}
+				w.WriteHeader(http.StatusNoContent)
+				return
+			}
+
 			view, err := gee.GetCacheValue(key)
 			if err != nil {
 				http.Error(w, err.Error(), http.StatusInternalServerError)
@@ -52,35 +155,97 @@ func startAPIServer(apiAddr string, gee *geecache.CacheGroup) {
 			}
 
 			w.Header().Set("Content-Type", "application/octet-stream")
-			w.Write(view.ByteSlice())
+			view.WriteTo(w)
 		}))
-	log.Println("fontend server is running at", apiAddr)
-	log.Fatal(http.ListenAndServe(apiAddr[7:], nil))
+	return &http.Server{Addr: listen, Handler: mux}
+}
+
+func serve(name string, srv *http.Server) {
+	log.Printf("%s is running at %s", name, srv.Addr)
+	if err := srv.ListenAndServe(); err != nil && err != http.ErrServerClosed {
+		log.Fatal(err)
+	}
+}
+
+// 优雅退出: 通知其他节点并等待节点间请求完成, 退出服务发现, 停止监听, 最后关闭group
+func shutdown(conf *Config, pool *geecache.HTTPPool, cancel func(), servers []*http.Server, groups []*geecache.CacheGroup) {
+	ctx, done := context.WithTimeout(context.Background(), time.Duration(conf.ShutdownTimeout))
+	defer done()
+
+	if err := pool.Shutdown(ctx); err != nil {
+		log.Println(err)
+	}
+	cancel()
+	for _, srv := range servers {
+		if err := srv.Shutdown(ctx); err != nil {
+			log.Printf("shutdown %s: %v", srv.Addr, err)
+		}
+	}
+	for _, g := range groups {
+		if err := g.Close(); err != nil {
+			log.Println(err)
+		}
+	}
+	log.Println("geecache stopped")
 }
 
 func main() {
+	var configPath, gossip, seeds, peersFile string
 	var port int
-	var api bool
+	var api, printConfig bool
 
-	flag.IntVar(&port, "port", 8081, "Geecache server port")
-	flag.BoolVar(&api, "api", false, "Start a api server?")
+	flag.StringVar(&configPath, "config", "", "JSON config file, defaults to three local peers serving the scores group")
+	flag.BoolVar(&printConfig, "print-config", false, "Print the effective config and exit")
+	flag.IntVar(&port, "port", 0, "Geecache server port, overrides self as http://localhost:<port>")
+	flag.BoolVar(&api, "api", false, "Start a api server at http://localhost:9999 unless api is configured")
+	flag.StringVar(&gossip, "gossip", "", "Gossip bind address, e.g. 127.0.0.1:7001")
+	flag.StringVar(&seeds, "seeds", "", "Comma separated gossip seed addresses")
+	flag.StringVar(&peersFile, "peers-file", "", "File listing peer addresses, one per line")
 	flag.Parse()
 
-	apiAddr := "http://localhost:9999"
-	addrMap := map[int]string{
-		8001: "http://localhost:8001",
-		8002: "http://localhost:8002",
-		8003: "http://localhost:8003",
+	conf, err := loadConfig(configPath)
+	if err != nil {
+		log.Fatal(err)
+	}
+	if port != 0 {
+		conf.Self = "http://localhost:" + strconv.Itoa(port)
+		conf.Listen = ""
+	}
+	if api && conf.API == "" {
+		conf.API = "http://localhost:9999"
+	}
+	if gossip != "" {
+		conf.Gossip, conf.Peers = gossip, nil
+		conf.Seeds = splitList(seeds)
+	}
+	if peersFile != "" {
+		conf.PeersFile, conf.Peers = peersFile, nil
+	}
+	if err := conf.validate(); err != nil {
+		log.Fatalf("invalid config: %v", err)
 	}
 
-	var addrs []string
-	for _, v := range addrMap {
-		addrs = append(addrs, v)
+	if printConfig {
+		enc := json.NewEncoder(os.Stdout)
+		enc.SetIndent("", "  ")
+		if err := enc.Encode(conf); err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			os.Exit(1)
+		}
+		return
 	}
 
-	gee := createCacheGroup()
-	if api {
-		go startAPIServer(apiAddr, gee)
+	groups := createCacheGroups(conf)
+	pool, cancel := newCachePool(conf, groups)
+	servers := []*http.Server{{Addr: conf.listenAddr(), Handler: pool}}
+	go serve("geecache", servers[0])
+	if conf.API != "" {
+		servers = append(servers, newAPIServer(conf.API, groups))
+		go serve("fontend server", servers[1])
 	}
-	startCacheServer(addrMap[port], []string(addrs), gee)
+
+	sig := make(chan os.Signal, 1)
+	signal.Notify(sig, syscall.SIGINT, syscall.SIGTERM)
+	log.Printf("received %v, shutting down", <-sig)
+	shutdown(conf, pool, cancel, servers, groups)
 }
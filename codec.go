@@ -0,0 +1,74 @@
+package geecache
+
+import (
+	"bytes"
+	"encoding/gob"
+	"encoding/json"
+	"errors"
+	"fmt"
+)
+
+// 值的编解码器, protobuf、msgpack等可自行实现该接口
+type Codec interface {
+	// 编解码器名称, 写入缓存值的头部用于检测编解码器不一致
+	Name() string
+	Marshal(v any) ([]byte, error)
+	Unmarshal(data []byte, v any) error
+}
+
+var ErrCodecMismatch = errors.New("geecache: codec mismatch")
+
+type jsonCodec struct{}
+
+func (jsonCodec) Name() string                       { return "json" }
+func (jsonCodec) Marshal(v any) ([]byte, error)      { return json.Marshal(v) }
+func (jsonCodec) Unmarshal(data []byte, v any) error { return json.Unmarshal(data, v) }
+
+type gobCodec struct{}
+
+func (gobCodec) Name() string { return "gob" }
+
+func (gobCodec) Marshal(v any) ([]byte, error) {
+	var buf bytes.Buffer
+	if err := gob.NewEncoder(&buf).Encode(v); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+func (gobCodec) Unmarshal(data []byte, v any) error {
+	return gob.NewDecoder(bytes.NewReader(data)).Decode(v)
+}
+
+var (
+	JSONCodec Codec = jsonCodec{}
+	GobCodec  Codec = gobCodec{}
+)
+
+// 编码值并在头部记录编解码器名称: [名称长度(1字节)][名称][数据]
+func encodeValue(codec Codec, v any) ([]byte, error) {
+	name := codec.Name()
+	if len(name) == 0 || len(name) > 255 {
+		return nil, fmt.Errorf("geecache: invalid codec name %q", name)
+	}
+	data, err := codec.Marshal(v)
+	if err != nil {
+		return nil, err
+	}
+
+	b := make([]byte, 0, 1+len(name)+len(data))
+	b = append(b, byte(len(name)))
+	b = append(b, name...)
+	return append(b, data...), nil
+}
+
+// 解码值, 头部记录的编解码器与codec不一致时返回ErrCodecMismatch
+func decodeValue(codec Codec, b []byte, v any) error {
+	if len(b) == 0 || len(b) < 1+int(b[0]) {
+		return fmt.Errorf("%w: missing codec header", ErrCodecMismatch)
+	}
+	if name := string(b[1 : 1+b[0]]); name != codec.Name() {
+		return fmt.Errorf("%w: value encoded with %s, want %s", ErrCodecMismatch, name, codec.Name())
+	}
+	return codec.Unmarshal(b[1+b[0]:], v)
+}
@@ -0,0 +1,143 @@
+package geecache
+
+import (
+	"bytes"
+	"compress/flate"
+	"compress/gzip"
+	"io"
+	"strings"
+	"sync"
+	"sync/atomic"
+)
+
+// 值的压缩算法
+//
+// 内置gzip和deflate两种, 只依赖标准库; zstd、snappy需要第三方库, 未内置,
+// 可自行实现该接口并通过RegisterCompressor注册, 所有节点都需要注册同名的算法.
+type Compressor interface {
+	// 算法名称, 同时作为节点间传输时的Content-Encoding
+	Name() string
+	Compress(b []byte) ([]byte, error)
+	Decompress(b []byte) ([]byte, error)
+}
+
+type gzipCompressor struct{}
+
+func (gzipCompressor) Name() string { return "gzip" }
+
+func (gzipCompressor) Compress(b []byte) ([]byte, error) {
+	var buf bytes.Buffer
+	w := gzip.NewWriter(&buf)
+	if _, err := w.Write(b); err != nil {
+		return nil, err
+	}
+	if err := w.Close(); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+func (gzipCompressor) Decompress(b []byte) ([]byte, error) {
+	r, err := gzip.NewReader(bytes.NewReader(b))
+	if err != nil {
+		return nil, err
+	}
+	defer r.Close()
+	return io.ReadAll(r)
+}
+
+type flateCompressor struct{}
+
+func (flateCompressor) Name() string { return "deflate" }
+
+func (flateCompressor) Compress(b []byte) ([]byte, error) {
+	var buf bytes.Buffer
+	w, err := flate.NewWriter(&buf, flate.DefaultCompression)
+	if err != nil {
+		return nil, err
+	}
+	if _, err := w.Write(b); err != nil {
+		return nil, err
+	}
+	if err := w.Close(); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+func (flateCompressor) Decompress(b []byte) ([]byte, error) {
+	r := flate.NewReader(bytes.NewReader(b))
+	defer r.Close()
+	return io.ReadAll(r)
+}
+
+var (
+	GzipCompressor  Compressor = gzipCompressor{}
+	FlateCompressor Compressor = flateCompressor{}
+)
+
+var (
+	compressorsMu sync.RWMutex
+	compressors   = map[string]Compressor{
+		GzipCompressor.Name():  GzipCompressor,
+		FlateCompressor.Name(): FlateCompressor,
+	}
+)
+
+// 注册压缩算法, 节点间传输时按名称查找解压算法
+func RegisterCompressor(c Compressor) {
+	compressorsMu.Lock()
+	defer compressorsMu.Unlock()
+	compressors[c.Name()] = c
+}
+
+func compressorByName(name string) (Compressor, bool) {
+	compressorsMu.RLock()
+	defer compressorsMu.RUnlock()
+	c, ok := compressors[name]
+	return c, ok
+}
+
+// 已注册的压缩算法名称, 用作Accept-Encoding
+func acceptEncoding() string {
+	compressorsMu.RLock()
+	defer compressorsMu.RUnlock()
+	names := make([]string, 0, len(compressors))
+	for name := range compressors {
+		names = append(names, name)
+	}
+	return strings.Join(names, ", ")
+}
+
+// 压缩统计
+type CompressionStats struct {
+	Values      int64 // 写入缓存的值的数量
+	Compressed  int64 // 其中被压缩的数量
+	RawBytes    int64 // 原始字节数
+	StoredBytes int64 // 实际存储的字节数
+}
+
+type compressionStats struct {
+	values, compressed, rawBytes, storedBytes atomic.Int64
+}
+
+func (s *compressionStats) record(raw, stored int, compressed bool) {
+	s.values.Add(1)
+	s.rawBytes.Add(int64(raw))
+	s.storedBytes.Add(int64(stored))
+	if compressed {
+		s.compressed.Add(1)
+	}
+}
+
+// 值的大小达到threshold时进行压缩, 压缩后没有变小则保存原始值
+func compressView(c Compressor, threshold int, value ByteView) ByteView {
+	if c == nil || value.c != nil || len(value.b) < threshold {
+		return value
+	}
+	b, err := c.Compress(value.b)
+	if err != nil || len(b) >= len(value.b) {
+		return value
+	}
+	return ByteView{b: b, c: c}
+}
@@ -0,0 +1,97 @@
+package consistence
+
+import (
+	"math"
+	"sync"
+)
+
+// 有界负载一致性哈希(Mirrokni et al.), 每个节点的负载不超过平均负载的c倍
+//
+// 在哈希环上顺时针查找第一个未超载的节点, 负载为当前未结束的请求数,
+// 调用方需要在请求结束后调用Done.
+type BoundedLoad struct {
+	ring *Consistence
+	c    float64
+
+	mu    sync.Mutex
+	loads map[string]int64
+	total int64
+	count int // 真实节点数
+}
+
+func NewBoundedLoad(replicas int, c float64, fn Hash) *BoundedLoad {
+	if c < 1 {
+		c = 1.25
+	}
+	return &BoundedLoad{
+		ring:  NewMap(replicas, fn),
+		c:     c,
+		loads: make(map[string]int64),
+	}
+}
+
+func (b *BoundedLoad) AddNode(keys ...string) {
+	b.ring.AddNode(keys...)
+	b.count = len(b.ring.Nodes())
+}
+
+func (b *BoundedLoad) AddWeightedNode(key string, weight int) {
+	b.ring.AddWeightedNode(key, weight)
+	b.count = len(b.ring.Nodes())
+}
+
+func (b *BoundedLoad) RemoveNode(keys ...string) {
+	b.ring.RemoveNode(keys...)
+	b.count = len(b.ring.Nodes())
+}
+
+func (b *BoundedLoad) GetNode(key string) string {
+	ring := b.ring.ring
+	if len(ring) == 0 {
+		return ""
+	}
+
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	limit := int64(math.Ceil(b.c * float64(b.total+1) / float64(b.count)))
+	idx := b.ring.Search(b.ring.hash([]byte(key)))
+	for i := 0; i < len(ring); i++ {
+		node := ring[(idx+i)%len(ring)].node
+		if b.loads[node]+1 <= limit {
+			b.loads[node]++
+			b.total++
+			return node
+		}
+	}
+
+	// 不会发生: 总有节点的负载不超过平均值
+	node := ring[idx%len(ring)].node
+	b.loads[node]++
+	b.total++
+	return node
+}
+
+// 副本不受负载限制, 与哈希环相同
+func (b *BoundedLoad) GetNodes(key string, n int) []string {
+	return b.ring.GetNodes(key, n)
+}
+
+func (b *BoundedLoad) Done(node string) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	if b.loads[node] > 0 {
+		b.loads[node]--
+		b.total--
+	}
+}
+
+func (b *BoundedLoad) Nodes() []string {
+	return b.ring.Nodes()
+}
+
+// 不考虑负载时哈希环上的比例
+func (b *BoundedLoad) Shares() map[string]float64 {
+	return b.ring.Shares()
+}
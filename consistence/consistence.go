@@ -1,45 +1,125 @@
 package consistence
 
 import (
-	"hash/crc32"
 	"sort"
 	"strconv"
 )
 
-// 哈希函数, 默认为crc32.ChecksumIEEE算法
-type Hash func(data []byte) uint32
+// 哈希函数, 默认为DefaultHash(64位FNV-1a)
+type Hash func(data []byte) uint64
+
+// 虚拟节点
+type vnode struct {
+	hash uint64 // 在哈希环上的位置
+	node string // 对应的真实节点
+}
 
 // 一致性哈希算法的主数据结构
+//
+// 哈希环按(hash, node)排序, 哈希冲突的虚拟节点同时保留, 由节点名称决定先后,
+// 因此选择结果只取决于节点集合, 与添加顺序无关.
 type Consistence struct {
-	hash     Hash           // Hash函数
-	replicas int            // 虚拟节点倍数
-	ring     []int          // 哈希环(排序)
-	hashMap  map[int]string // 虚拟节点与真实节点的映射表
+	hash     Hash    // Hash函数
+	replicas int     // 虚拟节点倍数
+	ring     []vnode // 哈希环(排序)
 }
 
 func NewMap(replicas int, fn Hash) *Consistence {
 	m := &Consistence{
 		replicas: replicas,
 		hash:     fn,
-		hashMap:  make(map[int]string),
 	}
 	if m.hash == nil {
-		m.hash = crc32.ChecksumIEEE
+		m.hash = DefaultHash
 	}
 	return m
 }
 
+// 虚拟节点的名称, 序号在最后一个#之后, 不同节点的虚拟节点名称不会相同
+func vnodeLabel(key string, i int) []byte {
+	return []byte(key + "#" + strconv.Itoa(i))
+}
+
 // 添加真实节点
 func (m *Consistence) AddNode(keys ...string) {
 	for _, key := range keys {
-		for i := 0; i < m.replicas; i++ {
-			hash := int(m.hash([]byte(strconv.Itoa(i) + key)))
-			m.ring = append(m.ring, hash)
-			m.hashMap[hash] = key
+		m.addNode(key, 1)
+	}
+
+	m.sort()
+}
+
+// 添加带权重的真实节点, 虚拟节点数为replicas*weight, weight小于1时按1处理
+func (m *Consistence) AddWeightedNode(key string, weight int) {
+	m.addNode(key, weight)
+
+	m.sort()
+}
+
+func (m *Consistence) addNode(key string, weight int) {
+	if weight < 1 {
+		weight = 1
+	}
+	// 重复添加时先删除, 保证每个节点只有一组虚拟节点
+	m.removeNodes(map[string]struct{}{key: {}})
+	for i := 0; i < m.replicas*weight; i++ {
+		m.ring = append(m.ring, vnode{hash: m.hash(vnodeLabel(key, i)), node: key})
+	}
+}
+
+func (m *Consistence) sort() {
+	sort.Slice(m.ring, func(i, j int) bool {
+		if m.ring[i].hash != m.ring[j].hash {
+			return m.ring[i].hash < m.ring[j].hash
+		}
+		return m.ring[i].node < m.ring[j].node
+	})
+}
+
+// 删除真实节点及其全部虚拟节点
+func (m *Consistence) RemoveNode(keys ...string) {
+	removed := make(map[string]struct{}, len(keys))
+	for _, key := range keys {
+		removed[key] = struct{}{}
+	}
+	m.removeNodes(removed)
+}
+
+// 删除后哈希环仍然有序
+func (m *Consistence) removeNodes(removed map[string]struct{}) {
+	ring := m.ring[:0]
+	for _, v := range m.ring {
+		if _, ok := removed[v.node]; !ok {
+			ring = append(ring, v)
 		}
 	}
+	m.ring = ring
+}
+
+// 返回当前所有真实节点(排序)
+func (m *Consistence) Nodes() []string {
+	set := make(map[string]struct{})
+	for _, v := range m.ring {
+		set[v.node] = struct{}{}
+	}
 
-	sort.Ints(m.ring)
+	nodes := make([]string, 0, len(set))
+	for node := range set {
+		nodes = append(nodes, node)
+	}
+	sort.Strings(nodes)
+	return nodes
+}
+
+// 深拷贝, 用于在副本上修改后整体替换, 避免读到构建一半的哈希环
+func (m *Consistence) Clone() *Consistence {
+	c := &Consistence{
+		hash:     m.hash,
+		replicas: m.replicas,
+		ring:     make([]vnode, len(m.ring)),
+	}
+	copy(c.ring, m.ring)
+	return c
 }
 
 // 选择节点
@@ -48,28 +128,69 @@ func (m *Consistence) GetNode(key string) string {
 		return ""
 	}
 
-	hash := int(m.hash([]byte(key)))
+	hash := m.hash([]byte(key))
 	// 二分查找, 第一个大于或等于给定hash值的元素的索引
-	// idx := sort.Search(len(m.keys), func(i int) bool {
-	// 	return m.keys[i] >= hash
+	// idx := sort.Search(len(m.ring), func(i int) bool {
+	// 	return m.ring[i].hash >= hash
 	// })
 	idx := m.Search(hash)
 
-	return m.hashMap[m.ring[idx%len(m.ring)]]
+	return m.ring[idx%len(m.ring)].node
+}
+
+// 选择key的n个副本节点, 从key在哈希环上的位置开始顺时针查找不同的真实节点
+func (m *Consistence) GetNodes(key string, n int) []string {
+	if len(m.ring) == 0 || n <= 0 {
+		return nil
+	}
+
+	idx := m.Search(m.hash([]byte(key)))
+	seen := make(map[string]struct{}, n)
+	nodes := make([]string, 0, n)
+	for i := 0; i < len(m.ring) && len(nodes) < n; i++ {
+		node := m.ring[(idx+i)%len(m.ring)].node
+		if _, ok := seen[node]; ok {
+			continue
+		}
+		seen[node] = struct{}{}
+		nodes = append(nodes, node)
+	}
+	return nodes
 }
 
-func (m *Consistence) Search(hash int) int {
+// 每个真实节点负责的哈希环比例, 总和为1
+//
+// 虚拟节点负责从上一个虚拟节点(不含)到自己(含)的区间, 第一个虚拟节点负责跨越0点的区间.
+func (m *Consistence) Shares() map[string]float64 {
+	shares := make(map[string]float64)
+	if len(m.ring) == 0 {
+		return shares
+	}
+	if len(m.ring) == 1 {
+		shares[m.ring[0].node] = 1
+		return shares
+	}
+
+	const ringSize = float64(1<<63) * 2
+	for i, v := range m.ring {
+		prev := m.ring[(i+len(m.ring)-1)%len(m.ring)].hash
+		// uint64减法自然回绕, 正好是跨越0点的区间长度
+		shares[v.node] += float64(v.hash-prev) / ringSize
+	}
+	return shares
+}
+
+// 第一个大于或等于hash的虚拟节点的索引, 哈希冲突时返回其中的第一个
+func (m *Consistence) Search(hash uint64) int {
 	low, high := 0, len(m.ring)-1
 
 	for low <= high {
 		mid := low + (high-low)/2
 
-		if m.ring[mid] < hash {
+		if m.ring[mid].hash < hash {
 			low = mid + 1
-		} else if m.ring[mid] > hash {
-			high = mid - 1
 		} else {
-			return mid
+			high = mid - 1
 		}
 	}
 
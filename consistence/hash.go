@@ -0,0 +1,26 @@
+package consistence
+
+const (
+	fnvOffset64 = 14695981039346656037
+	fnvPrime64  = 1099511628211
+)
+
+// 默认哈希函数: 64位FNV-1a, 再经过splitmix64混合使高位分布均匀
+//
+// 不依赖平台和进程, 所有节点对同一数据计算的结果相同.
+func DefaultHash(data []byte) uint64 {
+	h := uint64(fnvOffset64)
+	for _, c := range data {
+		h ^= uint64(c)
+		h *= fnvPrime64
+	}
+	return mix64(h)
+}
+
+// splitmix64的混合函数
+func mix64(x uint64) uint64 {
+	x += 0x9e3779b97f4a7c15
+	x = (x ^ (x >> 30)) * 0xbf58476d1ce4e5b9
+	x = (x ^ (x >> 27)) * 0x94d049bb133111eb
+	return x ^ (x >> 31)
+}
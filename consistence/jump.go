@@ -0,0 +1,80 @@
+package consistence
+
+import (
+	"sort"
+)
+
+// Jump一致性哈希(Lamping & Veach), 不需要哈希环, 内存占用极小, 分布非常均匀
+//
+// 桶按节点名称排序, 与节点的添加顺序无关. 只有增删排在最后的节点时才能保证最少的key迁移,
+// 增删中间的节点会导致其后所有节点的key迁移. 带权重的节点占用weight个桶.
+type Jump struct {
+	hash    Hash
+	nodes   weightedNodes
+	buckets []string // 桶与真实节点的映射
+}
+
+func NewJump(fn Hash) *Jump {
+	if fn == nil {
+		fn = DefaultHash
+	}
+	return &Jump{hash: fn}
+}
+
+func (j *Jump) AddNode(keys ...string) {
+	for _, key := range keys {
+		j.nodes.add(key, 1)
+	}
+	j.build()
+}
+
+func (j *Jump) AddWeightedNode(key string, weight int) {
+	j.nodes.add(key, weight)
+	j.build()
+}
+
+func (j *Jump) RemoveNode(keys ...string) {
+	if j.nodes.remove(keys...) {
+		j.build()
+	}
+}
+
+func (j *Jump) build() {
+	names := j.nodes.nodes()
+	sort.Strings(names)
+	buckets := make([]string, 0, len(names))
+	for _, name := range names {
+		for i := 0; i < j.nodes.weights[name]; i++ {
+			buckets = append(buckets, name)
+		}
+	}
+	j.buckets = buckets
+}
+
+func (j *Jump) GetNode(key string) string {
+	if len(j.buckets) == 0 {
+		return ""
+	}
+	return j.buckets[jumpHash(j.hash([]byte(key)), len(j.buckets))]
+}
+
+func (j *Jump) Nodes() []string {
+	nodes := j.nodes.nodes()
+	sort.Strings(nodes)
+	return nodes
+}
+
+func jumpHash(key uint64, buckets int) int {
+	var b, i int64 = -1, 0
+	for i < int64(buckets) {
+		b = i
+		key = key*2862933555777941757 + 1
+		i = int64(float64(b+1) * (float64(int64(1)<<31) / float64((key>>33)+1)))
+	}
+	return int(b)
+}
+
+// 每个桶负责相同比例的key, 节点比例与权重成正比
+func (j *Jump) Shares() map[string]float64 {
+	return j.nodes.shares()
+}
@@ -0,0 +1,167 @@
+package consistence
+
+import (
+	"sort"
+	"sync"
+	"sync/atomic"
+)
+
+// 默认查找表大小, 必须为质数且远大于节点数
+const DefaultMaglevTableSize = 65537
+
+// Maglev哈希, 根据每个节点的排列填充固定大小的查找表, 选择的复杂度为O(1)
+//
+// 查找表在增删节点后的第一次选择时重建.
+type Maglev struct {
+	hash  Hash
+	size  int
+	nodes weightedNodes
+
+	mu    sync.Mutex
+	table atomic.Pointer[[]string]
+}
+
+// size为查找表大小, 为0时使用默认值, 不是质数时向上取最近的质数
+func NewMaglev(size int, fn Hash) *Maglev {
+	if size <= 0 {
+		size = DefaultMaglevTableSize
+	}
+	size = nextPrime(size)
+	if fn == nil {
+		fn = DefaultHash
+	}
+	return &Maglev{hash: fn, size: size}
+}
+
+func (m *Maglev) AddNode(keys ...string) {
+	for _, key := range keys {
+		m.nodes.add(key, 1)
+	}
+	m.table.Store(nil)
+}
+
+func (m *Maglev) AddWeightedNode(key string, weight int) {
+	m.nodes.add(key, weight)
+	m.table.Store(nil)
+}
+
+func (m *Maglev) RemoveNode(keys ...string) {
+	if m.nodes.remove(keys...) {
+		m.table.Store(nil)
+	}
+}
+
+func (m *Maglev) GetNode(key string) string {
+	if len(m.nodes.names) == 0 {
+		return ""
+	}
+	table := m.lookupTable()
+	return table[m.hash([]byte(key))%uint64(len(table))]
+}
+
+// 从key在查找表中的位置开始向后查找n个不同的节点
+func (m *Maglev) GetNodes(key string, n int) []string {
+	if len(m.nodes.names) == 0 || n <= 0 {
+		return nil
+	}
+
+	table := m.lookupTable()
+	idx := int(m.hash([]byte(key)) % uint64(len(table)))
+	seen := make(map[string]struct{}, n)
+	nodes := make([]string, 0, n)
+	for i := 0; i < len(table) && len(nodes) < n && len(nodes) < len(m.nodes.names); i++ {
+		node := table[(idx+i)%len(table)]
+		if _, ok := seen[node]; ok {
+			continue
+		}
+		seen[node] = struct{}{}
+		nodes = append(nodes, node)
+	}
+	return nodes
+}
+
+func (m *Maglev) lookupTable() []string {
+	if t := m.table.Load(); t != nil {
+		return *t
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if t := m.table.Load(); t != nil {
+		return *t
+	}
+	t := m.populate()
+	m.table.Store(&t)
+	return t
+}
+
+// 按论文中的算法填充查找表, 权重为w的节点每轮填充w个位置
+func (m *Maglev) populate() []string {
+	names := m.nodes.nodes()
+	sort.Strings(names)
+
+	n := len(names)
+	offset := make([]int, n)
+	skip := make([]int, n)
+	next := make([]int, n)
+	for i, name := range names {
+		offset[i] = int(m.hash([]byte("offset\x00"+name)) % uint64(m.size))
+		skip[i] = int(m.hash([]byte("skip\x00"+name))%uint64(m.size-1)) + 1
+	}
+
+	table := make([]string, m.size)
+	filled := 0
+	for filled < m.size {
+		for i, name := range names {
+			for w := 0; w < m.nodes.weights[name] && filled < m.size; w++ {
+				c := (offset[i] + next[i]*skip[i]) % m.size
+				for table[c] != "" {
+					next[i]++
+					c = (offset[i] + next[i]*skip[i]) % m.size
+				}
+				table[c] = name
+				next[i]++
+				filled++
+			}
+		}
+	}
+	return table
+}
+
+// 大于等于n的最小质数, 保证每个节点的探测序列能遍历整个查找表
+func nextPrime(n int) int {
+	if n <= 2 {
+		return 2
+	}
+	for ; ; n++ {
+		prime := true
+		for d := 2; d*d <= n; d++ {
+			if n%d == 0 {
+				prime = false
+				break
+			}
+		}
+		if prime {
+			return n
+		}
+	}
+}
+
+func (m *Maglev) Nodes() []string {
+	nodes := m.nodes.nodes()
+	sort.Strings(nodes)
+	return nodes
+}
+
+// 查找表中每个节点所占的比例
+func (m *Maglev) Shares() map[string]float64 {
+	shares := make(map[string]float64)
+	if len(m.nodes.names) == 0 {
+		return shares
+	}
+	table := m.lookupTable()
+	for _, node := range table {
+		shares[node] += 1 / float64(len(table))
+	}
+	return shares
+}
@@ -0,0 +1,106 @@
+package consistence
+
+// 节点选择算法, 根据key选择对应的真实节点
+//
+// 实现只需在添加/删除节点时保证单协程调用, GetNode/Nodes可以并发调用.
+type Picker interface {
+	AddNode(keys ...string)
+	AddWeightedNode(key string, weight int)
+	RemoveNode(keys ...string)
+	GetNode(key string) string
+	Nodes() []string
+}
+
+// 支持多副本的选择算法
+type ReplicaPicker interface {
+	// 按优先级返回key的n个不同的副本节点, 第一个与GetNode的结果相同(有界负载除外)
+	GetNodes(key string, n int) []string
+}
+
+// 可以统计节点负责比例的选择算法
+type ShareReporter interface {
+	// 每个节点负责的key空间比例, 总和为1
+	Shares() map[string]float64
+}
+
+// 需要感知请求结束的选择算法, 如有界负载一致性哈希
+type LoadReporter interface {
+	// GetNode选中的节点处理完请求后调用
+	Done(node string)
+}
+
+var (
+	_ Picker       = (*Consistence)(nil)
+	_ Picker       = (*Jump)(nil)
+	_ Picker       = (*Rendezvous)(nil)
+	_ Picker       = (*Maglev)(nil)
+	_ Picker       = (*BoundedLoad)(nil)
+	_ LoadReporter = (*BoundedLoad)(nil)
+
+	_ ReplicaPicker = (*Consistence)(nil)
+	_ ReplicaPicker = (*Rendezvous)(nil)
+	_ ReplicaPicker = (*Maglev)(nil)
+	_ ReplicaPicker = (*BoundedLoad)(nil)
+
+	_ ShareReporter = (*Consistence)(nil)
+	_ ShareReporter = (*Jump)(nil)
+	_ ShareReporter = (*Rendezvous)(nil)
+	_ ShareReporter = (*Maglev)(nil)
+	_ ShareReporter = (*BoundedLoad)(nil)
+)
+
+// 有权重的节点列表, 供各算法复用
+type weightedNodes struct {
+	names   []string
+	weights map[string]int
+}
+
+func (w *weightedNodes) add(key string, weight int) {
+	if weight < 1 {
+		weight = 1
+	}
+	if w.weights == nil {
+		w.weights = make(map[string]int)
+	}
+	if _, ok := w.weights[key]; !ok {
+		w.names = append(w.names, key)
+	}
+	w.weights[key] = weight
+}
+
+func (w *weightedNodes) remove(keys ...string) bool {
+	changed := false
+	for _, key := range keys {
+		if _, ok := w.weights[key]; !ok {
+			continue
+		}
+		delete(w.weights, key)
+		for i, name := range w.names {
+			if name == key {
+				w.names = append(w.names[:i], w.names[i+1:]...)
+				break
+			}
+		}
+		changed = true
+	}
+	return changed
+}
+
+// 按权重计算的比例, 适用于分布与权重成正比的算法
+func (w *weightedNodes) shares() map[string]float64 {
+	total := 0
+	for _, weight := range w.weights {
+		total += weight
+	}
+	shares := make(map[string]float64, len(w.weights))
+	for name, weight := range w.weights {
+		shares[name] = float64(weight) / float64(total)
+	}
+	return shares
+}
+
+func (w *weightedNodes) nodes() []string {
+	nodes := make([]string, len(w.names))
+	copy(nodes, w.names)
+	return nodes
+}
@@ -0,0 +1,101 @@
+package consistence
+
+import (
+	"math"
+	"sort"
+)
+
+// Rendezvous哈希(HRW), 对每个节点计算hash(node, key), 得分最高的节点胜出
+//
+// 增删节点只影响该节点的key, 但每次选择的复杂度为O(n).
+type Rendezvous struct {
+	hash  Hash
+	nodes weightedNodes
+}
+
+func NewRendezvous(fn Hash) *Rendezvous {
+	if fn == nil {
+		fn = DefaultHash
+	}
+	return &Rendezvous{hash: fn}
+}
+
+func (r *Rendezvous) AddNode(keys ...string) {
+	for _, key := range keys {
+		r.nodes.add(key, 1)
+	}
+}
+
+func (r *Rendezvous) AddWeightedNode(key string, weight int) {
+	r.nodes.add(key, weight)
+}
+
+func (r *Rendezvous) RemoveNode(keys ...string) {
+	r.nodes.remove(keys...)
+}
+
+func (r *Rendezvous) GetNode(key string) string {
+	var best string
+	bestScore := math.Inf(-1)
+	h := r.hash([]byte(key))
+	for _, node := range r.nodes.names {
+		score := r.score(node, h)
+		if score > bestScore || (score == bestScore && node < best) {
+			best, bestScore = node, score
+		}
+	}
+	return best
+}
+
+// 得分最高的n个节点
+func (r *Rendezvous) GetNodes(key string, n int) []string {
+	if n <= 0 {
+		return nil
+	}
+
+	type scored struct {
+		node  string
+		score float64
+	}
+	h := r.hash([]byte(key))
+	all := make([]scored, 0, len(r.nodes.names))
+	for _, node := range r.nodes.names {
+		all = append(all, scored{node, r.score(node, h)})
+	}
+	sort.Slice(all, func(i, j int) bool {
+		if all[i].score != all[j].score {
+			return all[i].score > all[j].score
+		}
+		return all[i].node < all[j].node
+	})
+
+	if len(all) > n {
+		all = all[:n]
+	}
+	nodes := make([]string, 0, len(all))
+	for _, s := range all {
+		nodes = append(nodes, s.node)
+	}
+	return nodes
+}
+
+// 带权重的得分: -weight / ln(u), u为(0,1)之间的均匀分布
+//
+// crc32等线性哈希函数直接计算hash(node+key)会使不同节点的得分高度相关,
+// 因此分别计算后再经过splitmix64混合.
+func (r *Rendezvous) score(node string, keyHash uint64) float64 {
+	h := mix64(keyHash ^ mix64(r.hash([]byte(node))))
+	u := (float64(h>>11) + 0.5) / (1 << 53)
+	return -float64(r.nodes.weights[node]) / math.Log(u)
+}
+
+func (r *Rendezvous) Nodes() []string {
+	nodes := r.nodes.nodes()
+	sort.Strings(nodes)
+	return nodes
+}
+
+// 带权重的HRW中节点比例与权重成正比
+func (r *Rendezvous) Shares() map[string]float64 {
+	return r.nodes.shares()
+}
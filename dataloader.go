@@ -0,0 +1,114 @@
+package geecache
+
+import (
+	"fmt"
+	"sync"
+	"time"
+)
+
+const (
+	defaultBatchWindow = 2 * time.Millisecond
+	defaultMaxBatch    = 100
+)
+
+// 支持批量加载的数据源, Getter同时实现该接口时, 未命中的key会合并后批量加载
+type BatchGetter interface {
+	// 批量获取数据, 返回结果中不存在的key视为获取失败
+	GetMany(keys []string) (map[string][]byte, error)
+}
+
+type batchResult struct {
+	value []byte
+	err   error
+}
+
+// 合并一段时间内的加载请求, 调用一次BatchGetter.GetMany(dataloader)
+type batchLoader struct {
+	getter   BatchGetter
+	window   time.Duration // 等待更多请求的时间
+	maxBatch int           // 达到该数量时立即加载
+
+	mu      sync.Mutex
+	pending map[string][]chan batchResult // 等待中的请求, 同一个key只加载一次
+	timer   *time.Timer
+}
+
+func newBatchLoader(getter BatchGetter, window time.Duration, maxBatch int) *batchLoader {
+	if window <= 0 {
+		window = defaultBatchWindow
+	}
+	if maxBatch <= 0 {
+		maxBatch = defaultMaxBatch
+	}
+	return &batchLoader{
+		getter:   getter,
+		window:   window,
+		maxBatch: maxBatch,
+		pending:  make(map[string][]chan batchResult),
+	}
+}
+
+func (b *batchLoader) load(key string) ([]byte, error) {
+	ch := make(chan batchResult, 1)
+
+	b.mu.Lock()
+	b.pending[key] = append(b.pending[key], ch)
+	if len(b.pending) >= b.maxBatch {
+		batch := b.take()
+		b.mu.Unlock()
+		go b.run(batch)
+	} else {
+		if b.timer == nil {
+			b.timer = time.AfterFunc(b.window, b.flush)
+		}
+		b.mu.Unlock()
+	}
+
+	r := <-ch
+	return r.value, r.err
+}
+
+// 取出当前所有等待中的请求, 调用方需持有b.mu
+func (b *batchLoader) take() map[string][]chan batchResult {
+	batch := b.pending
+	b.pending = make(map[string][]chan batchResult)
+	if b.timer != nil {
+		b.timer.Stop()
+		b.timer = nil
+	}
+	return batch
+}
+
+func (b *batchLoader) flush() {
+	b.mu.Lock()
+	batch := b.take()
+	b.mu.Unlock()
+
+	b.run(batch)
+}
+
+func (b *batchLoader) run(batch map[string][]chan batchResult) {
+	if len(batch) == 0 {
+		return
+	}
+
+	keys := make([]string, 0, len(batch))
+	for key := range batch {
+		keys = append(keys, key)
+	}
+	values, err := b.getter.GetMany(keys)
+
+	for key, waiters := range batch {
+		r := batchResult{err: err}
+		if err == nil {
+			if v, ok := values[key]; ok {
+				r.value = v
+			} else {
+				r.err = fmt.Errorf("%s not found", key)
+			}
+		}
+		for _, ch := range waiters {
+			ch <- r
+		}
+	}
+}
@@ -0,0 +1,80 @@
+package geecache
+
+import (
+	"geecache/discovery"
+	"sync"
+	"time"
+)
+
+const defaultDebounce = 200 * time.Millisecond
+
+// 订阅服务发现, 节点列表变化在debounce时间内合并后再更新哈希环
+type subscription struct {
+	pool     *HTTPPool
+	d        discovery.Discovery
+	debounce time.Duration
+
+	mu      sync.Mutex
+	timer   *time.Timer
+	pending []string
+	closed  bool
+}
+
+// 订阅服务发现, 返回的函数用于取消订阅
+//
+// 发现的节点列表中没有自身时自动加入自身.
+func (p *HTTPPool) Subscribe(d discovery.Discovery) (func(), error) {
+	s := &subscription{pool: p, d: d, debounce: defaultDebounce}
+	if err := d.Watch(s.notify); err != nil {
+		return nil, err
+	}
+	return s.close, nil
+}
+
+func (s *subscription) notify(peers []string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.closed {
+		return
+	}
+	s.pending = peers
+	if s.timer == nil {
+		s.timer = time.AfterFunc(s.debounce, s.flush)
+	}
+}
+
+func (s *subscription) flush() {
+	s.mu.Lock()
+	peers := s.pending
+	s.timer = nil
+	closed := s.closed
+	s.mu.Unlock()
+
+	if !closed {
+		s.pool.SetPeers(s.withSelf(s.pool.parsePeers(peers))...)
+	}
+}
+
+// 节点列表中没有自身时加入自身, 与固定节点列表必须包含自身的要求一致,
+// 否则本节点不在哈希环中, 不会负责任何key; peers需要已经规范化
+func (s *subscription) withSelf(peers []string) []string {
+	for _, peer := range peers {
+		if peer == s.pool.self {
+			return peers
+		}
+	}
+	s.pool.Log("Discovered peers %v do not include self, adding %s", peers, s.pool.self)
+	return append(append(make([]string, 0, len(peers)+1), peers...), s.pool.self)
+}
+
+func (s *subscription) close() {
+	s.mu.Lock()
+	s.closed = true
+	if s.timer != nil {
+		s.timer.Stop()
+	}
+	s.mu.Unlock()
+
+	s.d.Close()
+}
@@ -0,0 +1,61 @@
+package discovery
+
+import (
+	"sort"
+)
+
+// 服务发现, 节点列表发生变化时通知订阅者
+type Discovery interface {
+	// 开始监听, 每次节点列表发生变化时以完整的节点列表调用notify, 首次获取到列表时也会调用
+	Watch(notify func(peers []string)) error
+	// 停止监听
+	Close() error
+}
+
+// 排序去重, 便于比较两次结果是否相同
+func normalize(peers []string) []string {
+	set := make(map[string]struct{}, len(peers))
+	out := make([]string, 0, len(peers))
+	for _, peer := range peers {
+		if _, ok := set[peer]; ok || peer == "" {
+			continue
+		}
+		set[peer] = struct{}{}
+		out = append(out, peer)
+	}
+	sort.Strings(out)
+	return out
+}
+
+func equal(a, b []string) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i := range a {
+		if a[i] != b[i] {
+			return false
+		}
+	}
+	return true
+}
+
+// 比较新旧节点列表, 返回新增和删除的节点
+func Diff(old, new []string) (added, removed []string) {
+	oldSet := make(map[string]struct{}, len(old))
+	for _, peer := range old {
+		oldSet[peer] = struct{}{}
+	}
+	newSet := make(map[string]struct{}, len(new))
+	for _, peer := range new {
+		newSet[peer] = struct{}{}
+		if _, ok := oldSet[peer]; !ok {
+			added = append(added, peer)
+		}
+	}
+	for _, peer := range old {
+		if _, ok := newSet[peer]; !ok {
+			removed = append(removed, peer)
+		}
+	}
+	return
+}
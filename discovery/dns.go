@@ -0,0 +1,108 @@
+package discovery
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"net"
+	"strings"
+	"sync"
+	"time"
+)
+
+// SRV记录查询函数, 签名与net.Resolver.LookupSRV一致, 便于测试时替换
+type LookupSRVFunc func(ctx context.Context, service, proto, name string) (string, []*net.SRV, error)
+
+// 基于DNS SRV记录的服务发现, 如_geecache._tcp.example.com
+//
+// 定期查询SRV记录, 每条记录转换为 scheme://target:port 形式的节点地址
+type DNSSRV struct {
+	service  string
+	proto    string
+	name     string
+	scheme   string
+	interval time.Duration
+	Lookup   LookupSRVFunc // 默认为net.DefaultResolver.LookupSRV
+
+	stopCh   chan struct{}
+	stopOnce sync.Once
+	wg       sync.WaitGroup
+}
+
+func NewDNSSRV(service, proto, name, scheme string, interval time.Duration) *DNSSRV {
+	if interval <= 0 {
+		interval = 30 * time.Second
+	}
+	if scheme == "" {
+		scheme = "http"
+	}
+	return &DNSSRV{
+		service:  service,
+		proto:    proto,
+		name:     name,
+		scheme:   scheme,
+		interval: interval,
+		Lookup:   net.DefaultResolver.LookupSRV,
+		stopCh:   make(chan struct{}),
+	}
+}
+
+func (d *DNSSRV) resolve() ([]string, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), d.interval)
+	defer cancel()
+
+	_, records, err := d.Lookup(ctx, d.service, d.proto, d.name)
+	if err != nil {
+		return nil, err
+	}
+
+	peers := make([]string, 0, len(records))
+	for _, srv := range records {
+		target := strings.TrimSuffix(srv.Target, ".")
+		peers = append(peers, fmt.Sprintf("%s://%s:%d", d.scheme, target, srv.Port))
+	}
+	return normalize(peers), nil
+}
+
+func (d *DNSSRV) Watch(notify func(peers []string)) error {
+	last, err := d.resolve()
+	if err != nil {
+		return err
+	}
+	notify(last)
+
+	d.wg.Add(1)
+	go func() {
+		defer d.wg.Done()
+
+		ticker := time.NewTicker(d.interval)
+		defer ticker.Stop()
+
+		for {
+			select {
+			case <-ticker.C:
+				// 查询失败时保留上一次的结果, 避免DNS抖动导致节点被全部移除
+				peers, err := d.resolve()
+				if err != nil {
+					log.Println("[Discovery] lookup SRV failed:", err)
+					continue
+				}
+				if !equal(peers, last) {
+					last = peers
+					notify(peers)
+				}
+			case <-d.stopCh:
+				return
+			}
+		}
+	}()
+	return nil
+}
+
+func (d *DNSSRV) Close() error {
+	d.stopOnce.Do(func() { close(d.stopCh) })
+	d.wg.Wait()
+	return nil
+}
+
+var _ Discovery = (*DNSSRV)(nil)
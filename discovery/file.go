@@ -0,0 +1,90 @@
+package discovery
+
+import (
+	"bufio"
+	"bytes"
+	"os"
+	"strings"
+	"sync"
+	"time"
+)
+
+// 基于静态文件的服务发现, 文件每行一个节点地址, #开头的行为注释
+//
+// 定期检查文件内容, 发生变化时通知订阅者
+type File struct {
+	path     string
+	interval time.Duration
+
+	stopCh   chan struct{}
+	stopOnce sync.Once
+	wg       sync.WaitGroup
+}
+
+func NewFile(path string, interval time.Duration) *File {
+	if interval <= 0 {
+		interval = time.Second
+	}
+	return &File{
+		path:     path,
+		interval: interval,
+		stopCh:   make(chan struct{}),
+	}
+}
+
+func (f *File) Watch(notify func(peers []string)) error {
+	content, err := os.ReadFile(f.path)
+	if err != nil {
+		return err
+	}
+	last := parsePeers(content)
+	notify(last)
+
+	f.wg.Add(1)
+	go func() {
+		defer f.wg.Done()
+
+		ticker := time.NewTicker(f.interval)
+		defer ticker.Stop()
+
+		for {
+			select {
+			case <-ticker.C:
+				// 文件暂时不可读(如正在被替换)时保留上一次的结果
+				next, err := os.ReadFile(f.path)
+				if err != nil || bytes.Equal(next, content) {
+					continue
+				}
+				content = next
+				if peers := parsePeers(content); !equal(peers, last) {
+					last = peers
+					notify(peers)
+				}
+			case <-f.stopCh:
+				return
+			}
+		}
+	}()
+	return nil
+}
+
+func (f *File) Close() error {
+	f.stopOnce.Do(func() { close(f.stopCh) })
+	f.wg.Wait()
+	return nil
+}
+
+func parsePeers(content []byte) []string {
+	var peers []string
+	scanner := bufio.NewScanner(bytes.NewReader(content))
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		peers = append(peers, line)
+	}
+	return normalize(peers)
+}
+
+var _ Discovery = (*File)(nil)
@@ -0,0 +1,77 @@
+package discovery
+
+import (
+	"geecache/membership"
+	"sync"
+)
+
+// 基于gossip成员协议的服务发现
+type Gossip struct {
+	conf  membership.Config
+	seeds []string
+
+	mu     sync.Mutex
+	list   *membership.Memberlist
+	notify func(peers []string)
+}
+
+// conf中的OnJoin/OnLeave回调会被覆盖
+func NewGossip(conf membership.Config, seeds ...string) *Gossip {
+	return &Gossip{conf: conf, seeds: seeds}
+}
+
+func (g *Gossip) Watch(notify func(peers []string)) error {
+	g.notify = notify
+	g.conf.OnJoin = func(membership.Member) { g.changed() }
+	g.conf.OnLeave = func(membership.Member) { g.changed() }
+
+	list, err := membership.Create(g.conf)
+	if err != nil {
+		return err
+	}
+	g.mu.Lock()
+	g.list = list
+	g.mu.Unlock()
+
+	if len(g.seeds) > 0 {
+		if err := list.Join(g.seeds...); err != nil {
+			list.Shutdown()
+			return err
+		}
+	}
+	g.changed()
+	return nil
+}
+
+// 成员列表
+func (g *Gossip) Memberlist() *membership.Memberlist {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	return g.list
+}
+
+func (g *Gossip) changed() {
+	list := g.Memberlist()
+	if list == nil {
+		return
+	}
+
+	members := list.Members()
+	peers := make([]string, 0, len(members))
+	for _, m := range members {
+		peers = append(peers, m.Name)
+	}
+	g.notify(normalize(peers))
+}
+
+// 通知其他节点后退出
+func (g *Gossip) Close() error {
+	list := g.Memberlist()
+	if list == nil {
+		return nil
+	}
+	list.Leave()
+	return list.Shutdown()
+}
+
+var _ Discovery = (*Gossip)(nil)
@@ -0,0 +1,198 @@
+package discovery
+
+import (
+	"context"
+	"log"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// etcd/Consul风格的键值存储抽象
+type KV interface {
+	// 返回prefix下的所有键值对, 以及当前的版本号
+	List(ctx context.Context, prefix string) (map[string]string, uint64, error)
+	// 阻塞直到prefix下发生版本号大于index的变更, 返回新的版本号
+	Watch(ctx context.Context, prefix string, index uint64) (uint64, error)
+}
+
+// 基于键值存储的服务发现, prefix下每个键值对的值为一个节点地址
+type KVDiscovery struct {
+	store  KV
+	prefix string
+
+	ctx    context.Context
+	cancel context.CancelFunc
+	wg     sync.WaitGroup
+}
+
+func NewKV(store KV, prefix string) *KVDiscovery {
+	ctx, cancel := context.WithCancel(context.Background())
+	return &KVDiscovery{
+		store:  store,
+		prefix: prefix,
+		ctx:    ctx,
+		cancel: cancel,
+	}
+}
+
+func (d *KVDiscovery) list() ([]string, uint64, error) {
+	pairs, index, err := d.store.List(d.ctx, d.prefix)
+	if err != nil {
+		return nil, 0, err
+	}
+	peers := make([]string, 0, len(pairs))
+	for _, v := range pairs {
+		peers = append(peers, v)
+	}
+	return normalize(peers), index, nil
+}
+
+func (d *KVDiscovery) Watch(notify func(peers []string)) error {
+	last, index, err := d.list()
+	if err != nil {
+		return err
+	}
+	notify(last)
+
+	d.wg.Add(1)
+	go func() {
+		defer d.wg.Done()
+
+		for {
+			next, err := d.store.Watch(d.ctx, d.prefix, index)
+			if d.ctx.Err() != nil {
+				return
+			}
+			if err != nil {
+				log.Println("[Discovery] watch failed:", err)
+				if !d.backoff() {
+					return
+				}
+				continue
+			}
+
+			peers, latest, err := d.list()
+			if d.ctx.Err() != nil {
+				return
+			}
+			// index未更新, 不等待的话下一次Watch会立即返回
+			if err != nil {
+				log.Println("[Discovery] list failed:", err)
+				if !d.backoff() {
+					return
+				}
+				continue
+			}
+			if latest > next {
+				next = latest
+			}
+			index = next
+			if !equal(peers, last) {
+				last = peers
+				notify(peers)
+			}
+		}
+	}()
+	return nil
+}
+
+// 出错后等待1秒再重试, 关闭时返回false
+func (d *KVDiscovery) backoff() bool {
+	select {
+	case <-time.After(time.Second):
+		return true
+	case <-d.ctx.Done():
+		return false
+	}
+}
+
+func (d *KVDiscovery) Close() error {
+	d.cancel()
+	d.wg.Wait()
+	return nil
+}
+
+var _ Discovery = (*KVDiscovery)(nil)
+
+// 内存实现的键值存储, 用于测试
+type MemoryKV struct {
+	mu     sync.Mutex
+	data   map[string]string
+	index  uint64
+	change chan struct{} // 每次变更时关闭并重建, 用于唤醒等待者
+}
+
+func NewMemoryKV() *MemoryKV {
+	return &MemoryKV{
+		data:   make(map[string]string),
+		change: make(chan struct{}),
+	}
+}
+
+func (m *MemoryKV) Put(key, value string) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	m.data[key] = value
+	m.bump()
+}
+
+func (m *MemoryKV) Delete(key string) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if _, ok := m.data[key]; !ok {
+		return
+	}
+	delete(m.data, key)
+	m.bump()
+}
+
+func (m *MemoryKV) bump() {
+	m.index++
+	close(m.change)
+	m.change = make(chan struct{})
+}
+
+func (m *MemoryKV) List(ctx context.Context, prefix string) (map[string]string, uint64, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	keys := make([]string, 0, len(m.data))
+	for k := range m.data {
+		if strings.HasPrefix(k, prefix) {
+			keys = append(keys, k)
+		}
+	}
+	sort.Strings(keys)
+
+	pairs := make(map[string]string, len(keys))
+	for _, k := range keys {
+		pairs[k] = m.data[k]
+	}
+	return pairs, m.index, nil
+}
+
+// 简化实现: 任意键的变更都会唤醒等待者
+func (m *MemoryKV) Watch(ctx context.Context, prefix string, index uint64) (uint64, error) {
+	for {
+		m.mu.Lock()
+		if m.index > index {
+			i := m.index
+			m.mu.Unlock()
+			return i, nil
+		}
+		change := m.change
+		m.mu.Unlock()
+
+		select {
+		case <-change:
+		case <-ctx.Done():
+			return index, ctx.Err()
+		}
+	}
+}
+
+var _ KV = (*MemoryKV)(nil)
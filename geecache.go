@@ -1,10 +1,14 @@
 package geecache
 
 import (
+	"errors"
 	"fmt"
 	"geecache/singleflight"
-	"log"
+	"geecache/wal"
+	"io/fs"
 	"sync"
+	"sync/atomic"
+	"time"
 )
 
 // 回调接口
@@ -20,50 +24,285 @@ func (f GetterFunc) Get(key string) ([]byte, error) {
 
 // 缓存的命名空间
 type CacheGroup struct {
-	name      string              // 唯一名称
-	getter    Getter              // 数据源获取数据, 缓存未命中时获取源数据的回调(callback)
-	mainCache cache               // 并发缓存
-	server    NodeServer          // 用于获取远程节点请求客户端
-	loader    *singleflight.Group // 解决缓存击穿和穿透问题
+	registry        *Registry                 // 所属的注册表
+	name            string                    // 唯一名称
+	getter          Getter                    // 数据源获取数据, 缓存未命中时获取源数据的回调(callback)
+	mainCache       store                     // 并发缓存
+	capacity        int64                     // 最大缓存数量, 为0时不限制
+	shards          int                       // 缓存分片数量, 为0时使用默认值
+	storage         StorageMode               // 缓存存储方式
+	arenaSize       int64                     // StorageArena模式下的总字节数
+	eviction        EvictionPolicy            // 缓存淘汰策略
+	ttl             time.Duration             // 缓存值的过期时间, 为0时不过期
+	cleanupInterval time.Duration             // 过期key的清理周期
+	server          atomic.Pointer[serverRef] // 用于获取远程节点请求客户端, 可以替换
+	loader          *singleflight.Group       // 解决缓存击穿和穿透问题
+	peerLoader      *singleflight.Group       // 处理其他节点的请求, 与loader分开避免节点间互相等待
+	replicas        int                       // 副本数, 默认为1
+	writeThrough    bool                      // Set时是否同步写入所有副本
+	batcher         *batchLoader              // getter实现了BatchGetter时, 合并加载请求
+	batchWindow     time.Duration             // 批量加载的合并窗口
+	maxBatch        int                       // 批量加载的最大批量
+	compressor      Compressor                // 压缩算法, 为nil时不压缩
+	compressMin     int                       // 值的大小达到该值时才压缩
+	compression     compressionStats          // 压缩统计
+	logger          Logger
+	metrics         Metrics
+
+	snapshotDir      string        // 快照目录, 为空时不写入快照
+	snapshotInterval time.Duration // 定期快照的周期, 为0时只在关闭时写入
+	snapshotStop     chan struct{}
+	snapshotDone     chan struct{}
+
+	walConf *wal.Config  // 预写日志配置, 为nil时不记录
+	wal     *wal.Log     // 记录本地的Set和Delete, 崩溃后在快照之上重放
+	walMu   sync.RWMutex // 写入时持有读锁, 切换日志段时持有写锁, 保证旧段中的写入都已进入缓存
+
+	closed atomic.Bool // 是否已关闭
+}
+
+type serverRef struct {
+	server NodeServer
 }
 
 var (
-	mu     sync.RWMutex
-	groups = make(map[string]*CacheGroup)
+	ErrGroupExists = errors.New("geecache: group already exists")
+	ErrGroupClosed = errors.New("geecache: group closed")
 )
 
-func NewGroup(name string, capacity int64, getter Getter) *CacheGroup {
+func newGroup(registry *Registry, name string, getter Getter, opts []GroupOption) (*CacheGroup, error) {
+	if name == "" {
+		return nil, fmt.Errorf("group name is required")
+	}
 	if getter == nil {
-		panic("nil getter func")
+		return nil, fmt.Errorf("nil getter for group %s", name)
 	}
-	mu.Lock()
-	defer mu.Unlock()
 
 	g := &CacheGroup{
-		name:      name,
-		getter:    getter,
-		mainCache: cache{capacity: capacity},
-		loader:    &singleflight.Group{},
+		registry:        registry,
+		name:            name,
+		getter:          getter,
+		loader:          &singleflight.Group{},
+		peerLoader:      &singleflight.Group{},
+		replicas:        1,
+		ttl:             defaultTTL,
+		cleanupInterval: defaultCleanupInterval,
+		batchWindow:     defaultBatchWindow,
+		maxBatch:        defaultMaxBatch,
+		logger:          defaultLogger(),
+		metrics:         NopMetrics{},
+	}
+	for _, opt := range opts {
+		if err := opt(g); err != nil {
+			return nil, fmt.Errorf("group %s: %w", name, err)
+		}
+	}
+	if g.storage == StorageArena && g.eviction == EvictLRU {
+		return nil, fmt.Errorf("group %s: arena storage only supports FIFO eviction", name)
+	}
+	if g.walConf != nil && g.snapshotDir == "" {
+		return nil, fmt.Errorf("group %s: wal requires a snapshot dir to compact the log", name)
+	}
+
+	g.mainCache = g.newStore()
+	if bg, ok := getter.(BatchGetter); ok {
+		g.batcher = newBatchLoader(bg, g.batchWindow, g.maxBatch)
+	}
+	return g, nil
+}
+
+// 注册成功后打开预写日志并启动定期快照, 避免重名的group使用同一个目录
+func (g *CacheGroup) start() error {
+	if g.walConf != nil {
+		l, err := wal.Open(*g.walConf)
+		if err != nil {
+			return fmt.Errorf("group %s: %w", g.name, err)
+		}
+		g.wal = l
+	}
+	if g.snapshotDir != "" && g.snapshotInterval > 0 {
+		g.snapshotStop = make(chan struct{})
+		g.snapshotDone = make(chan struct{})
+		go g.startSnapshots()
+	}
+	return nil
+}
+
+func (g *CacheGroup) newStore() store {
+	if g.storage == StorageArena {
+		return newArenaCache(g.capacity, g.arenaSize, g.shards)
+	}
+	return &cache{
+		capacity:        g.capacity,
+		shards:          g.shards,
+		cleanupInterval: g.cleanupInterval,
+		fifo:            g.eviction == EvictFIFO,
 	}
-	groups[name] = g
-	return g
 }
 
-func GetCacheGroup(name string) *CacheGroup {
-	mu.RLock()
-	g := groups[name]
-	mu.RUnlock()
+// 关闭group: 从注册表中删除, 停止后台任务并释放缓存, 之后的读写返回ErrGroupClosed
+//
+// 配置了快照目录时, 释放缓存前写入最后一次快照并压缩预写日志.
+// 返回最后一次快照和关闭预写日志的错误, 重复关闭返回nil.
+func (g *CacheGroup) Close() error {
+	g.registry.unregister(g)
+	return g.close()
+}
+
+func (g *CacheGroup) close() error {
+	if g.closed.Swap(true) {
+		return nil
+	}
+	if g.snapshotStop != nil {
+		close(g.snapshotStop)
+		<-g.snapshotDone
+	}
+	var snapshotErr, walErr error
+	if g.snapshotDir != "" {
+		if err := g.snapshotFile(g.snapshotDir); err != nil {
+			snapshotErr = fmt.Errorf("group %s snapshot: %w", g.name, err)
+		}
+	}
+	if g.wal != nil {
+		g.walMu.Lock()
+		if err := g.wal.Close(); err != nil {
+			walErr = fmt.Errorf("group %s close wal: %w", g.name, err)
+		}
+		g.walMu.Unlock()
+	}
+	g.mainCache.close()
+	g.server.Store(nil)
 
-	return g
+	switch {
+	case snapshotErr != nil && walErr != nil:
+		return fmt.Errorf("%w; %v", snapshotErr, walErr)
+	case snapshotErr != nil:
+		return snapshotErr
+	default:
+		return walErr
+	}
+}
+
+// 读取缓存, 压缩的值在这里解压一次
+//
+// 无法解压的值从缓存中删除并返回错误, 不会返回空值; 之后的读取重新加载.
+func (g *CacheGroup) lookupCache(key string) (ByteView, bool, error) {
+	v, ok := g.mainCache.get(key)
+	if !ok {
+		return ByteView{}, false, nil
+	}
+	v, err := v.decode()
+	if err != nil {
+		g.logger.Printf("[GeeCache] group %s evict undecodable %s: %v", g.name, key, err)
+		g.mainCache.remove(key)
+		return ByteView{}, false, fmt.Errorf("group %s: %s: %w", g.name, key, err)
+	}
+	return v, true, nil
 }
 
 func (g *CacheGroup) populateCache(key string, value ByteView) {
-	g.mainCache.add(key, value)
-	g.mainCache.expire(key, 60*60*24*7)
+	var second int64
+	if g.ttl > 0 {
+		second = int64(g.ttl / time.Second)
+	}
+	g.addToCache(key, value, second)
+}
+
+// 写入缓存, second秒后过期, 为0时不过期
+func (g *CacheGroup) addToCache(key string, value ByteView, second int64) {
+	value.d = nil
+	raw := len(value.b)
+	value = compressView(g.compressor, g.compressMin, value)
+	g.compression.record(raw, len(value.b), value.c != nil)
+
+	g.mainCache.add(key, value, second)
+}
+
+// 写入本地缓存, 开启预写日志时先写日志
+func (g *CacheGroup) applySet(key string, value []byte) error {
+	g.walMu.RLock()
+	defer g.walMu.RUnlock()
+
+	if g.wal != nil {
+		var expireAt int64
+		if g.ttl > 0 {
+			expireAt = time.Now().Add(g.ttl).Unix()
+		}
+		if err := g.wal.Append(wal.Record{Op: wal.OpSet, Key: key, Value: value, ExpireAt: expireAt}); err != nil {
+			return err
+		}
+	}
+	g.populateCache(key, ByteView{b: cloneBytes(value)})
+	return nil
+}
+
+// 删除本地缓存, 开启预写日志时先写日志
+func (g *CacheGroup) applyDelete(key string) error {
+	g.walMu.RLock()
+	defer g.walMu.RUnlock()
+
+	if g.wal != nil {
+		if err := g.wal.Append(wal.Record{Op: wal.OpDelete, Key: key}); err != nil {
+			return err
+		}
+	}
+	g.mainCache.remove(key)
+	return nil
+}
+
+// 启动时恢复: 先从快照目录恢复, 再重放预写日志中的Set和Delete
+//
+// 应在开始处理请求前调用, 没有快照或日志时直接返回.
+func (g *CacheGroup) Recover() error {
+	if g.closed.Load() {
+		return ErrGroupClosed
+	}
+	if g.snapshotDir != "" {
+		if err := g.RestoreFile(g.snapshotDir); err != nil && !errors.Is(err, fs.ErrNotExist) {
+			return err
+		}
+	}
+	if g.wal == nil {
+		return nil
+	}
+
+	now := time.Now().Unix()
+	var sets, deletes int
+	err := g.wal.Replay(func(rec wal.Record) error {
+		switch rec.Op {
+		case wal.OpSet:
+			var second int64
+			if rec.ExpireAt != 0 {
+				if second = rec.ExpireAt - now; second <= 0 {
+					g.mainCache.remove(rec.Key)
+					return nil
+				}
+			}
+			g.addToCache(rec.Key, ByteView{b: rec.Value}, second)
+			sets++
+		case wal.OpDelete:
+			g.mainCache.remove(rec.Key)
+			deletes++
+		}
+		return nil
+	})
+	if err != nil {
+		return fmt.Errorf("group %s: replay wal: %w", g.name, err)
+	}
+	g.logger.Printf("[GeeCache] group %s replayed %d sets and %d deletes from wal", g.name, sets, deletes)
+	return nil
 }
 
 func (g *CacheGroup) getLocally(key string) (ByteView, error) {
-	bytes, err := g.getter.Get(key)
+	var bytes []byte
+	var err error
+	start := time.Now()
+	if g.batcher != nil {
+		bytes, err = g.batcher.load(key)
+	} else {
+		bytes, err = g.getter.Get(key)
+	}
+	g.metrics.Load(g.name, LoadFromSource, time.Since(start), err)
 	if err != nil {
 		return ByteView{}, err
 	}
@@ -73,11 +312,51 @@ func (g *CacheGroup) getLocally(key string) (ByteView, error) {
 	return value, nil
 }
 
+// 注册节点服务, 重复调用时替换之前的服务, server为nil时取消注册
 func (g *CacheGroup) RegisterServer(server NodeServer) {
-	if g.server != nil {
-		panic("RegisterServer called more than once")
+	if server == nil {
+		g.server.Store(nil)
+		return
+	}
+	g.server.Store(&serverRef{server: server})
+}
+
+// 当前的节点服务, 未注册时为nil
+func (g *CacheGroup) nodeServer() NodeServer {
+	if ref := g.server.Load(); ref != nil {
+		return ref.server
 	}
-	g.server = server
+	return nil
+}
+
+// 缓存统计
+func (g *CacheGroup) CacheStats() CacheStats {
+	return g.mainCache.stats()
+}
+
+// 压缩统计
+func (g *CacheGroup) CompressionStats() CompressionStats {
+	return CompressionStats{
+		Values:      g.compression.values.Load(),
+		Compressed:  g.compression.compressed.Load(),
+		RawBytes:    g.compression.rawBytes.Load(),
+		StoredBytes: g.compression.storedBytes.Load(),
+	}
+}
+
+// 按优先级返回key的副本节点客户端, 自己作为副本时对应位置为nil
+func (g *CacheGroup) pickClients(key string) []NodeClient {
+	server := g.nodeServer()
+	if server == nil {
+		return []NodeClient{nil}
+	}
+	if rs, ok := server.(ReplicaNodeServer); ok && g.replicas > 1 {
+		return rs.PickReplicaClients(key, g.replicas)
+	}
+	if client, ok := server.PickNodeClient(key); ok {
+		return []NodeClient{client}
+	}
+	return []NodeClient{nil}
 }
 
 func (g *CacheGroup) getValueFormClient(client NodeClient, key string) (ByteView, error) {
@@ -90,15 +369,23 @@ func (g *CacheGroup) getValueFormClient(client NodeClient, key string) (ByteView
 
 func (g *CacheGroup) load(key string) (value ByteView, err error) {
 	view, err := g.loader.Do(key, func() (interface{}, error) {
-		if g.server != nil {
-			if client, ok := g.server.PickNodeClient(key); ok {
-				if value, err = g.getValueFormClient(client, key); err == nil {
-					return value, nil
-				}
-				log.Println("[GeeCache] Failed to get value from client", err)
+		// 按顺序尝试各副本, 轮到自己时从数据源加载
+		for _, client := range g.pickClients(key) {
+			if client == nil {
+				break
 			}
+			start := time.Now()
+			value, err = g.getValueFormClient(client, key)
+			g.metrics.Load(g.name, LoadFromPeer, time.Since(start), err)
+			if err == nil {
+				return value, nil
+			}
+			g.logger.Printf("[GeeCache] Failed to get value from client %v", err)
 		}
 
+		if value, ok := g.getFromPreviousOwner(key); ok {
+			return value, nil
+		}
 		return g.getLocally(key)
 	})
 
@@ -112,11 +399,183 @@ func (g *CacheGroup) GetCacheValue(key string) (ByteView, error) {
 	if key == "" {
 		return ByteView{}, fmt.Errorf("key is required")
 	}
+	if g.closed.Load() {
+		return ByteView{}, ErrGroupClosed
+	}
 
-	if v, ok := g.mainCache.get(key); ok {
-		log.Println("[GeeCache] hit")
+	v, ok, err := g.lookupCache(key)
+	if err != nil {
+		return ByteView{}, err
+	}
+	if ok {
+		g.metrics.CacheHit(g.name)
+		g.logger.Printf("[GeeCache] hit")
 		return v, nil
 	}
+	g.metrics.CacheMiss(g.name)
 
 	return g.load(key)
 }
+
+// 处理来自其他节点的请求, 未命中时直接从数据源加载, 不再转发给其他节点
+func (g *CacheGroup) getForPeer(key string) (ByteView, error) {
+	if key == "" {
+		return ByteView{}, fmt.Errorf("key is required")
+	}
+	if g.closed.Load() {
+		return ByteView{}, ErrGroupClosed
+	}
+
+	v, ok, err := g.lookupCache(key)
+	if err != nil {
+		return ByteView{}, err
+	}
+	if ok {
+		g.metrics.CacheHit(g.name)
+		return v, nil
+	}
+	g.metrics.CacheMiss(g.name)
+
+	view, err := g.peerLoader.Do(key, func() (interface{}, error) {
+		if value, ok := g.getFromPreviousOwner(key); ok {
+			return value, nil
+		}
+		return g.getLocally(key)
+	})
+	if err != nil {
+		return ByteView{}, err
+	}
+	return view.(ByteView), nil
+}
+
+// 写入缓存值, 写入key的主节点, 开启writeThrough时同时写入其他副本
+func (g *CacheGroup) Set(key string, value []byte) error {
+	if key == "" {
+		return fmt.Errorf("key is required")
+	}
+	if g.closed.Load() {
+		return ErrGroupClosed
+	}
+
+	clients := g.pickClients(key)
+	if !g.writeThrough {
+		clients = clients[:1]
+	}
+
+	var failed int
+	var lastErr error
+	for _, client := range clients {
+		if client == nil {
+			if err := g.applySet(key, value); err != nil {
+				failed, lastErr = failed+1, err
+			}
+			continue
+		}
+		setter, ok := client.(NodeSetter)
+		if !ok {
+			failed, lastErr = failed+1, fmt.Errorf("client does not support set")
+			continue
+		}
+		if err := setter.SetCacheValue(g.name, key, value); err != nil {
+			failed, lastErr = failed+1, err
+		}
+	}
+	if failed > 0 {
+		return fmt.Errorf("set %s failed on %d of %d replicas: %v", key, failed, len(clients), lastErr)
+	}
+	return nil
+}
+
+// 处理来自其他节点的写入
+func (g *CacheGroup) setForPeer(key string, value []byte) error {
+	if g.closed.Load() {
+		return ErrGroupClosed
+	}
+	return g.applySet(key, value)
+}
+
+// 写入节点变更时旧所有者迁移来的值
+//
+// 保留值剩余的过期时间(expireAt为unix秒, 为0时不过期), 已过期的值直接丢弃;
+// 迁移不是用户的写入, 不写预写日志.
+func (g *CacheGroup) handoffForPeer(key string, value []byte, expireAt int64) error {
+	if g.closed.Load() {
+		return ErrGroupClosed
+	}
+	var second int64
+	if expireAt != 0 {
+		if second = expireAt - time.Now().Unix(); second <= 0 {
+			return nil
+		}
+	}
+	g.addToCache(key, ByteView{b: cloneBytes(value)}, second)
+	return nil
+}
+
+// 删除缓存值, 删除本地以及key的所有副本上的值
+func (g *CacheGroup) Delete(key string) error {
+	if key == "" {
+		return fmt.Errorf("key is required")
+	}
+	if g.closed.Load() {
+		return ErrGroupClosed
+	}
+
+	clients := g.pickClients(key)
+	var failed int
+	var lastErr error
+	if err := g.applyDelete(key); err != nil {
+		failed, lastErr = failed+1, err
+	}
+	for _, client := range clients {
+		if client == nil {
+			continue
+		}
+		deleter, ok := client.(NodeDeleter)
+		if !ok {
+			failed, lastErr = failed+1, fmt.Errorf("client does not support delete")
+			continue
+		}
+		if err := deleter.DeleteCacheValue(g.name, key); err != nil {
+			failed, lastErr = failed+1, err
+		}
+	}
+	if failed > 0 {
+		return fmt.Errorf("delete %s failed on %d replicas: %v", key, failed, lastErr)
+	}
+	return nil
+}
+
+// 处理来自其他节点的删除
+func (g *CacheGroup) deleteForPeer(key string) error {
+	if g.closed.Load() {
+		return ErrGroupClosed
+	}
+	return g.applyDelete(key)
+}
+
+// 节点变更后的迁移窗口内, 从key的旧所有者的缓存中读取, 避免新所有者冷启动时全部回源
+func (g *CacheGroup) getFromPreviousOwner(key string) (ByteView, bool) {
+	hs, ok := g.nodeServer().(HandoffNodeServer)
+	if !ok {
+		return ByteView{}, false
+	}
+	client, ok := hs.PickPreviousClient(key)
+	if !ok {
+		return ByteView{}, false
+	}
+	peeker, ok := client.(NodePeeker)
+	if !ok {
+		return ByteView{}, false
+	}
+
+	start := time.Now()
+	bytes, err := peeker.PeekCacheValue(g.name, key)
+	g.metrics.Load(g.name, LoadFromPrevious, time.Since(start), err)
+	if err != nil {
+		return ByteView{}, false
+	}
+	value := ByteView{b: bytes}
+	g.populateCache(key, value)
+	return value, true
+}
@@ -0,0 +1,268 @@
+package geecache
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+)
+
+const healthPath = "/health"
+
+// 健康检查配置
+type HealthConfig struct {
+	Interval           time.Duration // 主动探测周期
+	Timeout            time.Duration // 单次探测超时时间
+	UnhealthyThreshold int           // 连续探测失败次数达到该值时剔除节点
+	HealthyThreshold   int           // 被剔除的节点连续探测成功次数达到该值时恢复
+	ErrorRate          float64       // 被动剔除: 统计窗口内请求错误率达到该值时剔除节点
+	MinRequests        int           // 被动剔除: 统计窗口内请求数达到该值才计算错误率
+	Window             time.Duration // 被动剔除: 统计窗口
+}
+
+func DefaultHealthConfig() HealthConfig {
+	return HealthConfig{
+		Interval:           5 * time.Second,
+		Timeout:            time.Second,
+		UnhealthyThreshold: 2,
+		HealthyThreshold:   2,
+		ErrorRate:          0.5,
+		MinRequests:        5,
+		Window:             10 * time.Second,
+	}
+}
+
+// 单个节点的健康状态
+type peerHealth struct {
+	probeFailures  int       // 连续探测失败次数
+	probeSuccesses int       // 连续探测成功次数
+	requests       int       // 统计窗口内的请求数
+	failures       int       // 统计窗口内的失败数
+	windowStart    time.Time // 统计窗口开始时间
+}
+
+// 健康检查, 包括定期探测/health的主动检查和根据请求错误率的被动剔除
+type healthChecker struct {
+	pool   *HTTPPool
+	conf   HealthConfig
+	client *http.Client
+
+	mu    sync.Mutex
+	peers map[string]*peerHealth
+
+	stopCh chan struct{}
+	wg     sync.WaitGroup
+}
+
+// 开启健康检查, 重复调用会先停止之前的检查
+func (p *HTTPPool) StartHealthCheck(conf HealthConfig) {
+	def := DefaultHealthConfig()
+	if conf.Interval <= 0 {
+		conf.Interval = def.Interval
+	}
+	if conf.Timeout <= 0 {
+		conf.Timeout = def.Timeout
+	}
+	if conf.UnhealthyThreshold <= 0 {
+		conf.UnhealthyThreshold = def.UnhealthyThreshold
+	}
+	if conf.HealthyThreshold <= 0 {
+		conf.HealthyThreshold = def.HealthyThreshold
+	}
+	if conf.ErrorRate <= 0 {
+		conf.ErrorRate = def.ErrorRate
+	}
+	if conf.MinRequests <= 0 {
+		conf.MinRequests = def.MinRequests
+	}
+	if conf.Window <= 0 {
+		conf.Window = def.Window
+	}
+
+	h := &healthChecker{
+		pool:   p,
+		conf:   conf,
+		client: &http.Client{Timeout: conf.Timeout, Transport: p.transport},
+		peers:  make(map[string]*peerHealth),
+		stopCh: make(chan struct{}),
+	}
+	if old := p.health.Swap(h); old != nil {
+		old.stop()
+	}
+
+	h.wg.Add(1)
+	go h.run()
+}
+
+// 停止健康检查, 已被剔除的节点全部恢复
+func (p *HTTPPool) StopHealthCheck() {
+	if h := p.health.Swap(nil); h != nil {
+		h.stop()
+	}
+
+	p.mu.Lock()
+	var ejected []string
+	for addr := range p.ejected {
+		ejected = append(ejected, addr)
+	}
+	p.mu.Unlock()
+
+	for _, addr := range ejected {
+		p.restorePeer(addr)
+	}
+}
+
+// 上报请求结果, 用于被动剔除
+func (p *HTTPPool) reportResult(addr string, ok bool) {
+	p.metrics.PeerRequest(addr, ok)
+	if h := p.health.Load(); h != nil {
+		h.record(addr, ok)
+	}
+}
+
+// 健康检查接口
+func (p *HTTPPool) serveHealth(w http.ResponseWriter, r *http.Request) {
+	status := "ok"
+	w.Header().Set("Content-Type", "application/json")
+	if p.draining.Load() {
+		status = "draining"
+		w.WriteHeader(http.StatusServiceUnavailable)
+	}
+	json.NewEncoder(w).Encode(map[string]string{
+		"status": status,
+		"self":   p.self,
+	})
+}
+
+func (h *healthChecker) stop() {
+	close(h.stopCh)
+	h.wg.Wait()
+}
+
+func (h *healthChecker) run() {
+	defer h.wg.Done()
+
+	ticker := time.NewTicker(h.conf.Interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ticker.C:
+			h.probeAll()
+		case <-h.stopCh:
+			return
+		}
+	}
+}
+
+// 并发探测所有节点, 包括已被剔除的节点
+func (h *healthChecker) probeAll() {
+	var wg sync.WaitGroup
+	for _, addr := range h.pool.Peers() {
+		if addr == h.pool.self {
+			continue
+		}
+		wg.Add(1)
+		go func(addr string) {
+			defer wg.Done()
+			h.probed(addr, h.probe(addr))
+		}(addr)
+	}
+	wg.Wait()
+}
+
+func (h *healthChecker) probe(addr string) bool {
+	ctx, cancel := context.WithTimeout(context.Background(), h.conf.Timeout)
+	defer cancel()
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, strings.TrimSuffix(addr, "/")+healthPath, nil)
+	if err != nil {
+		return false
+	}
+	res, err := h.client.Do(req)
+	if err != nil {
+		return false
+	}
+	res.Body.Close()
+	return res.StatusCode == http.StatusOK
+}
+
+func (h *healthChecker) get(addr string) *peerHealth {
+	ph, ok := h.peers[addr]
+	if !ok {
+		ph = &peerHealth{windowStart: time.Now()}
+		h.peers[addr] = ph
+	}
+	return ph
+}
+
+// 处理主动探测结果
+func (h *healthChecker) probed(addr string, ok bool) {
+	h.mu.Lock()
+	ph := h.get(addr)
+	var eject, restore bool
+	if ok {
+		ph.probeFailures = 0
+		ph.probeSuccesses++
+		restore = ph.probeSuccesses >= h.conf.HealthyThreshold
+	} else {
+		ph.probeSuccesses = 0
+		ph.probeFailures++
+		eject = ph.probeFailures >= h.conf.UnhealthyThreshold
+	}
+	h.mu.Unlock()
+
+	if eject {
+		h.pool.ejectPeer(addr)
+	} else if restore {
+		h.pool.restorePeer(addr)
+	}
+}
+
+// 删除已不是成员的节点的健康状态, 节点重新加入时从头统计
+func (h *healthChecker) prune(members map[string]*httpClient) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	for addr := range h.peers {
+		if _, ok := members[addr]; !ok {
+			delete(h.peers, addr)
+		}
+	}
+}
+
+// 节点主动退出, 需要重新通过主动探测才能恢复
+func (h *healthChecker) left(addr string) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	ph := h.get(addr)
+	ph.probeSuccesses = 0
+}
+
+// 记录请求结果, 错误率过高时剔除节点
+func (h *healthChecker) record(addr string, ok bool) {
+	h.mu.Lock()
+	ph := h.get(addr)
+	if now := time.Now(); now.Sub(ph.windowStart) > h.conf.Window {
+		ph.windowStart = now
+		ph.requests, ph.failures = 0, 0
+	}
+	ph.requests++
+	if !ok {
+		ph.failures++
+	}
+
+	eject := ph.requests >= h.conf.MinRequests &&
+		float64(ph.failures)/float64(ph.requests) >= h.conf.ErrorRate
+	if eject {
+		// 需要重新通过主动探测才能恢复
+		ph.requests, ph.failures = 0, 0
+		ph.probeSuccesses = 0
+	}
+	h.mu.Unlock()
+
+	if eject {
+		h.pool.ejectPeer(addr)
+	}
+}
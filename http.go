@@ -1,50 +1,131 @@
 package geecache
 
 import (
+	"bytes"
+	"encoding/json"
+	"errors"
 	"fmt"
 	"geecache/consistence"
+	"geecache/discovery"
 	"io"
-	"log"
 	"net/http"
 	"net/url"
+	"sort"
+	"strconv"
 	"strings"
 	"sync"
+	"sync/atomic"
 )
 
 const (
-	defaultBasePath = "/_geecache/"
-	defaultReplicas = 50
+	defaultBasePath  = "/_geecache/"
+	defaultAdminPath = "/_geecache_admin/"
+	defaultReplicas  = 50
+
+	maxBatchBodySize = 1 << 20  // 批量请求体的大小上限
+	maxValueSize     = 32 << 20 // 其他节点写入的单个值的大小上限
 )
 
 // 服务端
 type HTTPPool struct {
-	self        string                   // 记录自己的地址, 包括主机名/IP和端口
-	basePath    string                   // 节点间通讯地址的前缀, 默认是/_geecache/
-	mu          sync.Mutex               // 互斥锁, 用于并发访问远程服务
-	consistence *consistence.Consistence // 一致性哈希
-	httpClient  map[string]*httpClient   // 客户端, 存储远程访问服务
+	self         string                        // 记录自己的地址, 包括主机名/IP和端口
+	basePath     string                        // 节点间通讯地址的前缀, 默认是/_geecache/
+	adminPath    string                        // 管理接口地址的前缀, 默认是/_geecache_admin/
+	mu           sync.Mutex                    // 互斥锁, 串行化节点变更
+	peers        atomic.Pointer[peerState]     // 当前节点视图, 整体原子替换
+	ejected      map[string]struct{}           // 因不健康被移出哈希环的节点, 由mu保护
+	weights      map[string]int                // 节点权重, 未设置时为1, 由mu保护
+	newPicker    func() consistence.Picker     // 创建节点选择算法, 为nil时使用一致性哈希环
+	health       atomic.Pointer[healthChecker] // 健康检查, 未开启时为nil
+	rebalancer   atomic.Pointer[rebalancer]    // 节点变更时的数据迁移, 未开启时为nil
+	registry     *Registry                     // 提供服务的group注册表
+	virtualNodes int                           // 一致性哈希环中每个节点的虚拟节点数量
+	hash         consistence.Hash              // 一致性哈希环使用的哈希函数, 为nil时使用默认值
+	transport    http.RoundTripper             // 访问其他节点使用的Transport
+	client       *http.Client                  // 使用transport的客户端
+	logger       Logger
+	metrics      Metrics
+	draining     atomic.Bool  // 正在退出, 不再处理新的节点间请求
+	inflight     atomic.Int64 // 正在处理的节点间请求数
+}
+
+// 节点视图, 创建后只读
+type peerState struct {
+	picker     consistence.Picker     // 节点选择算法, 不包含被剔除的节点
+	httpClient map[string]*httpClient // 客户端, 存储远程访问服务, 包含全部节点
+	version    uint64                 // 版本号, 每次变更加1
 }
 
-func NewHTTPPool(self string) *HTTPPool {
-	return &HTTPPool{
-		self:     self,
-		basePath: defaultBasePath,
+// 创建服务端, 默认使用DefaultRegistry中的group
+func NewHTTPPool(self string, opts ...PoolOption) (*HTTPPool, error) {
+	if self == "" {
+		return nil, fmt.Errorf("self address is required")
+	}
+	self, err := parsePeer(self)
+	if err != nil {
+		return nil, fmt.Errorf("self: %v", err)
+	}
+
+	p := &HTTPPool{
+		registry:     DefaultRegistry,
+		self:         self,
+		basePath:     defaultBasePath,
+		adminPath:    defaultAdminPath,
+		ejected:      make(map[string]struct{}),
+		weights:      make(map[string]int),
+		virtualNodes: defaultReplicas,
+		transport:    http.DefaultTransport,
+		logger:       defaultLogger(),
+		metrics:      NopMetrics{},
+	}
+	for _, opt := range opts {
+		if err := opt(p); err != nil {
+			return nil, err
+		}
+	}
+	if p.basePath == p.adminPath {
+		return nil, fmt.Errorf("base path and admin path must differ: %q", p.basePath)
 	}
+	p.client = &http.Client{Transport: p.transport}
+
+	p.peers.Store(&peerState{
+		picker:     p.defaultPicker(),
+		httpClient: make(map[string]*httpClient),
+	})
+	return p, nil
 }
 
 func (p *HTTPPool) Log(format string, v ...interface{}) {
-	log.Printf("[Server %s] %s \n", p.self, fmt.Sprintf(format, v...))
+	p.logger.Printf("[Server %s] %s \n", p.self, fmt.Sprintf(format, v...))
 }
 
 // 监听服务, 如果有请求过来, 则进行处理
 func (p *HTTPPool) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if r.URL.Path == healthPath {
+		p.serveHealth(w, r)
+		return
+	}
+	if strings.HasPrefix(r.URL.Path, p.adminPath) {
+		p.serveAdmin(w, r)
+		return
+	}
 	if !strings.HasPrefix(r.URL.Path, p.basePath) {
 		panic("HTTPPool serving unexpected path: " + r.URL.Path)
 	}
+	p.inflight.Add(1)
+	defer p.inflight.Add(-1)
+	if p.draining.Load() {
+		http.Error(w, "shutting down", http.StatusServiceUnavailable)
+		return
+	}
 	p.Log("%s %s", r.Method, r.URL.Path)
 
 	// self/basepath/<groupname>/<key> required
 	parts := strings.SplitN(r.URL.Path[len(p.basePath):], "/", 2)
+	if r.Method == http.MethodPost && (len(parts) == 1 || parts[1] == "") {
+		p.serveBatch(w, r, parts[0])
+		return
+	}
 	if len(parts) != 2 {
 		http.Error(w, "bad request", http.StatusBadRequest)
 		return
@@ -54,53 +135,493 @@ func (p *HTTPPool) ServeHTTP(w http.ResponseWriter, r *http.Request) {
 	key := parts[1]
 
 	// 根据groupName获取cacheGroup
-	cacheGroup := GetCacheGroup(groupName)
+	cacheGroup := p.registry.Group(groupName)
 	if cacheGroup == nil {
 		http.Error(w, "no such group: "+groupName, http.StatusNotFound)
 		return
 	}
 
-	view, err := cacheGroup.GetCacheValue(key)
+	if r.Method == http.MethodPut {
+		body, err := io.ReadAll(http.MaxBytesReader(w, r.Body, maxValueSize))
+		if err != nil {
+			var tooLarge *http.MaxBytesError
+			if errors.As(err, &tooLarge) {
+				http.Error(w, "value too large", http.StatusRequestEntityTooLarge)
+				return
+			}
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		if r.URL.Query().Get("handoff") != "" {
+			p.serveHandoff(w, r, cacheGroup, key, body)
+			return
+		}
+		if err := cacheGroup.setForPeer(key, body); err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		w.WriteHeader(http.StatusNoContent)
+		return
+	}
+	if r.Method == http.MethodDelete {
+		if err := cacheGroup.deleteForPeer(key); err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		w.WriteHeader(http.StatusNoContent)
+		return
+	}
+
+	// 只查询缓存, 用于节点变更时新所有者从旧所有者读取
+	if r.URL.Query().Get("peek") != "" {
+		view, ok, err := cacheGroup.lookupCache(key)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		if !ok {
+			http.Error(w, "not found", http.StatusNotFound)
+			return
+		}
+		writeView(w, r, view)
+		return
+	}
+
+	view, err := cacheGroup.getForPeer(key)
 	if err != nil {
 		http.Error(w, err.Error(), http.StatusInternalServerError)
 		return
 	}
 
+	writeView(w, r, view)
+}
+
+// 写入节点变更时迁移来的值, PUT self/basepath/<groupname>/<key>?handoff=1&expire_at=<unix秒>
+func (p *HTTPPool) serveHandoff(w http.ResponseWriter, r *http.Request, g *CacheGroup, key string, body []byte) {
+	var expireAt int64
+	if s := r.URL.Query().Get("expire_at"); s != "" {
+		var err error
+		if expireAt, err = strconv.ParseInt(s, 10, 64); err != nil {
+			http.Error(w, "bad expire_at: "+s, http.StatusBadRequest)
+			return
+		}
+	}
+	if err := g.handoffForPeer(key, body, expireAt); err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// 写入缓存值, 值已压缩且对方支持该算法时直接发送压缩后的数据
+func writeView(w http.ResponseWriter, r *http.Request, view ByteView) {
 	w.Header().Set("Content-Type", "application/octet-stream")
-	w.Write(view.ByteSlice())
+	if view.c != nil && acceptsEncoding(r, view.c.Name()) {
+		w.Header().Set("Content-Encoding", view.c.Name())
+		w.Write(view.b)
+		return
+	}
+	view.WriteTo(w)
+}
+
+func acceptsEncoding(r *http.Request, name string) bool {
+	for _, enc := range strings.Split(r.Header.Get("Accept-Encoding"), ",") {
+		if strings.TrimSpace(strings.SplitN(enc, ";", 2)[0]) == name {
+			return true
+		}
+	}
+	return false
+}
+
+// 批量请求, POST self/basepath/<groupname>
+type batchRequest struct {
+	Keys []string `json:"keys"`
+}
+
+// 批量请求的响应, 每个key在values或errors中出现一次
+//
+// 值已压缩且请求的Accept-Encoding包含该算法时直接发送压缩后的数据, 算法名称记录在encodings中.
+type batchResponse struct {
+	Values    map[string][]byte `json:"values"`
+	Encodings map[string]string `json:"encodings,omitempty"`
+	Errors    map[string]string `json:"errors,omitempty"`
+}
+
+func (p *HTTPPool) serveBatch(w http.ResponseWriter, r *http.Request, groupName string) {
+	cacheGroup := p.registry.Group(groupName)
+	if cacheGroup == nil {
+		http.Error(w, "no such group: "+groupName, http.StatusNotFound)
+		return
+	}
+
+	var req batchRequest
+	r.Body = http.MaxBytesReader(w, r.Body, maxBatchBodySize)
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		var tooLarge *http.MaxBytesError
+		if errors.As(err, &tooLarge) {
+			http.Error(w, "request body too large", http.StatusRequestEntityTooLarge)
+			return
+		}
+		http.Error(w, "bad request: "+err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	values, errs := cacheGroup.getManyForPeer(req.Keys)
+	res := batchResponse{
+		Values:    make(map[string][]byte, len(values)),
+		Encodings: make(map[string]string),
+		Errors:    make(map[string]string, len(errs)),
+	}
+	for key, view := range values {
+		if view.c != nil && acceptsEncoding(r, view.c.Name()) {
+			res.Values[key] = view.b
+			res.Encodings[key] = view.c.Name()
+			continue
+		}
+		res.Values[key] = view.raw()
+	}
+	for key, err := range errs {
+		res.Errors[key] = err.Error()
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(res)
 }
 
 // 实例化一致性哈希算法, 并且添加传入的节点
 func (p *HTTPPool) Set(addrs ...string) {
+	weights := make(map[string]int, len(addrs))
+	for _, addr := range addrs {
+		weights[addr] = 1
+	}
+	p.SetWeighted(weights)
+}
+
+// 同Set, 按权重分配虚拟节点, 适用于机器配置不同的集群
+func (p *HTTPPool) SetWeighted(weights map[string]int) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	clients := make(map[string]*httpClient, len(weights))
+	p.weights = make(map[string]int, len(weights))
+	for addr, weight := range weights {
+		addr, err := parsePeer(addr)
+		if err != nil {
+			p.Log("Skip invalid peer: %v", err)
+			continue
+		}
+		clients[addr] = p.newClient(addr)
+		p.weights[addr] = weight
+	}
+	p.ejected = make(map[string]struct{})
+	p.storePeers(clients)
+}
+
+// 设置节点选择算法, 默认为一致性哈希环, 设置后按当前节点重建
+func (p *HTTPPool) SetPicker(newPicker func() consistence.Picker) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	p.newPicker = newPicker
+	p.storePeers(p.peers.Load().httpClient)
+}
+
+// 运行时添加带权重的节点, 节点已存在时更新其权重
+func (p *HTTPPool) AddWeightedPeer(addr string, weight int) {
+	addr, err := parsePeer(addr)
+	if err != nil {
+		p.Log("Skip invalid peer: %v", err)
+		return
+	}
+
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	if old, ok := p.weights[addr]; ok && old == weight {
+		return
+	}
+	p.weights[addr] = weight
+
+	if _, ok := p.peers.Load().httpClient[addr]; !ok {
+		p.updatePeers([]string{addr}, nil)
+		return
+	}
+	p.storePeers(p.peers.Load().httpClient)
+	p.Log("Peer %s weight changed to %d", addr, weight)
+}
+
+// 运行时添加节点, 已存在的节点会被忽略
+func (p *HTTPPool) AddPeer(addrs ...string) {
 	p.mu.Lock()
 	defer p.mu.Unlock()
 
-	p.consistence = consistence.NewMap(defaultReplicas, nil)
-	p.consistence.AddNode(addrs...)
-	p.httpClient = make(map[string]*httpClient, len(addrs))
+	p.updatePeers(addrs, nil)
+}
+
+// 运行时删除节点
+func (p *HTTPPool) RemovePeer(addrs ...string) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	p.updatePeers(nil, addrs)
+}
+
+// 将节点列表更新为addrs, 只增删有变化的节点
+func (p *HTTPPool) SetPeers(addrs ...string) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	added, removed := discovery.Diff(p.peers.Load().members(), p.parsePeers(addrs))
+	p.updatePeers(added, removed)
+}
+
+// 校验节点地址并去掉末尾的/, 与cmd中节点地址的校验一致, 需要形如http://host:port
+//
+// 同一个节点只有一种写法, 自身与哈希环中的节点地址可以直接比较.
+func parsePeer(addr string) (string, error) {
+	u, err := url.Parse(addr)
+	if err != nil {
+		return "", err
+	}
+	if u.Scheme != "http" && u.Scheme != "https" || u.Host == "" {
+		return "", fmt.Errorf("address must look like http://host:port: %q", addr)
+	}
+	return strings.TrimSuffix(addr, "/"), nil
+}
+
+// 规范化节点地址, 跳过无效的地址
+func (p *HTTPPool) parsePeers(addrs []string) []string {
+	parsed := make([]string, 0, len(addrs))
 	for _, addr := range addrs {
-		p.httpClient[addr] = &httpClient{baseURL: addr + p.basePath}
+		addr, err := parsePeer(addr)
+		if err != nil {
+			p.Log("Skip invalid peer: %v", err)
+			continue
+		}
+		parsed = append(parsed, addr)
 	}
+	return parsed
 }
 
-// 根据具体的key, 选择节点, 返回节点对应的HTTP客户端
-func (p *HTTPPool) PickNodeClient(key string) (NodeClient, bool) {
+// 增删节点, 地址无效的节点被跳过, 调用方需持有p.mu
+func (p *HTTPPool) updatePeers(add, remove []string) {
+	add, remove = p.parsePeers(add), p.parsePeers(remove)
+	old := p.peers.Load()
+	clients := make(map[string]*httpClient, len(old.httpClient)+len(add))
+	for addr, client := range old.httpClient {
+		clients[addr] = client
+	}
+
+	var added, removed []string
+	for _, addr := range add {
+		if _, ok := clients[addr]; ok {
+			continue
+		}
+		clients[addr] = p.newClient(addr)
+		added = append(added, addr)
+	}
+	for _, addr := range remove {
+		if _, ok := clients[addr]; !ok {
+			continue
+		}
+		delete(clients, addr)
+		delete(p.ejected, addr)
+		delete(p.weights, addr)
+		removed = append(removed, addr)
+	}
+	if len(added) == 0 && len(removed) == 0 {
+		return
+	}
+
+	p.storePeers(clients)
+	p.Log("Peers changed, added %v, removed %v", added, removed)
+}
+
+// 将不健康的节点移出哈希环, 但仍保留为集群成员
+func (p *HTTPPool) ejectPeer(addr string) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	old := p.peers.Load()
+	if _, ok := old.httpClient[addr]; !ok {
+		return
+	}
+	if _, ok := p.ejected[addr]; ok {
+		return
+	}
+	p.ejected[addr] = struct{}{}
+	p.storePeers(old.httpClient)
+	p.Log("Eject unhealthy peer %s", addr)
+}
+
+// 节点恢复健康, 重新加入哈希环
+func (p *HTTPPool) restorePeer(addr string) {
 	p.mu.Lock()
 	defer p.mu.Unlock()
 
-	if addr := p.consistence.GetNode(key); addr != "" && addr != p.self {
+	if _, ok := p.ejected[addr]; !ok {
+		return
+	}
+	delete(p.ejected, addr)
+	p.storePeers(p.peers.Load().httpClient)
+	p.Log("Restore peer %s", addr)
+}
+
+// 根据节点列表构建新的节点视图并整体替换, 被剔除的节点不参与选择, 调用方需持有p.mu
+//
+// 在新的选择算法上构建完成后才替换, PickNodeClient不会看到构建一半的哈希环.
+func (p *HTTPPool) storePeers(clients map[string]*httpClient) {
+	state := &peerState{picker: p.buildPicker(clients, ""), httpClient: clients}
+	if prev := p.peers.Load(); prev != nil {
+		state.version = prev.version + 1
+	}
+	old := p.peers.Swap(state)
+
+	if h := p.health.Load(); h != nil {
+		h.prune(clients)
+	}
+
+	// 健康检查剔除、恢复节点和调整权重不改变节点成员, 不触发数据迁移, 避免节点状态抖动时反复重新迁移
+	if rb := p.rebalancer.Load(); rb != nil && old != nil && !sameMembers(old.httpClient, clients) {
+		// 自身刚加入时, 变更前的视图可能为空, 以不包含自身的当前视图推导旧所有者
+		if _, ok := old.httpClient[p.self]; !ok {
+			if _, ok := clients[p.self]; ok {
+				old = p.withoutSelf(clients)
+			}
+		}
+		rb.changed(old, state)
+	}
+}
+
+func sameMembers(a, b map[string]*httpClient) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for addr := range a {
+		if _, ok := b[addr]; !ok {
+			return false
+		}
+	}
+	return true
+}
+
+// 按节点列表构建选择算法, 跳过被剔除的节点和skip, 调用方需持有p.mu
+func (p *HTTPPool) buildPicker(clients map[string]*httpClient, skip string) consistence.Picker {
+	picker := p.defaultPicker()
+	if p.newPicker != nil {
+		picker = p.newPicker()
+	}
+
+	for addr := range clients {
+		if _, ok := p.ejected[addr]; ok || addr == skip {
+			continue
+		}
+		weight := 1
+		if w, ok := p.weights[addr]; ok {
+			weight = w
+		}
+		picker.AddWeightedNode(addr, weight)
+	}
+	return picker
+}
+
+// 不包含自身的节点视图, 即自身加入前的节点视图, 调用方需持有p.mu
+func (p *HTTPPool) withoutSelf(clients map[string]*httpClient) *peerState {
+	others := make(map[string]*httpClient, len(clients))
+	for addr, client := range clients {
+		if addr != p.self {
+			others[addr] = client
+		}
+	}
+	return &peerState{picker: p.buildPicker(others, p.self), httpClient: others}
+}
+
+func (p *HTTPPool) defaultPicker() consistence.Picker {
+	return consistence.NewMap(p.virtualNodes, p.hash)
+}
+
+// 返回当前所有节点, 包括被剔除的不健康节点
+func (p *HTTPPool) Peers() []string {
+	return p.peers.Load().members()
+}
+
+// 返回当前参与选择的健康节点
+func (p *HTTPPool) HealthyPeers() []string {
+	return p.peers.Load().picker.Nodes()
+}
+
+func (s *peerState) members() []string {
+	addrs := make([]string, 0, len(s.httpClient))
+	for addr := range s.httpClient {
+		addrs = append(addrs, addr)
+	}
+	sort.Strings(addrs)
+	return addrs
+}
+
+func (p *HTTPPool) newClient(addr string) *httpClient {
+	return &httpClient{addr: addr, baseURL: addr + p.basePath, pool: p}
+}
+
+// 根据具体的key, 选择节点, 返回节点对应的HTTP客户端
+func (p *HTTPPool) PickNodeClient(key string) (NodeClient, bool) {
+	state := p.peers.Load()
+
+	addr := state.picker.GetNode(key)
+	if addr != "" && addr != p.self {
 		p.Log("Pick node %s", addr)
-		return p.httpClient[addr], true
+		if r, ok := state.picker.(consistence.LoadReporter); ok {
+			return &pickedClient{httpClient: state.httpClient[addr], reporter: r}, true
+		}
+		return state.httpClient[addr], true
 	}
 
+	// 本地处理的请求不计入负载
+	if r, ok := state.picker.(consistence.LoadReporter); ok && addr != "" {
+		r.Done(addr)
+	}
 	return nil, false
 }
 
+// 按优先级返回key的n个副本节点的客户端, 自己作为副本时对应位置为nil
+func (p *HTTPPool) PickReplicaClients(key string, n int) []NodeClient {
+	state := p.peers.Load()
+
+	rp, ok := state.picker.(consistence.ReplicaPicker)
+	if !ok || n <= 1 {
+		if client, ok := p.PickNodeClient(key); ok {
+			return []NodeClient{client}
+		}
+		return []NodeClient{nil}
+	}
+
+	addrs := rp.GetNodes(key, n)
+	if len(addrs) == 0 {
+		return []NodeClient{nil}
+	}
+	clients := make([]NodeClient, 0, len(addrs))
+	for _, addr := range addrs {
+		if addr == p.self {
+			clients = append(clients, nil)
+			continue
+		}
+		clients = append(clients, state.httpClient[addr])
+	}
+	return clients
+}
+
 var _ NodeServer = (*HTTPPool)(nil)
+var _ ReplicaNodeServer = (*HTTPPool)(nil)
 
 // 客户端
 type httpClient struct {
+	addr    string
 	baseURL string
+	pool    *HTTPPool // 所属的服务端, 用于上报请求结果
+}
+
+func (h *httpClient) peerAddr() string {
+	return h.addr
 }
 
 func (h *httpClient) GetCacheValue(group string, key string) ([]byte, error) {
@@ -111,17 +632,20 @@ func (h *httpClient) GetCacheValue(group string, key string) ([]byte, error) {
 		url.QueryEscape(key),
 	)
 
-	res, err := http.Get(u)
+	res, err := getCompressed(h.pool.client, u)
 	if err != nil {
+		h.pool.reportResult(h.addr, false)
 		return nil, err
 	}
 	defer res.Body.Close()
 
+	// 只有节点不可用才计入失败, 数据源返回的错误不影响节点健康状态
+	h.pool.reportResult(h.addr, res.StatusCode != http.StatusServiceUnavailable)
 	if res.StatusCode != http.StatusOK {
 		return nil, fmt.Errorf("server returned: %v", res.Status)
 	}
 
-	bytes, err := io.ReadAll(res.Body)
+	bytes, err := readBody(res)
 	if err != nil {
 		return nil, fmt.Errorf("reading response body: %v", err)
 	}
@@ -129,4 +653,223 @@ func (h *httpClient) GetCacheValue(group string, key string) ([]byte, error) {
 	return bytes, nil
 }
 
+// 发送GET请求, 声明支持已注册的压缩算法
+func getCompressed(client *http.Client, u string) (*http.Response, error) {
+	req, err := http.NewRequest(http.MethodGet, u, nil)
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Accept-Encoding", acceptEncoding())
+	return client.Do(req)
+}
+
+// 读取响应, 按Content-Encoding解压
+func readBody(res *http.Response) ([]byte, error) {
+	body, err := io.ReadAll(res.Body)
+	if err != nil {
+		return nil, err
+	}
+	enc := res.Header.Get("Content-Encoding")
+	if enc == "" {
+		return body, nil
+	}
+	c, ok := compressorByName(enc)
+	if !ok {
+		return nil, fmt.Errorf("unsupported content encoding: %s", enc)
+	}
+	return c.Decompress(body)
+}
+
+func (h *httpClient) SetCacheValue(group string, key string, value []byte) error {
+	u := fmt.Sprintf(
+		"%v%v/%v",
+		h.baseURL,
+		url.QueryEscape(group),
+		url.QueryEscape(key),
+	)
+	return h.put(u, value)
+}
+
+// 将迁移的值写入新所有者, 保留剩余的过期时间, 新所有者不写预写日志
+func (h *httpClient) handoffCacheValue(group string, key string, value []byte, expireAt int64) error {
+	u := fmt.Sprintf(
+		"%v%v/%v?handoff=1&expire_at=%d",
+		h.baseURL,
+		url.QueryEscape(group),
+		url.QueryEscape(key),
+		expireAt,
+	)
+	return h.put(u, value)
+}
+
+func (h *httpClient) put(u string, value []byte) error {
+	req, err := http.NewRequest(http.MethodPut, u, bytes.NewReader(value))
+	if err != nil {
+		return err
+	}
+	res, err := h.pool.client.Do(req)
+	if err != nil {
+		h.pool.reportResult(h.addr, false)
+		return err
+	}
+	defer res.Body.Close()
+
+	h.pool.reportResult(h.addr, res.StatusCode != http.StatusServiceUnavailable)
+	if res.StatusCode != http.StatusNoContent {
+		return fmt.Errorf("server returned: %v", res.Status)
+	}
+	return nil
+}
+
+func (h *httpClient) DeleteCacheValue(group string, key string) error {
+	u := fmt.Sprintf(
+		"%v%v/%v",
+		h.baseURL,
+		url.QueryEscape(group),
+		url.QueryEscape(key),
+	)
+
+	req, err := http.NewRequest(http.MethodDelete, u, nil)
+	if err != nil {
+		return err
+	}
+	res, err := h.pool.client.Do(req)
+	if err != nil {
+		h.pool.reportResult(h.addr, false)
+		return err
+	}
+	defer res.Body.Close()
+
+	h.pool.reportResult(h.addr, res.StatusCode != http.StatusServiceUnavailable)
+	if res.StatusCode != http.StatusNoContent {
+		return fmt.Errorf("server returned: %v", res.Status)
+	}
+	return nil
+}
+
+func (h *httpClient) PeekCacheValue(group string, key string) ([]byte, error) {
+	u := fmt.Sprintf(
+		"%v%v/%v?peek=1",
+		h.baseURL,
+		url.QueryEscape(group),
+		url.QueryEscape(key),
+	)
+
+	res, err := getCompressed(h.pool.client, u)
+	if err != nil {
+		return nil, err
+	}
+	defer res.Body.Close()
+
+	if res.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("server returned: %v", res.Status)
+	}
+	return readBody(res)
+}
+
+func (h *httpClient) GetCacheValues(group string, keys []string) (map[string][]byte, map[string]error, error) {
+	body, err := json.Marshal(batchRequest{Keys: keys})
+	if err != nil {
+		return nil, nil, err
+	}
+
+	req, err := http.NewRequest(http.MethodPost, h.baseURL+url.QueryEscape(group), bytes.NewReader(body))
+	if err != nil {
+		return nil, nil, err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("Accept-Encoding", acceptEncoding())
+	res, err := h.pool.client.Do(req)
+	if err != nil {
+		h.pool.reportResult(h.addr, false)
+		return nil, nil, err
+	}
+	defer res.Body.Close()
+
+	h.pool.reportResult(h.addr, res.StatusCode != http.StatusServiceUnavailable)
+	if res.StatusCode != http.StatusOK {
+		return nil, nil, fmt.Errorf("server returned: %v", res.Status)
+	}
+
+	var batch batchResponse
+	if err := json.NewDecoder(res.Body).Decode(&batch); err != nil {
+		return nil, nil, fmt.Errorf("decoding response body: %v", err)
+	}
+	errs := make(map[string]error, len(batch.Errors))
+	for key, msg := range batch.Errors {
+		errs[key] = errors.New(msg)
+	}
+	for key, enc := range batch.Encodings {
+		value, ok := batch.Values[key]
+		if !ok {
+			continue
+		}
+		delete(batch.Values, key)
+		c, ok := compressorByName(enc)
+		if !ok {
+			errs[key] = fmt.Errorf("unsupported content encoding: %s", enc)
+			continue
+		}
+		raw, err := c.Decompress(value)
+		if err != nil {
+			errs[key] = fmt.Errorf("decompressing %s value: %v", enc, err)
+			continue
+		}
+		batch.Values[key] = raw
+	}
+	return batch.Values, errs, nil
+}
+
 var _ NodeClient = (*httpClient)(nil)
+var _ NodeSetter = (*httpClient)(nil)
+var _ NodeDeleter = (*httpClient)(nil)
+var _ NodePeeker = (*httpClient)(nil)
+var _ BatchNodeClient = (*httpClient)(nil)
+
+// 被选择算法选中的客户端, 第一次请求结束后通知选择算法更新负载
+type pickedClient struct {
+	*httpClient
+	reporter consistence.LoadReporter
+	once     sync.Once
+}
+
+// 选中后不再发送请求的客户端需要释放负载
+type loadReleaser interface {
+	release()
+}
+
+func (c *pickedClient) release() {
+	c.once.Do(func() { c.reporter.Done(c.addr) })
+}
+
+func (c *pickedClient) GetCacheValue(group string, key string) ([]byte, error) {
+	defer c.release()
+	return c.httpClient.GetCacheValue(group, key)
+}
+
+func (c *pickedClient) SetCacheValue(group string, key string, value []byte) error {
+	defer c.release()
+	return c.httpClient.SetCacheValue(group, key, value)
+}
+
+func (c *pickedClient) DeleteCacheValue(group string, key string) error {
+	defer c.release()
+	return c.httpClient.DeleteCacheValue(group, key)
+}
+
+func (c *pickedClient) PeekCacheValue(group string, key string) ([]byte, error) {
+	defer c.release()
+	return c.httpClient.PeekCacheValue(group, key)
+}
+
+func (c *pickedClient) GetCacheValues(group string, keys []string) (map[string][]byte, map[string]error, error) {
+	defer c.release()
+	return c.httpClient.GetCacheValues(group, keys)
+}
+
+// 释放client的负载, 不是pickedClient时不做处理
+func releaseLoad(client NodeClient) {
+	if r, ok := client.(loadReleaser); ok {
+		r.release()
+	}
+}
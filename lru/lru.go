@@ -97,9 +97,18 @@ func (c *LRUCache) Add(key string, value Value) {
 
 // 设置过期时间
 func (c *LRUCache) Expire(key string, second int64) {
+	// 不在缓存中的key(已被淘汰或删除)不记录过期时间, 否则清理时找不到对应的结点
+	if _, ok := c.cache[key]; !ok {
+		return
+	}
 	c.expireDict[key] = time.Now().Add(time.Duration(second) * time.Second).Unix()
 }
 
+// 清除过期时间, key不再过期
+func (c *LRUCache) Persist(key string) {
+	delete(c.expireDict, key)
+}
+
 // 检测key是否已经过期
 func (c *LRUCache) CheckKey(key string) bool {
 	if t, ok := c.expireDict[key]; ok && t < time.Now().Unix() {
@@ -110,10 +119,15 @@ func (c *LRUCache) CheckKey(key string) bool {
 
 // 定期清理key
 func (c *LRUCache) CleanupExpiredKeys() {
-	checkKeysIndex := make(map[int64]struct{}, 5)
+	// 每次随机抽查5个key, 数量不足时抽查全部
+	samples := 5
+	if c.length < int64(samples) {
+		samples = int(c.length)
+	}
+	checkKeysIndex := make(map[int64]struct{}, samples)
 	rand.Seed(time.Now().UnixNano())
 
-	for i := 0; i < 5; i++ {
+	for i := 0; i < samples; i++ {
 		keyIdx := rand.Int63n(c.length)
 		if _, ok := checkKeysIndex[keyIdx]; ok {
 			i--
@@ -126,17 +140,52 @@ func (c *LRUCache) CleanupExpiredKeys() {
 	var i int64
 	for key, expireTime := range c.expireDict {
 		if _, ok := checkKeysIndex[i]; ok && expireTime <= now {
-			c.RemoveNode(c.cache[key])
+			if ele, ok := c.cache[key]; ok {
+				c.RemoveNode(ele)
+			} else {
+				delete(c.expireDict, key)
+			}
 		}
 		i++
 	}
 }
 
+// 删除key, 不触发OnEvivted
+func (c *LRUCache) Remove(key string) {
+	if ele, ok := c.cache[key]; ok {
+		c.RemoveNode(ele)
+	}
+}
+
 // 删除结点
 func (c *LRUCache) RemoveNode(node *list.Element) {
+	if node == nil {
+		return
+	}
 	c.list.Remove(node)
 	c.length--
 	kv := node.Value.(*entry)
 	delete(c.cache, kv.key)
 	delete(c.expireDict, kv.key)
 }
+
+// 当前缓存数量
+func (c *LRUCache) Len() int64 {
+	return c.length
+}
+
+// 按最近访问顺序返回最多n个未过期的key, n小于等于0时返回全部
+func (c *LRUCache) Keys(n int) []string {
+	keys := make([]string, 0)
+	for ele := c.list.Front(); ele != nil; ele = ele.Next() {
+		if n > 0 && len(keys) >= n {
+			break
+		}
+		kv := ele.Value.(*entry)
+		if c.CheckKey(kv.key) {
+			continue
+		}
+		keys = append(keys, kv.key)
+	}
+	return keys
+}
@@ -0,0 +1,629 @@
+package membership
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"math/rand"
+	"net"
+	"sort"
+	"sync"
+	"time"
+)
+
+// 成员状态
+type State uint8
+
+const (
+	StateAlive   State = iota // 存活
+	StateSuspect              // 疑似故障, 仍然被视为集群成员
+	StateDead                 // 已确认故障
+	StateLeft                 // 主动离开
+)
+
+func (s State) String() string {
+	switch s {
+	case StateAlive:
+		return "alive"
+	case StateSuspect:
+		return "suspect"
+	case StateDead:
+		return "dead"
+	case StateLeft:
+		return "left"
+	}
+	return fmt.Sprintf("State(%d)", s)
+}
+
+// 集群成员
+type Member struct {
+	Name        string // 唯一名称, 一般为节点对外的缓存服务地址, 如http://localhost:8001
+	Addr        string // gossip通讯地址(UDP)
+	State       State  // 当前状态
+	Incarnation uint64 // 版本号, 只有节点自己可以增加, 用于反驳怀疑
+}
+
+// 配置
+type Config struct {
+	Name             string        // 节点名称
+	BindAddr         string        // gossip监听地址, 如127.0.0.1:0
+	ProbeInterval    time.Duration // 探测周期
+	ProbeTimeout     time.Duration // 直接探测的超时时间, 超时后发起间接探测
+	SuspicionTimeout time.Duration // 疑似故障的节点在该时间内未反驳, 则判定为故障
+	IndirectChecks   int           // 间接探测时委托的节点数
+	RetransmitMult   int           // 状态变更的重传倍数
+	MaxPiggyback     int           // 每条消息最多捎带的状态变更数
+
+	OnJoin  func(m Member) // 节点加入集群(包括自己)时的回调, 可以为nil
+	OnLeave func(m Member) // 节点故障或离开时的回调, 可以为nil
+}
+
+// 默认配置
+func DefaultConfig(name, bindAddr string) Config {
+	return Config{
+		Name:             name,
+		BindAddr:         bindAddr,
+		ProbeInterval:    time.Second,
+		ProbeTimeout:     500 * time.Millisecond,
+		SuspicionTimeout: 5 * time.Second,
+		IndirectChecks:   3,
+		RetransmitMult:   4,
+		MaxPiggyback:     16,
+	}
+}
+
+const maxPacketSize = 65536
+
+var ErrClosed = errors.New("membership: closed")
+
+// 成员状态及故障检测计时器
+type memberState struct {
+	Member
+	suspectTimer *time.Timer
+}
+
+// SWIM协议的成员列表
+//
+// 每个探测周期选择一个节点发送ping, 超时未收到ack则委托其他节点间接探测,
+// 仍然失败则将其标记为疑似故障(suspect), 超过SuspicionTimeout未反驳则判定为故障(dead).
+// 成员状态变更捎带在ping/ack等消息上以gossip方式传播.
+type Memberlist struct {
+	conf Config
+	conn net.PacketConn
+
+	mu         sync.Mutex
+	self       *memberState
+	members    map[string]*memberState  // 全部已知成员(包括故障节点), key为名称
+	acks       map[uint64]chan struct{} // 等待中的探测, key为序列号
+	queue      broadcastQueue           // 待传播的状态变更
+	seq        uint64
+	probeIndex int
+	leaving    bool
+
+	events   chan event
+	stopCh   chan struct{}
+	stopOnce sync.Once
+	wg       sync.WaitGroup
+}
+
+type event struct {
+	join   bool
+	member Member
+}
+
+// 创建成员列表并开始监听, 此时集群中只有自己
+func Create(conf Config) (*Memberlist, error) {
+	if conf.Name == "" {
+		return nil, errors.New("membership: name is required")
+	}
+	def := DefaultConfig(conf.Name, conf.BindAddr)
+	if conf.ProbeInterval <= 0 {
+		conf.ProbeInterval = def.ProbeInterval
+	}
+	if conf.ProbeTimeout <= 0 || conf.ProbeTimeout >= conf.ProbeInterval {
+		conf.ProbeTimeout = conf.ProbeInterval / 2
+	}
+	if conf.SuspicionTimeout <= 0 {
+		conf.SuspicionTimeout = 5 * conf.ProbeInterval
+	}
+	if conf.IndirectChecks <= 0 {
+		conf.IndirectChecks = def.IndirectChecks
+	}
+	if conf.RetransmitMult <= 0 {
+		conf.RetransmitMult = def.RetransmitMult
+	}
+	if conf.MaxPiggyback <= 0 {
+		conf.MaxPiggyback = def.MaxPiggyback
+	}
+
+	conn, err := net.ListenPacket("udp", conf.BindAddr)
+	if err != nil {
+		return nil, err
+	}
+
+	m := &Memberlist{
+		conf:    conf,
+		conn:    conn,
+		members: make(map[string]*memberState),
+		acks:    make(map[uint64]chan struct{}),
+		events:  make(chan event, 64),
+		stopCh:  make(chan struct{}),
+	}
+	m.self = &memberState{Member: Member{
+		Name:  conf.Name,
+		Addr:  conn.LocalAddr().String(),
+		State: StateAlive,
+	}}
+	m.members[conf.Name] = m.self
+	m.queue.push(m.self.update())
+	m.events <- event{join: true, member: m.self.Member}
+
+	m.wg.Add(3)
+	go m.readLoop()
+	go m.probeLoop()
+	go m.eventLoop()
+	return m, nil
+}
+
+// gossip监听地址
+func (m *Memberlist) Addr() string {
+	return m.self.Addr
+}
+
+func (m *Memberlist) Log(format string, v ...interface{}) {
+	log.Printf("[Membership %s] %s \n", m.conf.Name, fmt.Sprintf(format, v...))
+}
+
+// 通过种子节点加入集群, 只要有一个种子节点应答即视为成功
+func (m *Memberlist) Join(seeds ...string) error {
+	var lastErr error
+	for _, seed := range seeds {
+		seq, ch := m.registerAck()
+		m.mu.Lock()
+		self := m.self.update()
+		m.mu.Unlock()
+		m.send(seed, &message{Type: msgJoin, Seq: seq, Updates: []update{self}})
+
+		select {
+		case <-ch:
+			m.unregisterAck(seq)
+			return nil
+		case <-time.After(2 * m.conf.ProbeInterval):
+			lastErr = fmt.Errorf("membership: join %s timeout", seed)
+		case <-m.stopCh:
+			m.unregisterAck(seq)
+			return ErrClosed
+		}
+		m.unregisterAck(seq)
+	}
+	if lastErr == nil {
+		lastErr = errors.New("membership: no seeds")
+	}
+	return lastErr
+}
+
+// 当前集群成员(存活及疑似故障), 按名称排序
+func (m *Memberlist) Members() []Member {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	members := make([]Member, 0, len(m.members))
+	for _, ms := range m.members {
+		if ms.State == StateAlive || ms.State == StateSuspect {
+			members = append(members, ms.Member)
+		}
+	}
+	sort.Slice(members, func(i, j int) bool {
+		return members[i].Name < members[j].Name
+	})
+	return members
+}
+
+// 主动离开集群, 将离开消息直接通知给所有成员
+func (m *Memberlist) Leave() error {
+	m.mu.Lock()
+	m.leaving = true
+	m.self.State = StateLeft
+	m.self.Incarnation++
+	u := m.self.update()
+	var addrs []string
+	for _, ms := range m.members {
+		if ms != m.self && (ms.State == StateAlive || ms.State == StateSuspect) {
+			addrs = append(addrs, ms.Addr)
+		}
+	}
+	m.mu.Unlock()
+
+	for _, addr := range addrs {
+		m.send(addr, &message{Type: msgGossip, Updates: []update{u}})
+	}
+	return nil
+}
+
+// 停止所有后台协程并关闭连接
+func (m *Memberlist) Shutdown() error {
+	var err error
+	m.stopOnce.Do(func() {
+		close(m.stopCh)
+		err = m.conn.Close()
+		m.wg.Wait()
+
+		m.mu.Lock()
+		for _, ms := range m.members {
+			if ms.suspectTimer != nil {
+				ms.suspectTimer.Stop()
+			}
+		}
+		m.mu.Unlock()
+	})
+	return err
+}
+
+func (ms *memberState) update() update {
+	return update{
+		Name:        ms.Name,
+		Addr:        ms.Addr,
+		State:       ms.State,
+		Incarnation: ms.Incarnation,
+	}
+}
+
+func (m *Memberlist) readLoop() {
+	defer m.wg.Done()
+
+	buf := make([]byte, maxPacketSize)
+	for {
+		n, from, err := m.conn.ReadFrom(buf)
+		if err != nil {
+			select {
+			case <-m.stopCh:
+				return
+			default:
+			}
+			m.Log("read error: %v", err)
+			continue
+		}
+
+		msg, err := decode(buf[:n])
+		if err != nil {
+			m.Log("bad message from %s: %v", from, err)
+			continue
+		}
+		m.handle(msg, from.String())
+	}
+}
+
+func (m *Memberlist) handle(msg *message, from string) {
+	for _, u := range msg.Updates {
+		m.merge(u)
+	}
+
+	switch msg.Type {
+	case msgPing:
+		if msg.Target != "" && msg.Target != m.conf.Name {
+			return
+		}
+		m.send(from, &message{Type: msgAck, Seq: msg.Seq})
+	case msgAck:
+		m.mu.Lock()
+		ch, ok := m.acks[msg.Seq]
+		m.mu.Unlock()
+		if ok {
+			select {
+			case ch <- struct{}{}:
+			default:
+			}
+		}
+	case msgPingReq:
+		go m.indirectProbe(msg, from)
+	case msgJoin:
+		m.mu.Lock()
+		state := make([]update, 0, len(m.members))
+		for _, ms := range m.members {
+			state = append(state, ms.update())
+		}
+		m.mu.Unlock()
+		m.sendRaw(from, &message{Type: msgAck, Seq: msg.Seq, From: m.conf.Name, Updates: state})
+	case msgGossip:
+	}
+}
+
+// 代替其他节点探测目标节点, 收到应答后转发给请求方
+func (m *Memberlist) indirectProbe(req *message, from string) {
+	seq, ch := m.registerAck()
+	defer m.unregisterAck(seq)
+
+	m.send(req.TargetAddr, &message{Type: msgPing, Seq: seq, Target: req.Target})
+	select {
+	case <-ch:
+		m.send(from, &message{Type: msgAck, Seq: req.Seq})
+	case <-time.After(m.conf.ProbeTimeout):
+	case <-m.stopCh:
+	}
+}
+
+func (m *Memberlist) probeLoop() {
+	defer m.wg.Done()
+
+	ticker := time.NewTicker(m.conf.ProbeInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ticker.C:
+			m.probe()
+		case <-m.stopCh:
+			return
+		}
+	}
+}
+
+// 一轮探测
+func (m *Memberlist) probe() {
+	target, ok := m.nextProbeTarget()
+	if !ok {
+		return
+	}
+
+	seq, ch := m.registerAck()
+	defer m.unregisterAck(seq)
+
+	m.send(target.Addr, &message{Type: msgPing, Seq: seq, Target: target.Name})
+	select {
+	case <-ch:
+		return
+	case <-time.After(m.conf.ProbeTimeout):
+	case <-m.stopCh:
+		return
+	}
+
+	// 直接探测超时, 委托其他节点间接探测
+	for _, peer := range m.randomMembers(m.conf.IndirectChecks, target.Name) {
+		m.send(peer.Addr, &message{
+			Type:       msgPingReq,
+			Seq:        seq,
+			Target:     target.Name,
+			TargetAddr: target.Addr,
+		})
+	}
+	select {
+	case <-ch:
+		return
+	case <-time.After(m.conf.ProbeInterval - m.conf.ProbeTimeout):
+	case <-m.stopCh:
+		return
+	}
+
+	m.Log("Suspect %s", target.Name)
+	m.merge(update{
+		Name:        target.Name,
+		Addr:        target.Addr,
+		State:       StateSuspect,
+		Incarnation: target.Incarnation,
+	})
+}
+
+// 轮询选择下一个探测目标
+func (m *Memberlist) nextProbeTarget() (Member, bool) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	var candidates []Member
+	for _, ms := range m.members {
+		if ms != m.self && (ms.State == StateAlive || ms.State == StateSuspect) {
+			candidates = append(candidates, ms.Member)
+		}
+	}
+	if len(candidates) == 0 {
+		return Member{}, false
+	}
+	sort.Slice(candidates, func(i, j int) bool {
+		return candidates[i].Name < candidates[j].Name
+	})
+
+	m.probeIndex++
+	return candidates[m.probeIndex%len(candidates)], true
+}
+
+// 随机选择最多k个存活节点, 排除自己和exclude
+func (m *Memberlist) randomMembers(k int, exclude string) []Member {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	var candidates []Member
+	for _, ms := range m.members {
+		if ms != m.self && ms.Name != exclude && ms.State == StateAlive {
+			candidates = append(candidates, ms.Member)
+		}
+	}
+	rand.Shuffle(len(candidates), func(i, j int) {
+		candidates[i], candidates[j] = candidates[j], candidates[i]
+	})
+	if len(candidates) > k {
+		candidates = candidates[:k]
+	}
+	return candidates
+}
+
+func (m *Memberlist) registerAck() (uint64, chan struct{}) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	m.seq++
+	ch := make(chan struct{}, 1)
+	m.acks[m.seq] = ch
+	return m.seq, ch
+}
+
+func (m *Memberlist) unregisterAck(seq uint64) {
+	m.mu.Lock()
+	delete(m.acks, seq)
+	m.mu.Unlock()
+}
+
+// 发送消息, 并捎带待传播的状态变更
+func (m *Memberlist) send(addr string, msg *message) {
+	m.mu.Lock()
+	msg.From = m.conf.Name
+	msg.Updates = append(msg.Updates, m.queue.take(
+		m.conf.MaxPiggyback,
+		retransmitLimit(m.conf.RetransmitMult, len(m.members)),
+	)...)
+	m.mu.Unlock()
+
+	m.sendRaw(addr, msg)
+}
+
+func (m *Memberlist) sendRaw(addr string, msg *message) {
+	b, err := encode(msg)
+	if err != nil {
+		m.Log("encode message: %v", err)
+		return
+	}
+	udpAddr, err := net.ResolveUDPAddr("udp", addr)
+	if err != nil {
+		m.Log("resolve %s: %v", addr, err)
+		return
+	}
+	if _, err := m.conn.WriteTo(b, udpAddr); err != nil {
+		select {
+		case <-m.stopCh:
+		default:
+			m.Log("send to %s: %v", addr, err)
+		}
+	}
+}
+
+// 合并一条状态变更
+func (m *Memberlist) merge(u update) {
+	m.mu.Lock()
+	evt, ok := m.mergeLocked(u)
+	m.mu.Unlock()
+
+	if ok {
+		select {
+		case m.events <- evt:
+		case <-m.stopCh:
+		}
+	}
+}
+
+func (m *Memberlist) mergeLocked(u update) (event, bool) {
+	if u.Name == m.conf.Name {
+		m.refute(u)
+		return event{}, false
+	}
+
+	cur, ok := m.members[u.Name]
+	if !ok {
+		if u.State != StateAlive {
+			// 未知节点的故障消息只记录版本号, 防止之后收到过期的存活消息
+			m.members[u.Name] = &memberState{Member: Member(u)}
+			return event{}, false
+		}
+		cur = &memberState{Member: Member(u)}
+		m.members[u.Name] = cur
+		m.queue.push(u)
+		return event{join: true, member: cur.Member}, true
+	}
+
+	wasMember := cur.State == StateAlive || cur.State == StateSuspect
+	switch u.State {
+	case StateAlive:
+		if u.Incarnation <= cur.Incarnation {
+			return event{}, false
+		}
+	case StateSuspect:
+		if !wasMember {
+			return event{}, false
+		}
+		if u.Incarnation < cur.Incarnation ||
+			(u.Incarnation == cur.Incarnation && cur.State == StateSuspect) {
+			return event{}, false
+		}
+	case StateDead, StateLeft:
+		if u.Incarnation < cur.Incarnation || !wasMember {
+			if u.Incarnation > cur.Incarnation {
+				cur.Incarnation = u.Incarnation
+			}
+			return event{}, false
+		}
+	}
+
+	cur.Addr = u.Addr
+	cur.State = u.State
+	cur.Incarnation = u.Incarnation
+	if cur.suspectTimer != nil {
+		cur.suspectTimer.Stop()
+		cur.suspectTimer = nil
+	}
+	if u.State == StateSuspect {
+		name, inc := u.Name, u.Incarnation
+		cur.suspectTimer = time.AfterFunc(m.conf.SuspicionTimeout, func() {
+			m.suspicionExpired(name, inc)
+		})
+	}
+	m.queue.push(u)
+
+	isMember := cur.State == StateAlive || cur.State == StateSuspect
+	if wasMember == isMember {
+		return event{}, false
+	}
+	if isMember {
+		m.Log("Member %s joined", cur.Name)
+	} else {
+		m.Log("Member %s %s", cur.Name, cur.State)
+	}
+	return event{join: isMember, member: cur.Member}, true
+}
+
+// 收到关于自己的状态变更, 如果是怀疑或故障消息, 则增加版本号反驳
+func (m *Memberlist) refute(u update) {
+	if m.leaving || u.Incarnation < m.self.Incarnation {
+		return
+	}
+	if u.State == StateAlive && u.Incarnation == m.self.Incarnation {
+		return
+	}
+
+	m.self.Incarnation = u.Incarnation + 1
+	m.queue.push(m.self.update())
+	m.Log("Refute %s with incarnation %d", u.State, m.self.Incarnation)
+}
+
+// 怀疑超时, 判定节点故障
+func (m *Memberlist) suspicionExpired(name string, inc uint64) {
+	m.mu.Lock()
+	cur, ok := m.members[name]
+	if !ok || cur.State != StateSuspect || cur.Incarnation != inc {
+		m.mu.Unlock()
+		return
+	}
+	addr := cur.Addr
+	m.mu.Unlock()
+
+	m.merge(update{
+		Name:        name,
+		Addr:        addr,
+		State:       StateDead,
+		Incarnation: inc,
+	})
+}
+
+// 串行执行回调, 保证事件顺序
+func (m *Memberlist) eventLoop() {
+	defer m.wg.Done()
+
+	for {
+		select {
+		case evt := <-m.events:
+			if evt.join && m.conf.OnJoin != nil {
+				m.conf.OnJoin(evt.member)
+			} else if !evt.join && m.conf.OnLeave != nil {
+				m.conf.OnLeave(evt.member)
+			}
+		case <-m.stopCh:
+			return
+		}
+	}
+}
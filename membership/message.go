@@ -0,0 +1,106 @@
+package membership
+
+import (
+	"encoding/json"
+	"math"
+	"sort"
+)
+
+// 消息类型
+type msgType uint8
+
+const (
+	msgPing    msgType = iota // 直接探测
+	msgAck                    // 探测应答
+	msgPingReq                // 间接探测请求, 由中间节点代为探测目标节点
+	msgJoin                   // 加入集群, 应答中携带完整的成员状态
+	msgGossip                 // 单纯的状态传播, 不需要应答
+)
+
+// 节点间传输的消息, 通过JSON编码后以UDP报文发送
+type message struct {
+	Type       msgType  `json:"t"`
+	Seq        uint64   `json:"seq,omitempty"`
+	From       string   `json:"from,omitempty"`        // 发送方名称
+	Target     string   `json:"target,omitempty"`      // 探测目标名称
+	TargetAddr string   `json:"target_addr,omitempty"` // 探测目标的gossip地址, 仅用于间接探测
+	Updates    []update `json:"updates,omitempty"`     // 捎带的成员状态变更
+}
+
+// 成员状态变更
+type update struct {
+	Name        string `json:"name"`
+	Addr        string `json:"addr"`
+	State       State  `json:"state"`
+	Incarnation uint64 `json:"inc"`
+}
+
+func encode(msg *message) ([]byte, error) {
+	return json.Marshal(msg)
+}
+
+func decode(b []byte) (*message, error) {
+	msg := new(message)
+	if err := json.Unmarshal(b, msg); err != nil {
+		return nil, err
+	}
+	return msg, nil
+}
+
+// 待传播的状态变更, 每条变更会被捎带在若干条消息上发送出去
+type broadcast struct {
+	u         update
+	transmits int // 已发送次数
+}
+
+// 状态变更队列, 同一节点只保留最新的一条
+type broadcastQueue struct {
+	items map[string]*broadcast
+}
+
+func (q *broadcastQueue) push(u update) {
+	if q.items == nil {
+		q.items = make(map[string]*broadcast)
+	}
+	q.items[u.Name] = &broadcast{u: u}
+}
+
+// 取出最多limit条发送次数最少的变更, 发送次数超过retransmit的变更会被丢弃
+func (q *broadcastQueue) take(limit, retransmit int) []update {
+	if len(q.items) == 0 {
+		return nil
+	}
+
+	all := make([]*broadcast, 0, len(q.items))
+	for _, b := range q.items {
+		all = append(all, b)
+	}
+	sort.Slice(all, func(i, j int) bool {
+		if all[i].transmits != all[j].transmits {
+			return all[i].transmits < all[j].transmits
+		}
+		return all[i].u.Name < all[j].u.Name
+	})
+
+	if len(all) > limit {
+		all = all[:limit]
+	}
+	updates := make([]update, 0, len(all))
+	for _, b := range all {
+		updates = append(updates, b.u)
+		b.transmits++
+		if b.transmits >= retransmit {
+			delete(q.items, b.u.Name)
+		}
+	}
+	return updates
+}
+
+// 每条变更的重传次数, 随集群规模对数增长
+func retransmitLimit(mult, n int) int {
+	limit := mult * int(math.Ceil(math.Log10(float64(n+1))))
+	if limit < 1 {
+		limit = 1
+	}
+	return limit
+}
@@ -11,3 +11,40 @@ type NodeClient interface {
 	// 从对应group查找缓存值
 	GetCacheValue(group string, key string) ([]byte, error)
 }
+
+// 支持多副本的节点服务
+type ReplicaNodeServer interface {
+	NodeServer
+	// 按优先级返回key的n个副本节点的客户端, 自己作为副本时对应位置为nil
+	PickReplicaClients(key string, n int) []NodeClient
+}
+
+// 支持写入的远程节点客户端
+type NodeSetter interface {
+	// 将缓存值写入对应group
+	SetCacheValue(group string, key string, value []byte) error
+}
+
+// 支持删除的远程节点客户端
+type NodeDeleter interface {
+	// 删除对应group的缓存值
+	DeleteCacheValue(group string, key string) error
+}
+
+// 支持节点变更时数据迁移的节点服务
+type HandoffNodeServer interface {
+	// 在迁移窗口内, 如果key在节点变更前属于其他节点, 返回该节点的客户端
+	PickPreviousClient(key string) (NodeClient, bool)
+}
+
+// 支持只读查询的远程节点客户端
+type NodePeeker interface {
+	// 只查询远程节点的缓存, 未命中时返回错误, 不会触发远程节点的数据源加载
+	PeekCacheValue(group string, key string) ([]byte, error)
+}
+
+// 支持批量查询的远程节点客户端
+type BatchNodeClient interface {
+	// 批量查询对应group的缓存值, 返回每个key的值或错误; 整个请求失败时返回error
+	GetCacheValues(group string, keys []string) (map[string][]byte, map[string]error, error)
+}
@@ -0,0 +1,321 @@
+package geecache
+
+import (
+	"fmt"
+	"geecache/consistence"
+	"geecache/wal"
+	"log"
+	"net/http"
+	"strings"
+	"time"
+)
+
+const (
+	defaultTTL             = 7 * 24 * time.Hour // 缓存值的默认过期时间
+	defaultCleanupInterval = 10 * time.Minute   // 默认的过期key清理周期
+)
+
+// 日志接口, *log.Logger实现了该接口
+type Logger interface {
+	Printf(format string, v ...interface{})
+}
+
+// 加载来源, 用于Metrics.Load
+const (
+	LoadFromPeer     = "peer"     // 其他节点
+	LoadFromPrevious = "previous" // 节点变更前的旧所有者
+	LoadFromSource   = "source"   // 数据源
+)
+
+// 指标收集接口, 可以对接Prometheus等, 只需要部分指标时可以嵌入NopMetrics
+type Metrics interface {
+	CacheHit(group string)
+	CacheMiss(group string)
+	// 一次未命中后的加载, source为LoadFrom*
+	Load(group string, source string, d time.Duration, err error)
+	// 一次对其他节点的请求, ok为false表示节点不可用
+	PeerRequest(peer string, ok bool)
+}
+
+// 不做任何处理的Metrics
+type NopMetrics struct{}
+
+func (NopMetrics) CacheHit(string)                           {}
+func (NopMetrics) CacheMiss(string)                          {}
+func (NopMetrics) Load(string, string, time.Duration, error) {}
+func (NopMetrics) PeerRequest(string, bool)                  {}
+
+// 缓存淘汰策略
+type EvictionPolicy int
+
+const (
+	EvictDefault EvictionPolicy = iota // 由存储方式决定: StorageLRU为LRU, StorageArena为FIFO
+	EvictLRU                           // 淘汰最久未访问的key
+	EvictFIFO                          // 淘汰最早写入的key, 读取不更新顺序
+)
+
+// group的配置项
+type GroupOption func(g *CacheGroup) error
+
+// 最大缓存数量, 为0时不限制
+func WithCapacity(capacity int64) GroupOption {
+	return func(g *CacheGroup) error {
+		if capacity < 0 {
+			return fmt.Errorf("capacity must not be negative: %d", capacity)
+		}
+		g.capacity = capacity
+		return nil
+	}
+}
+
+func WithEvictionPolicy(policy EvictionPolicy) GroupOption {
+	return func(g *CacheGroup) error {
+		if policy < EvictDefault || policy > EvictFIFO {
+			return fmt.Errorf("unknown eviction policy: %d", policy)
+		}
+		g.eviction = policy
+		return nil
+	}
+}
+
+// 缓存值的默认过期时间, 为0时不过期, 否则不能小于1秒
+func WithTTL(ttl time.Duration) GroupOption {
+	return func(g *CacheGroup) error {
+		if ttl != 0 && ttl < time.Second {
+			return fmt.Errorf("ttl must be 0 or at least 1s: %v", ttl)
+		}
+		g.ttl = ttl
+		return nil
+	}
+}
+
+// 过期key的清理周期
+func WithCleanupInterval(interval time.Duration) GroupOption {
+	return func(g *CacheGroup) error {
+		if interval <= 0 {
+			return fmt.Errorf("cleanup interval must be positive: %v", interval)
+		}
+		g.cleanupInterval = interval
+		return nil
+	}
+}
+
+// 缓存分片数量
+func WithShards(n int) GroupOption {
+	return func(g *CacheGroup) error {
+		if n <= 0 {
+			return fmt.Errorf("shards must be positive: %d", n)
+		}
+		g.shards = n
+		return nil
+	}
+}
+
+// 缓存存储方式, arenaSize为StorageArena模式下的总字节数, 为0时使用默认值
+func WithStorage(mode StorageMode, arenaSize int64) GroupOption {
+	return func(g *CacheGroup) error {
+		if mode != StorageLRU && mode != StorageArena {
+			return fmt.Errorf("unknown storage mode: %d", mode)
+		}
+		if arenaSize < 0 {
+			return fmt.Errorf("arena size must not be negative: %d", arenaSize)
+		}
+		g.storage = mode
+		g.arenaSize = arenaSize
+		return nil
+	}
+}
+
+// 副本数, 读取时按顺序尝试各副本; writeThrough为true时Set会同步写入所有副本
+func WithReplication(replicas int, writeThrough bool) GroupOption {
+	return func(g *CacheGroup) error {
+		if replicas < 1 {
+			return fmt.Errorf("replicas must be at least 1: %d", replicas)
+		}
+		g.replicas = replicas
+		g.writeThrough = writeThrough
+		return nil
+	}
+}
+
+// 压缩算法, 大小达到threshold字节的值压缩后存入缓存
+func WithCompression(c Compressor, threshold int) GroupOption {
+	return func(g *CacheGroup) error {
+		if c == nil {
+			return fmt.Errorf("nil compressor")
+		}
+		if threshold < 0 {
+			return fmt.Errorf("compression threshold must not be negative: %d", threshold)
+		}
+		g.compressor = c
+		g.compressMin = threshold
+		return nil
+	}
+}
+
+// 批量加载的合并窗口和最大批量, 仅在getter实现了BatchGetter时生效
+func WithBatchWindow(window time.Duration, maxBatch int) GroupOption {
+	return func(g *CacheGroup) error {
+		if window <= 0 || maxBatch <= 0 {
+			return fmt.Errorf("batch window and size must be positive: %v, %d", window, maxBatch)
+		}
+		g.batchWindow = window
+		g.maxBatch = maxBatch
+		return nil
+	}
+}
+
+func WithLogger(l Logger) GroupOption {
+	return func(g *CacheGroup) error {
+		if l == nil {
+			return fmt.Errorf("nil logger")
+		}
+		g.logger = l
+		return nil
+	}
+}
+
+func WithMetrics(m Metrics) GroupOption {
+	return func(g *CacheGroup) error {
+		if m == nil {
+			return fmt.Errorf("nil metrics")
+		}
+		g.metrics = m
+		return nil
+	}
+}
+
+// 将缓存快照写入dir, interval为定期快照的周期, 为0时只在Close时写入
+//
+// 启动时的恢复由调用方通过Recover完成, 开启预写日志时同时重放日志.
+func WithSnapshotDir(dir string, interval time.Duration) GroupOption {
+	return func(g *CacheGroup) error {
+		if dir == "" {
+			return fmt.Errorf("snapshot dir is required")
+		}
+		if interval < 0 {
+			return fmt.Errorf("snapshot interval must not be negative: %v", interval)
+		}
+		g.snapshotDir = dir
+		g.snapshotInterval = interval
+		return nil
+	}
+}
+
+// 开启预写日志, 本地的Set和Delete先写入日志, 崩溃后通过Recover在快照之上重放
+//
+// 必须同时配置WithSnapshotDir, 日志只在写入快照后删除快照之前的段, 否则会无限增长;
+// 快照周期为0时只在Close时清理.
+func WithWAL(conf wal.Config) GroupOption {
+	return func(g *CacheGroup) error {
+		if conf.Dir == "" {
+			return fmt.Errorf("wal dir is required")
+		}
+		g.walConf = &conf
+		return nil
+	}
+}
+
+// HTTPPool的配置项
+type PoolOption func(p *HTTPPool) error
+
+// 节点间通讯地址的前缀, 需要以/开头和结尾
+func WithBasePath(path string) PoolOption {
+	return func(p *HTTPPool) error {
+		if !strings.HasPrefix(path, "/") || !strings.HasSuffix(path, "/") || path == "/" {
+			return fmt.Errorf("base path must start and end with /: %q", path)
+		}
+		p.basePath = path
+		return nil
+	}
+}
+
+// 管理接口地址的前缀, 需要以/开头和结尾
+func WithAdminPath(path string) PoolOption {
+	return func(p *HTTPPool) error {
+		if !strings.HasPrefix(path, "/") || !strings.HasSuffix(path, "/") || path == "/" {
+			return fmt.Errorf("admin path must start and end with /: %q", path)
+		}
+		p.adminPath = path
+		return nil
+	}
+}
+
+// 一致性哈希环中每个节点的虚拟节点数量
+func WithVirtualNodes(n int) PoolOption {
+	return func(p *HTTPPool) error {
+		if n <= 0 {
+			return fmt.Errorf("virtual nodes must be positive: %d", n)
+		}
+		p.virtualNodes = n
+		return nil
+	}
+}
+
+// 一致性哈希环使用的哈希函数
+func WithHashFunc(fn consistence.Hash) PoolOption {
+	return func(p *HTTPPool) error {
+		if fn == nil {
+			return fmt.Errorf("nil hash func")
+		}
+		p.hash = fn
+		return nil
+	}
+}
+
+// 节点选择算法, 默认为一致性哈希环
+func WithPicker(newPicker func() consistence.Picker) PoolOption {
+	return func(p *HTTPPool) error {
+		if newPicker == nil {
+			return fmt.Errorf("nil picker constructor")
+		}
+		p.newPicker = newPicker
+		return nil
+	}
+}
+
+// 访问其他节点使用的Transport
+func WithTransport(rt http.RoundTripper) PoolOption {
+	return func(p *HTTPPool) error {
+		if rt == nil {
+			return fmt.Errorf("nil transport")
+		}
+		p.transport = rt
+		return nil
+	}
+}
+
+// 提供服务的group注册表, 默认为DefaultRegistry
+func WithRegistry(r *Registry) PoolOption {
+	return func(p *HTTPPool) error {
+		if r == nil {
+			return fmt.Errorf("nil registry")
+		}
+		p.registry = r
+		return nil
+	}
+}
+
+func WithPoolLogger(l Logger) PoolOption {
+	return func(p *HTTPPool) error {
+		if l == nil {
+			return fmt.Errorf("nil logger")
+		}
+		p.logger = l
+		return nil
+	}
+}
+
+func WithPoolMetrics(m Metrics) PoolOption {
+	return func(p *HTTPPool) error {
+		if m == nil {
+			return fmt.Errorf("nil metrics")
+		}
+		p.metrics = m
+		return nil
+	}
+}
+
+func defaultLogger() Logger {
+	return log.Default()
+}
@@ -0,0 +1,193 @@
+package geecache
+
+import (
+	"geecache/consistence"
+	"sync"
+	"time"
+)
+
+// 节点变更时的数据迁移配置
+type HandoffConfig struct {
+	Window       time.Duration // 迁移窗口, 窗口内新所有者未命中的key先从旧所有者读取
+	TransferKeys int           // 节点变更后主动迁移的热点key数量(每个group), 为0时不主动迁移
+	TransferRate int           // 主动迁移的速率(key/秒), 超过100000时按100000
+}
+
+// 主动迁移速率的上限, 保证发送间隔大于0
+const maxTransferRate = 100000
+
+func DefaultHandoffConfig() HandoffConfig {
+	return HandoffConfig{
+		Window:       time.Minute,
+		TransferKeys: 1000,
+		TransferRate: 200,
+	}
+}
+
+// 节点变更时的数据迁移
+//
+// 新所有者在迁移窗口内未命中时, 先从旧所有者的缓存中读取(双读);
+// 旧所有者在后台按速率限制将热点key推送给新所有者.
+type rebalancer struct {
+	pool *HTTPPool
+	conf HandoffConfig
+
+	mu     sync.Mutex
+	prev   *peerState    // 变更前的节点视图
+	until  time.Time     // 迁移窗口结束时间
+	stopCh chan struct{} // 用于取消正在进行的主动迁移
+}
+
+// 开启节点变更时的数据迁移
+//
+// 开启时自身已在节点列表中, 视为刚刚加入集群, 迁移窗口内按不包含自身的节点视图双读.
+func (p *HTTPPool) EnableHandoff(conf HandoffConfig) {
+	if conf.Window <= 0 {
+		conf.Window = DefaultHandoffConfig().Window
+	}
+	if conf.TransferRate <= 0 {
+		conf.TransferRate = DefaultHandoffConfig().TransferRate
+	}
+	if conf.TransferRate > maxTransferRate {
+		conf.TransferRate = maxTransferRate
+	}
+
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	rb := &rebalancer{pool: p, conf: conf}
+	if clients := p.peers.Load().httpClient; len(clients) > 1 {
+		if _, ok := clients[p.self]; ok {
+			rb.prev = p.withoutSelf(clients)
+			rb.until = time.Now().Add(conf.Window)
+		}
+	}
+	if old := p.rebalancer.Swap(rb); old != nil {
+		old.stop()
+	}
+}
+
+// 关闭数据迁移
+func (p *HTTPPool) DisableHandoff() {
+	if old := p.rebalancer.Swap(nil); old != nil {
+		old.stop()
+	}
+}
+
+// 在迁移窗口内, 如果key在节点变更前属于其他节点, 返回该节点的客户端
+func (p *HTTPPool) PickPreviousClient(key string) (NodeClient, bool) {
+	rb := p.rebalancer.Load()
+	if rb == nil {
+		return nil, false
+	}
+	prev := rb.previous()
+	if prev == nil {
+		return nil, false
+	}
+
+	state := p.peers.Load()
+	oldOwner := ownerOf(prev.picker, key)
+	if oldOwner == "" || oldOwner == p.self || oldOwner == ownerOf(state.picker, key) {
+		return nil, false
+	}
+	client, ok := prev.httpClient[oldOwner]
+	return client, ok
+}
+
+var _ HandoffNodeServer = (*HTTPPool)(nil)
+
+// key的所有者, 优先使用GetNodes避免有界负载等算法记录负载
+func ownerOf(picker consistence.Picker, key string) string {
+	if rp, ok := picker.(consistence.ReplicaPicker); ok {
+		if nodes := rp.GetNodes(key, 1); len(nodes) > 0 {
+			return nodes[0]
+		}
+		return ""
+	}
+	return picker.GetNode(key)
+}
+
+func (rb *rebalancer) previous() *peerState {
+	rb.mu.Lock()
+	defer rb.mu.Unlock()
+
+	if rb.prev == nil || time.Now().After(rb.until) {
+		return nil
+	}
+	return rb.prev
+}
+
+// 节点成员发生变化
+func (rb *rebalancer) changed(old, next *peerState) {
+	rb.mu.Lock()
+	defer rb.mu.Unlock()
+
+	rb.prev = old
+	rb.until = time.Now().Add(rb.conf.Window)
+
+	if rb.stopCh != nil {
+		close(rb.stopCh)
+		rb.stopCh = nil
+	}
+	if rb.conf.TransferKeys > 0 {
+		rb.stopCh = make(chan struct{})
+		go rb.transfer(old, next, rb.stopCh)
+	}
+}
+
+func (rb *rebalancer) stop() {
+	rb.mu.Lock()
+	defer rb.mu.Unlock()
+
+	if rb.stopCh != nil {
+		close(rb.stopCh)
+		rb.stopCh = nil
+	}
+	rb.prev = nil
+}
+
+// 将原本属于自己, 变更后属于其他节点的热点key推送给新所有者
+func (rb *rebalancer) transfer(old, next *peerState, stopCh chan struct{}) {
+	self := rb.pool.self
+	ticker := time.NewTicker(time.Second / time.Duration(rb.conf.TransferRate))
+	defer ticker.Stop()
+
+	var moved int
+	for _, g := range rb.pool.registry.Groups() {
+		for _, key := range g.mainCache.hotKeys(rb.conf.TransferKeys) {
+			if ownerOf(old.picker, key) != self {
+				continue
+			}
+			owner := ownerOf(next.picker, key)
+			if owner == "" || owner == self {
+				continue
+			}
+			client, ok := next.httpClient[owner]
+			if !ok {
+				continue
+			}
+			view, ok, _ := g.lookupCache(key)
+			if !ok {
+				continue
+			}
+			expireAt, ok := g.mainCache.expireAt(key)
+			if !ok {
+				continue
+			}
+
+			select {
+			case <-ticker.C:
+			case <-stopCh:
+				return
+			}
+			if err := client.handoffCacheValue(g.name, key, view.raw(), expireAt); err != nil {
+				rb.pool.Log("Handoff %s/%s to %s failed: %v", g.name, key, owner, err)
+				continue
+			}
+			moved++
+		}
+	}
+	if moved > 0 {
+		rb.pool.Log("Handoff transferred %d keys", moved)
+	}
+}
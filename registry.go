@@ -0,0 +1,169 @@
+package geecache
+
+import (
+	"fmt"
+	"path/filepath"
+	"sort"
+	"sync"
+)
+
+// group注册表, 一个进程中可以有多个互相独立的注册表(缓存集群)
+type Registry struct {
+	mu       sync.RWMutex
+	groups   map[string]*CacheGroup
+	starting map[string]bool // 正在启动的group名称
+}
+
+func NewRegistry() *Registry {
+	return &Registry{groups: make(map[string]*CacheGroup), starting: make(map[string]bool)}
+}
+
+// 默认注册表, 包级别的NewGroup、GetCacheGroup等函数以及NewHTTPPool使用
+var DefaultRegistry = NewRegistry()
+
+// 创建并注册group, 名称已存在时返回ErrGroupExists
+//
+// 启动group(打开预写日志)时不持有注册表的锁, 期间名称被占用, 同名的创建和替换返回错误.
+func (r *Registry) NewGroup(name string, getter Getter, opts ...GroupOption) (*CacheGroup, error) {
+	g, err := newGroup(r, name, getter, opts)
+	if err != nil {
+		return nil, err
+	}
+
+	r.mu.Lock()
+	if _, ok := r.groups[name]; ok || r.starting[name] {
+		r.mu.Unlock()
+		return nil, fmt.Errorf("%w: %s", ErrGroupExists, name)
+	}
+	r.starting[name] = true
+	r.mu.Unlock()
+
+	err = g.start()
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	delete(r.starting, name)
+	if err != nil {
+		return nil, err
+	}
+	r.groups[name] = g
+	return g, nil
+}
+
+// 创建并注册group, 名称已存在时关闭并替换原来的group
+//
+// 新的group先启动, 启动失败时保留原来的group; 替换后再关闭原来的group(写入快照、关闭预写日志).
+// 两者同时运行, 因此不能使用同一个预写日志目录; 原来的group关闭时写入的快照会覆盖新group的快照,
+// 因此也不能使用同一个快照目录. 启动期间名称被占用, 原来的group不会被同名的创建或替换换掉.
+func (r *Registry) ReplaceGroup(name string, getter Getter, opts ...GroupOption) (*CacheGroup, error) {
+	g, err := newGroup(r, name, getter, opts)
+	if err != nil {
+		return nil, err
+	}
+
+	r.mu.Lock()
+	if r.starting[name] {
+		r.mu.Unlock()
+		return nil, fmt.Errorf("group %s is being started", name)
+	}
+	if old := r.groups[name]; old != nil {
+		if err := checkReplaceDirs(old, g); err != nil {
+			r.mu.Unlock()
+			return nil, err
+		}
+	}
+	r.starting[name] = true
+	r.mu.Unlock()
+
+	err = g.start()
+
+	r.mu.Lock()
+	delete(r.starting, name)
+	if err != nil {
+		r.mu.Unlock()
+		return nil, err
+	}
+	old := r.groups[name]
+	r.groups[name] = g
+	r.mu.Unlock()
+
+	if old != nil {
+		if err := old.close(); err != nil {
+			old.logger.Printf("[GeeCache] %v", err)
+		}
+	}
+	return g, nil
+}
+
+// 新旧group同时运行, 不能使用同一个预写日志目录或快照目录
+func checkReplaceDirs(old, g *CacheGroup) error {
+	if old.walConf != nil && g.walConf != nil && filepath.Clean(old.walConf.Dir) == filepath.Clean(g.walConf.Dir) {
+		return fmt.Errorf("group %s: wal dir %s is used by the group being replaced", g.name, g.walConf.Dir)
+	}
+	if old.snapshotDir != "" && g.snapshotDir != "" && filepath.Clean(old.snapshotDir) == filepath.Clean(g.snapshotDir) {
+		return fmt.Errorf("group %s: snapshot dir %s is used by the group being replaced", g.name, g.snapshotDir)
+	}
+	return nil
+}
+
+// 关闭并删除group, 不存在时返回false
+func (r *Registry) RemoveGroup(name string) bool {
+	r.mu.Lock()
+	g, ok := r.groups[name]
+	delete(r.groups, name)
+	r.mu.Unlock()
+
+	if ok {
+		if err := g.close(); err != nil {
+			g.logger.Printf("[GeeCache] %v", err)
+		}
+	}
+	return ok
+}
+
+func (r *Registry) Group(name string) *CacheGroup {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	return r.groups[name]
+}
+
+// 全部group, 按名称排序
+func (r *Registry) Groups() []*CacheGroup {
+	r.mu.RLock()
+	groups := make([]*CacheGroup, 0, len(r.groups))
+	for _, g := range r.groups {
+		groups = append(groups, g)
+	}
+	r.mu.RUnlock()
+
+	sort.Slice(groups, func(i, j int) bool { return groups[i].name < groups[j].name })
+	return groups
+}
+
+// 删除g, 已被替换时不做处理
+func (r *Registry) unregister(g *CacheGroup) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if r.groups[g.name] == g {
+		delete(r.groups, g.name)
+	}
+}
+
+// 在默认注册表中创建group, 名称已存在时返回ErrGroupExists
+func NewGroup(name string, getter Getter, opts ...GroupOption) (*CacheGroup, error) {
+	return DefaultRegistry.NewGroup(name, getter, opts...)
+}
+
+// 在默认注册表中创建group, 名称已存在时关闭并替换原来的group
+func ReplaceGroup(name string, getter Getter, opts ...GroupOption) (*CacheGroup, error) {
+	return DefaultRegistry.ReplaceGroup(name, getter, opts...)
+}
+
+// 关闭并删除默认注册表中的group, 不存在时返回false
+func RemoveGroup(name string) bool {
+	return DefaultRegistry.RemoveGroup(name)
+}
+
+func GetCacheGroup(name string) *CacheGroup {
+	return DefaultRegistry.Group(name)
+}
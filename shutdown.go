@@ -0,0 +1,116 @@
+package geecache
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"net/http"
+	"net/url"
+	"sync"
+	"time"
+)
+
+// 优雅退出
+//
+//  1. 进入draining状态: /health返回503, 新的节点间请求返回503, 其他节点会改为自行加载
+//  2. 通知其他节点将自己剔除出哈希环, 重启后通过健康检查恢复
+//  3. 停止健康检查和数据迁移
+//  4. 等待正在处理的请求完成, 直到ctx超时
+//
+// 监听和group的关闭由调用方完成, 见cmd/main.go.
+func (p *HTTPPool) Shutdown(ctx context.Context) error {
+	if p.draining.Swap(true) {
+		return nil
+	}
+	p.Log("Shutting down, draining %d in-flight requests", p.inflight.Load())
+
+	p.announceLeave(ctx)
+	if h := p.health.Swap(nil); h != nil {
+		h.stop()
+	}
+	p.DisableHandoff()
+
+	ticker := time.NewTicker(10 * time.Millisecond)
+	defer ticker.Stop()
+	for p.inflight.Load() > 0 {
+		select {
+		case <-ticker.C:
+		case <-ctx.Done():
+			return fmt.Errorf("shutdown: %d requests still in flight: %w", p.inflight.Load(), ctx.Err())
+		}
+	}
+	return nil
+}
+
+// 是否正在退出
+func (p *HTTPPool) Draining() bool {
+	return p.draining.Load()
+}
+
+// 并发通知其他节点剔除自己, 失败的节点之后会通过健康检查剔除自己
+func (p *HTTPPool) announceLeave(ctx context.Context) {
+	var wg sync.WaitGroup
+	for _, addr := range p.Peers() {
+		if addr == p.self {
+			continue
+		}
+		wg.Add(1)
+		go func(addr string) {
+			defer wg.Done()
+			u := fmt.Sprintf("%s%sleave?peer=%s", addr, p.adminPath, url.QueryEscape(p.self))
+			req, err := http.NewRequestWithContext(ctx, http.MethodPost, u, nil)
+			if err != nil {
+				return
+			}
+			res, err := p.client.Do(req)
+			if err != nil {
+				p.Log("Announce leave to %s failed: %v", addr, err)
+				return
+			}
+			res.Body.Close()
+		}(addr)
+	}
+	wg.Wait()
+}
+
+// 请求是否由peer发出: 请求的来源IP需要是peer地址中的主机解析出的IP之一
+//
+// 防止任意客户端将其他节点剔除出哈希环. 经过代理或NAT时来源不匹配, 退出通知被拒绝,
+// 离开的节点之后由健康检查剔除.
+func sentByPeer(r *http.Request, peer string) bool {
+	remote, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		return false
+	}
+	remoteIP := net.ParseIP(remote)
+	u, err := url.Parse(peer)
+	if err != nil || remoteIP == nil {
+		return false
+	}
+	addrs, err := net.DefaultResolver.LookupHost(r.Context(), u.Hostname())
+	if err != nil {
+		return false
+	}
+	for _, addr := range addrs {
+		if ip := net.ParseIP(addr); ip != nil && ip.Equal(remoteIP) {
+			return true
+		}
+	}
+	return false
+}
+
+// 节点主动退出
+//
+// 开启健康检查时只剔除该节点, 仍保留为集群成员, 节点重启后通过主动探测恢复;
+// 没有健康检查时无法恢复, 直接从节点列表中删除.
+func (p *HTTPPool) peerLeft(addr string) {
+	if addr == p.self {
+		return
+	}
+	if h := p.health.Load(); h != nil {
+		h.left(addr)
+		p.ejectPeer(addr)
+		return
+	}
+	p.RemovePeer(addr)
+}
@@ -0,0 +1,327 @@
+package geecache
+
+import (
+	"bufio"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"hash"
+	"hash/crc32"
+	"io"
+	"net/url"
+	"os"
+	"path/filepath"
+	"time"
+)
+
+// 快照格式
+//
+//	header: magic(4) + version(2) + group名称 + 创建时间
+//	entry:  1 + key + value + 过期时间(unix秒, 0表示不过期)
+//	end:    0 + 条目数量 + CRC32(之前的全部内容)
+//
+// 长度和整数使用varint编码, value为解压后的原始数据, 恢复时按当前group的配置重新压缩.
+const (
+	snapshotMagic   = "GCSN"
+	snapshotVersion = 1
+
+	snapshotEntry byte = 1
+	snapshotEnd   byte = 0
+
+	maxSnapshotKey   = 1 << 16
+	maxSnapshotValue = 1 << 30
+)
+
+var (
+	ErrSnapshotCorrupt = errors.New("geecache: snapshot corrupt")
+	ErrSnapshotVersion = errors.New("geecache: unsupported snapshot version")
+)
+
+// 将缓存内容写入w, 包括每个值的过期时间
+func (g *CacheGroup) Snapshot(w io.Writer) error {
+	if g.closed.Load() {
+		return ErrGroupClosed
+	}
+	_, err := g.snapshot(w)
+	return err
+}
+
+func (g *CacheGroup) snapshot(w io.Writer) (int, error) {
+	crc := crc32.NewIEEE()
+	bw := bufio.NewWriter(io.MultiWriter(w, crc))
+
+	var buf [binary.MaxVarintLen64]byte
+	putUvarint := func(v uint64) {
+		bw.Write(buf[:binary.PutUvarint(buf[:], v)])
+	}
+	putVarint := func(v int64) {
+		bw.Write(buf[:binary.PutVarint(buf[:], v)])
+	}
+	putBytes := func(b []byte) {
+		putUvarint(uint64(len(b)))
+		bw.Write(b)
+	}
+
+	bw.WriteString(snapshotMagic)
+	binary.LittleEndian.PutUint16(buf[:], snapshotVersion)
+	bw.Write(buf[:2])
+	putBytes([]byte(g.name))
+	putVarint(time.Now().Unix())
+
+	var count int
+	var decodeErr error
+	g.mainCache.each(func(key string, value ByteView, expireAt int64) bool {
+		if value, decodeErr = value.decode(); decodeErr != nil {
+			decodeErr = fmt.Errorf("snapshot %s: %w", key, decodeErr)
+			return false
+		}
+		bw.WriteByte(snapshotEntry)
+		putBytes([]byte(key))
+		putBytes(value.raw())
+		putVarint(expireAt)
+		count++
+		return true
+	})
+	// 不写入结束标记和校验和, 已写出的内容无法通过恢复时的校验
+	if decodeErr != nil {
+		return 0, decodeErr
+	}
+	bw.WriteByte(snapshotEnd)
+	putUvarint(uint64(count))
+
+	// bufio.Writer记录第一次写入错误, 之后的写入直接忽略
+	if err := bw.Flush(); err != nil {
+		return 0, err
+	}
+	binary.LittleEndian.PutUint32(buf[:], crc.Sum32())
+	if _, err := w.Write(buf[:4]); err != nil {
+		return 0, err
+	}
+	return count, nil
+}
+
+type snapshotRecord struct {
+	key      string
+	value    []byte
+	expireAt int64
+}
+
+// 读取快照并写入缓存, 校验通过后才写入, 已过期的值被跳过
+//
+// 快照中的过期时间是绝对时间, 节点停机期间过期的值不会被恢复.
+func (g *CacheGroup) Restore(r io.Reader) error {
+	if g.closed.Load() {
+		return ErrGroupClosed
+	}
+	records, err := readSnapshot(r, g.name)
+	if err != nil {
+		return err
+	}
+
+	now := time.Now().Unix()
+	var restored int
+	for _, rec := range records {
+		var second int64
+		if rec.expireAt != 0 {
+			if second = rec.expireAt - now; second <= 0 {
+				continue
+			}
+		}
+		g.addToCache(rec.key, ByteView{b: rec.value}, second)
+		restored++
+	}
+	g.logger.Printf("[GeeCache] group %s restored %d of %d entries from snapshot", g.name, restored, len(records))
+	return nil
+}
+
+// 读取时同时计算CRC
+type snapshotReader struct {
+	r   *bufio.Reader
+	crc hash.Hash32
+}
+
+func (r *snapshotReader) ReadByte() (byte, error) {
+	b, err := r.r.ReadByte()
+	if err == nil {
+		r.crc.Write([]byte{b})
+	}
+	return b, err
+}
+
+func (r *snapshotReader) Read(p []byte) (int, error) {
+	n, err := r.r.Read(p)
+	r.crc.Write(p[:n])
+	return n, err
+}
+
+func (r *snapshotReader) bytes(max uint64) ([]byte, error) {
+	n, err := binary.ReadUvarint(r)
+	if err != nil {
+		return nil, err
+	}
+	if n > max {
+		return nil, fmt.Errorf("%w: length %d exceeds %d", ErrSnapshotCorrupt, n, max)
+	}
+	b := make([]byte, n)
+	if _, err := io.ReadFull(r, b); err != nil {
+		return nil, err
+	}
+	return b, nil
+}
+
+func readSnapshot(r io.Reader, group string) ([]snapshotRecord, error) {
+	sr := &snapshotReader{r: bufio.NewReader(r), crc: crc32.NewIEEE()}
+	records, err := readSnapshotRecords(sr, group)
+	if errors.Is(err, io.EOF) || errors.Is(err, io.ErrUnexpectedEOF) {
+		return nil, fmt.Errorf("%w: truncated", ErrSnapshotCorrupt)
+	}
+	return records, err
+}
+
+func readSnapshotRecords(sr *snapshotReader, group string) ([]snapshotRecord, error) {
+	var header [6]byte
+	if _, err := io.ReadFull(sr, header[:]); err != nil {
+		return nil, err
+	}
+	if string(header[:4]) != snapshotMagic {
+		return nil, fmt.Errorf("%w: bad magic", ErrSnapshotCorrupt)
+	}
+	if v := binary.LittleEndian.Uint16(header[4:]); v != snapshotVersion {
+		return nil, fmt.Errorf("%w: %d", ErrSnapshotVersion, v)
+	}
+	name, err := sr.bytes(maxSnapshotKey)
+	if err != nil {
+		return nil, err
+	}
+	if string(name) != group {
+		return nil, fmt.Errorf("snapshot of group %s cannot be restored into %s", name, group)
+	}
+	if _, err := binary.ReadVarint(sr); err != nil {
+		return nil, err
+	}
+
+	var records []snapshotRecord
+	for {
+		tag, err := sr.ReadByte()
+		if err != nil {
+			return nil, err
+		}
+		if tag == snapshotEnd {
+			break
+		}
+		if tag != snapshotEntry {
+			return nil, fmt.Errorf("%w: unknown record %d", ErrSnapshotCorrupt, tag)
+		}
+
+		var rec snapshotRecord
+		key, err := sr.bytes(maxSnapshotKey)
+		if err != nil {
+			return nil, err
+		}
+		if rec.value, err = sr.bytes(maxSnapshotValue); err != nil {
+			return nil, err
+		}
+		if rec.expireAt, err = binary.ReadVarint(sr); err != nil {
+			return nil, err
+		}
+		rec.key = string(key)
+		records = append(records, rec)
+	}
+
+	count, err := binary.ReadUvarint(sr)
+	if err != nil {
+		return nil, err
+	}
+	sum := sr.crc.Sum32()
+	var trailer [4]byte
+	if _, err := io.ReadFull(sr.r, trailer[:]); err != nil {
+		return nil, err
+	}
+	if binary.LittleEndian.Uint32(trailer[:]) != sum {
+		return nil, fmt.Errorf("%w: checksum mismatch", ErrSnapshotCorrupt)
+	}
+	if count != uint64(len(records)) {
+		return nil, fmt.Errorf("%w: expect %d entries, got %d", ErrSnapshotCorrupt, count, len(records))
+	}
+	return records, nil
+}
+
+// group在dir下的快照文件路径
+func SnapshotPath(dir string, group string) string {
+	return filepath.Join(dir, url.PathEscape(group)+".snapshot")
+}
+
+// 写入dir下的快照文件, 先写临时文件再重命名, 不会留下不完整的快照
+func (g *CacheGroup) SnapshotFile(dir string) error {
+	if g.closed.Load() {
+		return ErrGroupClosed
+	}
+	return g.snapshotFile(dir)
+}
+
+// 开启预写日志时, 先切换日志段, 快照写入成功后删除旧的段
+func (g *CacheGroup) snapshotFile(dir string) error {
+	var segment uint64
+	if g.wal != nil {
+		g.walMu.Lock()
+		var err error
+		segment, err = g.wal.Rotate()
+		g.walMu.Unlock()
+		if err != nil {
+			return err
+		}
+	}
+
+	path := SnapshotPath(dir, g.name)
+	f, err := os.CreateTemp(dir, filepath.Base(path)+".tmp*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(f.Name())
+
+	count, err := g.snapshot(f)
+	if err == nil {
+		err = f.Sync()
+	}
+	if cerr := f.Close(); err == nil {
+		err = cerr
+	}
+	if err != nil {
+		return err
+	}
+	if err := os.Rename(f.Name(), path); err != nil {
+		return err
+	}
+	g.logger.Printf("[GeeCache] group %s saved %d entries to %s", g.name, count, path)
+	if g.wal != nil {
+		return g.wal.Compact(segment)
+	}
+	return nil
+}
+
+// 从dir下的快照文件恢复, 文件不存在时返回的错误满足errors.Is(err, fs.ErrNotExist)
+func (g *CacheGroup) RestoreFile(dir string) error {
+	f, err := os.Open(SnapshotPath(dir, g.name))
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	return g.Restore(f)
+}
+
+// 定期写入快照, 直到group关闭
+func (g *CacheGroup) startSnapshots() {
+	defer close(g.snapshotDone)
+	ticker := time.NewTicker(g.snapshotInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C:
+			if err := g.snapshotFile(g.snapshotDir); err != nil {
+				g.logger.Printf("[GeeCache] group %s snapshot failed: %v", g.name, err)
+			}
+		case <-g.snapshotStop:
+			return
+		}
+	}
+}
@@ -0,0 +1,128 @@
+package test
+
+import (
+	"geecache"
+	"io"
+	"log"
+	"os"
+	"strconv"
+	"strings"
+	"testing"
+)
+
+func newArenaGroup(t testing.TB, name string, capacity, size int64, shards int) (*geecache.CacheGroup, *int) {
+	loads := 0
+	opts := []geecache.GroupOption{geecache.WithCapacity(capacity), geecache.WithStorage(geecache.StorageArena, size)}
+	if shards > 0 {
+		opts = append(opts, geecache.WithShards(shards))
+	}
+	g, err := geecache.ReplaceGroup(name, geecache.GetterFunc(func(key string) ([]byte, error) {
+		loads++
+		return []byte("value-" + key), nil
+	}), opts...)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return g, &loads
+}
+
+func TestArenaStorage(t *testing.T) {
+	g, loads := newArenaGroup(t, "arena", 0, 1<<20, 4)
+	for i := 0; i < 2; i++ {
+		for j := 0; j < 100; j++ {
+			key := "key-" + strconv.Itoa(j)
+			if v, err := g.GetCacheValue(key); err != nil || v.String() != "value-"+key {
+				t.Fatalf("get %s = %q, %v", key, v.String(), err)
+			}
+		}
+	}
+	if *loads != 100 {
+		t.Errorf("loaded %d times, want 100", *loads)
+	}
+
+	g.Set("key-1", []byte("updated"))
+	if v, _ := g.GetCacheValue("key-1"); v.String() != "updated" {
+		t.Errorf("get updated key-1 = %q", v.String())
+	}
+	if stats := g.CacheStats(); stats.Entries != 100 || stats.Hits != 101 {
+		t.Errorf("stats = %+v, want 100 entries and 101 hits", stats)
+	}
+}
+
+func TestArenaEvictsOldest(t *testing.T) {
+	// 单个分片只能容纳约10个条目
+	g, loads := newArenaGroup(t, "arena-evict", 0, 10*(23+8+14), 1)
+	for round := 0; round < 5; round++ {
+		for j := 0; j < 30; j++ {
+			key := "key-" + strconv.Itoa(j)
+			if v, err := g.GetCacheValue(key); err != nil || v.String() != "value-"+key {
+				t.Fatalf("get %s = %q, %v", key, v.String(), err)
+			}
+		}
+	}
+	stats := g.CacheStats()
+	if stats.Entries == 0 || stats.Entries > 10 || stats.Evictions == 0 {
+		t.Errorf("stats = %+v, want at most 10 entries with evictions", stats)
+	}
+	if *loads != 150 {
+		t.Errorf("loaded %d times, want 150 with FIFO eviction", *loads)
+	}
+
+	// 最近写入的key仍然在缓存中
+	before := *loads
+	g.GetCacheValue("key-29")
+	if *loads != before {
+		t.Error("most recent key was evicted")
+	}
+}
+
+func TestArenaCapacity(t *testing.T) {
+	g, _ := newArenaGroup(t, "arena-capacity", 8, 1<<20, 2)
+	for j := 0; j < 50; j++ {
+		g.GetCacheValue("key-" + strconv.Itoa(j))
+	}
+	if stats := g.CacheStats(); stats.Entries != 8 || stats.Evictions != 42 {
+		t.Errorf("stats = %+v, want 8 entries and 42 evictions", stats)
+	}
+}
+
+func TestArenaCompressed(t *testing.T) {
+	page := strings.Repeat("geecache ", 100)
+	removeOnCleanup(t, "arena-compress")
+	g, err := geecache.NewGroup("arena-compress", geecache.GetterFunc(func(key string) ([]byte, error) {
+		return []byte(page), nil
+	}), geecache.WithStorage(geecache.StorageArena, 1<<20), geecache.WithCompression(geecache.GzipCompressor, 64))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for i := 0; i < 2; i++ {
+		if v, err := g.GetCacheValue("page"); err != nil || v.String() != page {
+			t.Fatalf("get page failed: %v", err)
+		}
+	}
+	if stats := g.CacheStats(); stats.Hits != 1 {
+		t.Errorf("stats = %+v, want 1 hit", stats)
+	}
+}
+
+func BenchmarkCacheGetArena(b *testing.B) {
+	log.SetOutput(io.Discard)
+	defer log.SetOutput(os.Stderr)
+
+	g, _ := newArenaGroup(b, "bench-arena", 0, 64<<20, 0)
+	keys := make([]string, 1024)
+	for i := range keys {
+		keys[i] = "key-" + strconv.Itoa(i)
+		g.GetCacheValue(keys[i])
+	}
+
+	b.ResetTimer()
+	b.RunParallel(func(pb *testing.PB) {
+		var i int
+		for pb.Next() {
+			g.GetCacheValue(keys[i%len(keys)])
+			i++
+		}
+	})
+}
@@ -0,0 +1,165 @@
+package test
+
+import (
+	"encoding/json"
+	"fmt"
+	"geecache"
+	"geecache/consistence"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"sync/atomic"
+	"testing"
+)
+
+func TestGetMany(t *testing.T) {
+	var batches int32
+	peer := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost {
+			http.Error(w, "unexpected single request", http.StatusInternalServerError)
+			return
+		}
+		atomic.AddInt32(&batches, 1)
+
+		var req struct{ Keys []string }
+		json.NewDecoder(r.Body).Decode(&req)
+		res := struct {
+			Values map[string][]byte `json:"values"`
+			Errors map[string]string `json:"errors"`
+		}{map[string][]byte{}, map[string]string{}}
+		for _, key := range req.Keys {
+			if key == "missing" {
+				res.Errors[key] = key + " not exist"
+				continue
+			}
+			res.Values[key] = []byte("remote-" + key)
+		}
+		json.NewEncoder(w).Encode(res)
+	}))
+	defer peer.Close()
+
+	self := "http://localhost:9201"
+	pool := newTestPool(t, self)
+	pool.Set(self, peer.URL)
+
+	removeOnCleanup(t, "batch")
+	g, err := geecache.NewGroup("batch", geecache.GetterFunc(func(key string) ([]byte, error) {
+		if key == "missing" {
+			return nil, fmt.Errorf("%s not exist", key)
+		}
+		return []byte("local-" + key), nil
+	}), geecache.WithCapacity(2<<10))
+	if err != nil {
+		t.Fatal(err)
+	}
+	g.RegisterServer(pool)
+
+	ring := consistence.NewMap(50, nil)
+	ring.AddNode(self, peer.URL)
+
+	keys := []string{"missing", "missing"}
+	for i := 0; i < 50; i++ {
+		keys = append(keys, "key-"+strconv.Itoa(i))
+	}
+	values, errs := g.GetMany(keys)
+
+	if len(values)+len(errs) != 51 || errs["missing"] == nil {
+		t.Fatalf("got %d values and %d errors", len(values), len(errs))
+	}
+	for i := 0; i < 50; i++ {
+		key := "key-" + strconv.Itoa(i)
+		expect := "local-" + key
+		if ring.GetNode(key) == peer.URL {
+			expect = "remote-" + key
+		}
+		if values[key].String() != expect {
+			t.Fatalf("%s = %q, expect %q", key, values[key].String(), expect)
+		}
+	}
+	if n := atomic.LoadInt32(&batches); n != 1 {
+		t.Fatalf("expect one batch request per peer, got %d", n)
+	}
+}
+
+// 有界负载每次选择都返回新的客户端对象, 仍然按节点合并为一次批量请求
+func TestGetManyBoundedLoad(t *testing.T) {
+	var batches int32
+	peer := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		atomic.AddInt32(&batches, 1)
+		var req struct{ Keys []string }
+		json.NewDecoder(r.Body).Decode(&req)
+		values := make(map[string][]byte)
+		for _, key := range req.Keys {
+			values[key] = []byte("remote")
+		}
+		json.NewEncoder(w).Encode(map[string]interface{}{"values": values})
+	}))
+	defer peer.Close()
+
+	self := "http://localhost:9203"
+	pool := newTestPool(t, self)
+	pool.Set(self, peer.URL)
+	pool.SetPicker(func() consistence.Picker { return consistence.NewBoundedLoad(50, 1.25, nil) })
+
+	g, err := geecache.NewRegistry().NewGroup("batch-bounded", geecache.GetterFunc(func(key string) ([]byte, error) {
+		return []byte("local"), nil
+	}))
+	if err != nil {
+		t.Fatal(err)
+	}
+	g.RegisterServer(pool)
+
+	var keys []string
+	for i := 0; i < 50; i++ {
+		keys = append(keys, "key-"+strconv.Itoa(i))
+	}
+	values, errs := g.GetMany(keys)
+	if len(values) != 50 || len(errs) != 0 {
+		t.Fatalf("got %d values and %v", len(values), errs)
+	}
+	if n := atomic.LoadInt32(&batches); n != 1 {
+		t.Fatalf("expect one batch request per peer, got %d", n)
+	}
+}
+
+func TestBatchEndpoint(t *testing.T) {
+	removeOnCleanup(t, "batch-endpoint")
+	if _, err := geecache.NewGroup("batch-endpoint", geecache.GetterFunc(func(key string) ([]byte, error) {
+		if key == "missing" {
+			return nil, fmt.Errorf("%s not exist", key)
+		}
+		return []byte(key), nil
+	}), geecache.WithCapacity(2<<10)); err != nil {
+		t.Fatal(err)
+	}
+	server := httptest.NewServer(newTestPool(t, "http://localhost:9202"))
+	defer server.Close()
+
+	res, err := http.Post(server.URL+"/_geecache/batch-endpoint", "application/json",
+		strings.NewReader(`{"keys":["Tom","missing"]}`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer res.Body.Close()
+
+	var batch struct {
+		Values map[string][]byte
+		Errors map[string]string
+	}
+	json.NewDecoder(res.Body).Decode(&batch)
+	if string(batch.Values["Tom"]) != "Tom" || batch.Errors["missing"] == "" {
+		t.Fatalf("unexpected batch response %+v", batch)
+	}
+
+	// 请求体超过大小上限
+	large := `{"keys":["` + strings.Repeat("k", 2<<20) + `"]}`
+	res, err = http.Post(server.URL+"/_geecache/batch-endpoint", "application/json", strings.NewReader(large))
+	if err != nil {
+		t.Fatal(err)
+	}
+	res.Body.Close()
+	if res.StatusCode != http.StatusRequestEntityTooLarge {
+		t.Fatalf("oversized batch request returned %v", res.Status)
+	}
+}
@@ -0,0 +1,98 @@
+package test
+
+import (
+	"geecache"
+	"strconv"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+type countingBatchGetter struct {
+	gets    int32
+	batches int32
+	keys    int32
+}
+
+func (c *countingBatchGetter) Get(key string) ([]byte, error) {
+	atomic.AddInt32(&c.gets, 1)
+	return []byte("v-" + key), nil
+}
+
+func (c *countingBatchGetter) GetMany(keys []string) (map[string][]byte, error) {
+	atomic.AddInt32(&c.batches, 1)
+	atomic.AddInt32(&c.keys, int32(len(keys)))
+	values := make(map[string][]byte, len(keys))
+	for _, key := range keys {
+		if key == "missing" {
+			continue
+		}
+		values[key] = []byte("v-" + key)
+	}
+	return values, nil
+}
+
+func TestBatchGetterCoalesce(t *testing.T) {
+	getter := &countingBatchGetter{}
+	removeOnCleanup(t, "batchgetter")
+	g, err := geecache.NewGroup("batchgetter", getter,
+		geecache.WithCapacity(2<<10), geecache.WithBatchWindow(20*time.Millisecond, 1000))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var wg sync.WaitGroup
+	for i := 0; i < 50; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			key := "key-" + strconv.Itoa(i%25)
+			view, err := g.GetCacheValue(key)
+			if err != nil || view.String() != "v-"+key {
+				t.Errorf("get %s = %q, %v", key, view.String(), err)
+			}
+		}(i)
+	}
+	wg.Wait()
+
+	if n := atomic.LoadInt32(&getter.gets); n != 0 {
+		t.Errorf("Get called %d times, want 0", n)
+	}
+	if n := atomic.LoadInt32(&getter.batches); n > 3 {
+		t.Errorf("GetMany called %d times, want coalesced", n)
+	}
+	if n := atomic.LoadInt32(&getter.keys); n != 25 {
+		t.Errorf("loaded %d keys, want 25", n)
+	}
+
+	if _, err := g.GetCacheValue("missing"); err == nil {
+		t.Error("missing key should return error")
+	}
+}
+
+func TestBatchGetterMaxBatch(t *testing.T) {
+	getter := &countingBatchGetter{}
+	removeOnCleanup(t, "batchgetter-max")
+	g, err := geecache.NewGroup("batchgetter-max", getter,
+		geecache.WithCapacity(2<<10), geecache.WithBatchWindow(time.Hour, 10))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var wg sync.WaitGroup
+	for i := 0; i < 30; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			if _, err := g.GetCacheValue("key-" + strconv.Itoa(i)); err != nil {
+				t.Error(err)
+			}
+		}(i)
+	}
+	wg.Wait()
+
+	if n := atomic.LoadInt32(&getter.batches); n != 3 {
+		t.Errorf("GetMany called %d times, want 3", n)
+	}
+}
@@ -0,0 +1,76 @@
+package test
+
+import (
+	"bytes"
+	"geecache"
+	"io"
+	"strings"
+	"testing"
+)
+
+func TestByteViewReadOnly(t *testing.T) {
+	removeOnCleanup(t, "byteview")
+	g, err := geecache.NewGroup("byteview", geecache.GetterFunc(func(key string) ([]byte, error) {
+		return []byte("hello " + key), nil
+	}), geecache.WithCapacity(2<<10))
+	if err != nil {
+		t.Fatal(err)
+	}
+	v, _ := g.GetCacheValue("geecache")
+	other, _ := g.GetCacheValue("geecache")
+
+	if v.Len() != 14 || v.At(0) != 'h' || v.Slice(6, 14).String() != "geecache" {
+		t.Fatalf("Len/At/Slice failed: %d %c %q", v.Len(), v.At(0), v.Slice(6, 14).String())
+	}
+	if !v.Equal(other) || !v.EqualBytes([]byte("hello geecache")) || v.Equal(v.Slice(0, 5)) {
+		t.Fatal("Equal failed")
+	}
+
+	var buf bytes.Buffer
+	if n, err := v.WriteTo(&buf); err != nil || n != 14 || buf.String() != "hello geecache" {
+		t.Fatalf("WriteTo = %d, %v, %q", n, err, buf.String())
+	}
+
+	r := v.Reader()
+	r.Seek(6, io.SeekStart)
+	if rest, _ := io.ReadAll(r); string(rest) != "geecache" {
+		t.Fatalf("Reader read %q", rest)
+	}
+
+	allocs := testing.AllocsPerRun(100, func() {
+		v.WriteTo(io.Discard)
+		v.Equal(other)
+		v.Slice(0, 5).Len()
+	})
+	if allocs != 0 {
+		t.Errorf("read-only accessors allocated %v times", allocs)
+	}
+}
+
+// 压缩的值只在读取缓存时解压一次, Len和At不再分配内存
+func TestCompressedViewNoAlloc(t *testing.T) {
+	page := strings.Repeat("geecache ", 100)
+	g, err := geecache.NewRegistry().NewGroup("compress-alloc", geecache.GetterFunc(func(key string) ([]byte, error) {
+		return []byte(page), nil
+	}), geecache.WithCompression(geecache.GzipCompressor, 64))
+	if err != nil {
+		t.Fatal(err)
+	}
+	g.GetCacheValue("page")
+	if stats := g.CompressionStats(); stats.Compressed != 1 {
+		t.Fatalf("value should be stored compressed, stats = %+v", stats)
+	}
+
+	view, err := g.GetCacheValue("page")
+	if err != nil {
+		t.Fatal(err)
+	}
+	allocs := testing.AllocsPerRun(100, func() {
+		if view.Len() != len(page) || view.At(0) != 'g' {
+			t.Fatalf("unexpected view content")
+		}
+	})
+	if allocs != 0 {
+		t.Fatalf("Len/At allocated %v times per call on a compressed value", allocs)
+	}
+}
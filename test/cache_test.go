@@ -0,0 +1,199 @@
+package test
+
+import (
+	"geecache"
+	"geecache/lru"
+	"geecache/wal"
+	"io"
+	"log"
+	"os"
+	"strconv"
+	"sync"
+	"testing"
+	"time"
+)
+
+func newCacheGroup(t testing.TB, name string, capacity int64, shards int) *geecache.CacheGroup {
+	opts := []geecache.GroupOption{geecache.WithCapacity(capacity)}
+	if shards > 0 {
+		opts = append(opts, geecache.WithShards(shards))
+	}
+	g, err := geecache.ReplaceGroup(name, geecache.GetterFunc(func(key string) ([]byte, error) {
+		return []byte(key), nil
+	}), opts...)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return g
+}
+
+func TestShardedCacheCapacity(t *testing.T) {
+	g := newCacheGroup(t, "sharded", 10, 4)
+	for i := 0; i < 100; i++ {
+		g.GetCacheValue("key-" + strconv.Itoa(i))
+	}
+	g.GetCacheValue("key-99")
+
+	stats := g.CacheStats()
+	if stats.Shards != 4 || stats.Entries != 10 || stats.Evictions != 90 {
+		t.Errorf("stats = %+v, want 4 shards, 10 entries and 90 evictions", stats)
+	}
+	if stats.Hits != 1 || stats.Misses != 100 {
+		t.Errorf("stats = %+v, want 1 hit and 100 misses", stats)
+	}
+}
+
+func TestShardedCacheSmallCapacity(t *testing.T) {
+	g := newCacheGroup(t, "sharded-small", 3, 0)
+	for i := 0; i < 10; i++ {
+		g.GetCacheValue("key-" + strconv.Itoa(i))
+	}
+	if stats := g.CacheStats(); stats.Shards != 3 || stats.Entries != 3 {
+		t.Errorf("stats = %+v, want 3 shards holding 3 entries", stats)
+	}
+}
+
+func benchmarkCacheGet(b *testing.B, shards int) {
+	log.SetOutput(io.Discard)
+	defer log.SetOutput(os.Stderr)
+
+	g := newCacheGroup(b, "bench-get-"+strconv.Itoa(shards), 0, shards)
+	keys := make([]string, 1024)
+	for i := range keys {
+		keys[i] = "key-" + strconv.Itoa(i)
+		g.GetCacheValue(keys[i])
+	}
+
+	b.ResetTimer()
+	b.RunParallel(func(pb *testing.PB) {
+		var i int
+		for pb.Next() {
+			g.GetCacheValue(keys[i%len(keys)])
+			i++
+		}
+	})
+}
+
+// go test ./test -run ^$ -bench CacheGet -cpu 1,4,16,32
+func BenchmarkCacheGetSingleShard(b *testing.B) { benchmarkCacheGet(b, 1) }
+func BenchmarkCacheGetSharded(b *testing.B)     { benchmarkCacheGet(b, 0) }
+
+func benchmarkCacheMixed(b *testing.B, shards int) {
+	log.SetOutput(io.Discard)
+	defer log.SetOutput(os.Stderr)
+
+	g := newCacheGroup(b, "bench-mixed-"+strconv.Itoa(shards), 4096, shards)
+	b.ResetTimer()
+	b.RunParallel(func(pb *testing.PB) {
+		var i int
+		for pb.Next() {
+			key := "key-" + strconv.Itoa(i%8192)
+			if i%10 == 0 {
+				g.Set(key, []byte(key))
+			} else {
+				g.GetCacheValue(key)
+			}
+			i++
+		}
+	})
+}
+
+func BenchmarkCacheMixedSingleShard(b *testing.B) { benchmarkCacheMixed(b, 1) }
+func BenchmarkCacheMixedSharded(b *testing.B)     { benchmarkCacheMixed(b, 0) }
+
+func TestCacheReadPromotes(t *testing.T) {
+	g := newCacheGroup(t, "read-promote", 3, 1)
+	for _, key := range []string{"a", "b", "c"} {
+		g.GetCacheValue(key)
+	}
+	g.GetCacheValue("a")
+	g.GetCacheValue("d")
+
+	hits := g.CacheStats().Hits
+	g.GetCacheValue("a")
+	g.GetCacheValue("b")
+	if stats := g.CacheStats(); stats.Hits != hits+1 {
+		t.Errorf("want a kept and b evicted, stats = %+v", stats)
+	}
+}
+
+func TestCacheConcurrentReadWrite(t *testing.T) {
+	g := newCacheGroup(t, "concurrent", 64, 4)
+	var wg sync.WaitGroup
+	for w := 0; w < 8; w++ {
+		wg.Add(1)
+		go func(w int) {
+			defer wg.Done()
+			for i := 0; i < 2000; i++ {
+				key := "key-" + strconv.Itoa((i*7+w)%128)
+				if i%5 == 0 {
+					g.Set(key, []byte(key))
+					continue
+				}
+				if v, err := g.GetCacheValue(key); err != nil || v.String() != key {
+					t.Errorf("get %s = %q, %v", key, v.String(), err)
+					return
+				}
+			}
+		}(w)
+	}
+	wg.Wait()
+
+	if stats := g.CacheStats(); stats.Entries > 64 {
+		t.Errorf("stats = %+v, want at most 64 entries", stats)
+	}
+}
+
+// 带过期时间写入后再不带过期时间覆盖, 之前的过期时间不再生效
+func TestCacheOverwriteClearsTTL(t *testing.T) {
+	walDir := t.TempDir()
+	l, err := wal.Open(wal.DefaultConfig(walDir))
+	if err != nil {
+		t.Fatal(err)
+	}
+	l.Append(wal.Record{Op: wal.OpSet, Key: "key", Value: []byte("stale"), ExpireAt: time.Now().Add(time.Second).Unix()})
+	l.Close()
+
+	var loads int
+	g, err := geecache.NewRegistry().NewGroup("overwrite-ttl", geecache.GetterFunc(func(key string) ([]byte, error) {
+		loads++
+		return []byte("loaded"), nil
+	}), geecache.WithTTL(0), geecache.WithShards(1),
+		geecache.WithSnapshotDir(t.TempDir(), 0), geecache.WithWAL(wal.DefaultConfig(walDir)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer g.Close()
+	if err := g.Recover(); err != nil {
+		t.Fatal(err)
+	}
+	if err := g.Set("key", []byte("fresh")); err != nil {
+		t.Fatal(err)
+	}
+
+	time.Sleep(2100 * time.Millisecond)
+	// 写入其他key时处理读缓冲区, LRU检查访问过的key是否过期
+	g.GetCacheValue("key")
+	g.Set("other", []byte("other"))
+	if v, err := g.GetCacheValue("key"); err != nil || v.String() != "fresh" || loads != 0 {
+		t.Fatalf("get key = %q, %v after %d loads, want fresh value without expiry", v.String(), err, loads)
+	}
+}
+
+// 为已被淘汰的key设置过期时间后, 定期清理不会访问不存在的结点
+func TestCacheExpireEvictedKey(t *testing.T) {
+	c := lru.NewCache(1, nil)
+	c.Add("k1", String("v1"))
+	c.Add("k2", String("v2"))
+	c.Expire("k1", -1)
+	c.CleanupExpiredKeys()
+	if _, ok := c.Get("k2"); !ok {
+		t.Fatal("cleanup removed unexpired k2")
+	}
+
+	c.Expire("k2", -1)
+	c.CleanupExpiredKeys()
+	if c.Len() != 0 {
+		t.Fatalf("expired k2 not cleaned up, %d entries", c.Len())
+	}
+}
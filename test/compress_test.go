@@ -0,0 +1,211 @@
+package test
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"geecache"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync/atomic"
+	"testing"
+)
+
+func TestCompression(t *testing.T) {
+	page := strings.Repeat("<div class=\"item\">geecache</div>", 100)
+	removeOnCleanup(t, "compress")
+	g, err := geecache.NewGroup("compress", geecache.GetterFunc(func(key string) ([]byte, error) {
+		if key == "small" {
+			return []byte("tiny"), nil
+		}
+		return []byte(page), nil
+	}), geecache.WithCapacity(2<<10), geecache.WithCompression(geecache.GzipCompressor, 256))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for i := 0; i < 2; i++ {
+		view, err := g.GetCacheValue("page")
+		if err != nil || view.String() != page || string(view.ByteSlice()) != page {
+			t.Fatalf("get page failed: %v", err)
+		}
+	}
+	if view, _ := g.GetCacheValue("small"); view.String() != "tiny" {
+		t.Fatalf("get small = %q", view.String())
+	}
+
+	stats := g.CompressionStats()
+	if stats.Values != 2 || stats.Compressed != 1 {
+		t.Errorf("stats = %+v, want 2 values and 1 compressed", stats)
+	}
+	if stats.RawBytes != int64(len(page)+4) || stats.StoredBytes >= stats.RawBytes/2 {
+		t.Errorf("stats = %+v, want compressed bytes well below raw bytes", stats)
+	}
+}
+
+func TestCompressionOverHTTP(t *testing.T) {
+	page := bytes.Repeat([]byte("geecache "), 200)
+	removeOnCleanup(t, "compress-http")
+	g, err := geecache.NewGroup("compress-http", geecache.GetterFunc(func(key string) ([]byte, error) {
+		return page, nil
+	}), geecache.WithCapacity(2<<10), geecache.WithCompression(geecache.FlateCompressor, 64))
+	if err != nil {
+		t.Fatal(err)
+	}
+	g.GetCacheValue("page")
+
+	srv := httptest.NewServer(newTestPool(t, "http://localhost:9301"))
+	defer srv.Close()
+
+	for _, accept := range []string{"", "gzip, deflate"} {
+		req, _ := http.NewRequest(http.MethodGet, srv.URL+"/_geecache/compress-http/page", nil)
+		req.Header.Set("Accept-Encoding", accept)
+		res, err := http.DefaultTransport.RoundTrip(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		body, _ := io.ReadAll(res.Body)
+		res.Body.Close()
+
+		enc := res.Header.Get("Content-Encoding")
+		if accept == "" {
+			if enc != "" || !bytes.Equal(body, page) {
+				t.Errorf("without Accept-Encoding got encoding %q and %d bytes", enc, len(body))
+			}
+			continue
+		}
+		if enc != "deflate" || len(body) >= len(page) {
+			t.Fatalf("with Accept-Encoding got encoding %q and %d bytes", enc, len(body))
+		}
+		raw, err := geecache.FlateCompressor.Decompress(body)
+		if err != nil || !bytes.Equal(raw, page) {
+			t.Errorf("decompress response failed: %v", err)
+		}
+	}
+}
+
+// 批量接口同样按Accept-Encoding发送压缩后的值
+func TestCompressionBatch(t *testing.T) {
+	page := bytes.Repeat([]byte("geecache "), 200)
+	removeOnCleanup(t, "compress-batch")
+	g, err := geecache.NewGroup("compress-batch", geecache.GetterFunc(func(key string) ([]byte, error) {
+		return page, nil
+	}), geecache.WithCapacity(2<<10), geecache.WithCompression(geecache.FlateCompressor, 64))
+	if err != nil {
+		t.Fatal(err)
+	}
+	g.GetCacheValue("page")
+
+	srv := httptest.NewServer(newTestPool(t, "http://localhost:9302"))
+	defer srv.Close()
+
+	for _, accept := range []string{"", "gzip, deflate"} {
+		req, _ := http.NewRequest(http.MethodPost, srv.URL+"/_geecache/compress-batch", strings.NewReader(`{"keys":["page"]}`))
+		req.Header.Set("Accept-Encoding", accept)
+		res, err := http.DefaultTransport.RoundTrip(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		var batch struct {
+			Values    map[string][]byte
+			Encodings map[string]string
+		}
+		err = json.NewDecoder(res.Body).Decode(&batch)
+		res.Body.Close()
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		value, enc := batch.Values["page"], batch.Encodings["page"]
+		if accept == "" {
+			if enc != "" || !bytes.Equal(value, page) {
+				t.Errorf("without Accept-Encoding got encoding %q and %d bytes", enc, len(value))
+			}
+			continue
+		}
+		if enc != "deflate" || len(value) >= len(page) {
+			t.Fatalf("with Accept-Encoding got encoding %q and %d bytes", enc, len(value))
+		}
+		raw, err := geecache.FlateCompressor.Decompress(value)
+		if err != nil || !bytes.Equal(raw, page) {
+			t.Errorf("decompress batch value failed: %v", err)
+		}
+	}
+
+	// 其他节点通过批量接口读取时按encodings解压
+	local, err := geecache.NewRegistry().NewGroup("compress-batch", geecache.GetterFunc(func(key string) ([]byte, error) {
+		return nil, fmt.Errorf("%s should be loaded by the peer", key)
+	}))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer local.Close()
+	pool := newTestPool(t, "http://localhost:9303")
+	pool.Set(srv.URL)
+	local.RegisterServer(pool)
+
+	values, errs := local.GetMany([]string{"page"})
+	if len(errs) != 0 || !bytes.Equal(values["page"].ByteSlice(), page) {
+		t.Fatalf("GetMany from peer = %d bytes, %v", values["page"].Len(), errs)
+	}
+}
+
+// 解压失败时返回错误的压缩算法
+type brokenCompressor struct {
+	broken *atomic.Bool
+}
+
+func (c brokenCompressor) Name() string { return "broken" }
+
+func (c brokenCompressor) Compress(b []byte) ([]byte, error) {
+	return geecache.GzipCompressor.Compress(b)
+}
+
+func (c brokenCompressor) Decompress(b []byte) ([]byte, error) {
+	if c.broken.Load() {
+		return nil, errors.New("corrupt data")
+	}
+	return geecache.GzipCompressor.Decompress(b)
+}
+
+// 无法解压的值返回错误并被删除, 快照失败而不是写入空值
+func TestCompressionUndecodable(t *testing.T) {
+	page := strings.Repeat("geecache ", 100)
+	broken := new(atomic.Bool)
+	var loads int
+	g, err := geecache.NewRegistry().NewGroup("compress-broken", geecache.GetterFunc(func(key string) ([]byte, error) {
+		loads++
+		return []byte(page), nil
+	}), geecache.WithCompression(brokenCompressor{broken: broken}, 64))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := g.GetCacheValue("page"); err != nil {
+		t.Fatal(err)
+	}
+
+	broken.Store(true)
+	if err := g.Snapshot(&bytes.Buffer{}); err == nil {
+		t.Fatalf("snapshot should fail on undecodable values")
+	}
+	if view, err := g.GetCacheValue("page"); err == nil || !strings.Contains(err.Error(), "corrupt data") || loads != 1 {
+		t.Fatalf("undecodable value should return the decode error, got %q, %d loads, %v", view.String(), loads, err)
+	}
+	if stats := g.CacheStats(); stats.Entries != 0 {
+		t.Fatalf("undecodable value not evicted, %d entries", stats.Entries)
+	}
+	if _, errs := g.GetMany([]string{"page"}); errs["page"] != nil {
+		t.Fatalf("get after eviction failed: %v", errs["page"])
+	}
+
+	// 批量读取同样返回解压错误
+	if values, errs := g.GetMany([]string{"page"}); errs["page"] == nil || values["page"].Len() != 0 {
+		t.Fatalf("GetMany on undecodable value = %d bytes, %v", values["page"].Len(), errs["page"])
+	}
+	if loads != 2 {
+		t.Fatalf("loaded %d times, want 2", loads)
+	}
+}
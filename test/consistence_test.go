@@ -2,15 +2,28 @@ package test
 
 import (
 	"geecache/consistence"
+	"math"
+	"math/rand"
 	"strconv"
+	"strings"
 	"testing"
+	"testing/quick"
 )
 
+// 虚拟节点"<node>#<i>"的哈希值为i*10+node, key的哈希值为其本身
+func labelHash(key []byte) uint64 {
+	s := string(key)
+	if idx := strings.LastIndex(s, "#"); idx >= 0 {
+		node, _ := strconv.Atoi(s[:idx])
+		i, _ := strconv.Atoi(s[idx+1:])
+		return uint64(i*10 + node)
+	}
+	i, _ := strconv.Atoi(s)
+	return uint64(i)
+}
+
 func TestHashing(t *testing.T) {
-	hash := consistence.NewMap(3, func(key []byte) uint32 {
-		i, _ := strconv.Atoi(string(key))
-		return uint32(i)
-	})
+	hash := consistence.NewMap(3, labelHash)
 
 	// 2, 4, 6,
 	// 12, 14, 16,
@@ -43,3 +56,160 @@ func TestHashing(t *testing.T) {
 		t.Log(k, ":", hash.GetNode(k))
 	}
 }
+
+func TestRemoveNode(t *testing.T) {
+	hash := consistence.NewMap(3, labelHash)
+
+	// 2, 4, 6, 8,
+	// 12, 14, 16, 18,
+	// 22, 24, 26, 28
+	hash.AddNode("6", "4", "2", "8")
+	hash.RemoveNode("8")
+
+	testCases := map[string]string{
+		"2":  "2",
+		"11": "2",
+		"23": "4",
+		"27": "2",
+	}
+
+	for k, v := range testCases {
+		if hash.GetNode(k) != v {
+			t.Errorf("Asking for %s, should hash yielded %s", k, v)
+		}
+	}
+
+	if nodes := hash.Nodes(); len(nodes) != 3 {
+		t.Errorf("expected 3 nodes after remove, got %v", nodes)
+	}
+}
+
+// 测试带权重节点的key分布, 每个节点分到的key比例应接近其权重占比
+func TestWeightedDistribution(t *testing.T) {
+	weights := map[string]int{
+		"http://10.0.0.1:8001": 1,
+		"http://10.0.0.2:8001": 2,
+		"http://10.0.0.3:8001": 8,
+	}
+	total := 0
+	hash := consistence.NewMap(50, nil)
+	for node, weight := range weights {
+		hash.AddWeightedNode(node, weight)
+		total += weight
+	}
+
+	const keys = 100000
+	counts := make(map[string]int, len(weights))
+	for i := 0; i < keys; i++ {
+		counts[hash.GetNode("key-"+strconv.Itoa(i))]++
+	}
+
+	// 实际占比与期望占比的相对误差的方差
+	var variance float64
+	for node, weight := range weights {
+		expect := float64(weight) / float64(total)
+		actual := float64(counts[node]) / keys
+		diff := (actual - expect) / expect
+		variance += diff * diff / float64(len(weights))
+		t.Logf("%s weight=%d expect=%.3f actual=%.3f", node, weight, expect, actual)
+	}
+	t.Logf("variance of relative error: %.4f", variance)
+	if variance > 0.05 {
+		t.Errorf("weighted distribution variance too large: %.4f", variance)
+	}
+}
+
+// 旧的虚拟节点命名为strconv.Itoa(i)+key, 节点"1"的第11个虚拟节点与节点"11"的第1个虚拟节点同名
+func TestVirtualNodeLabelCollision(t *testing.T) {
+	hash := consistence.NewMap(20, nil)
+	hash.AddNode("1", "11")
+	hash.RemoveNode("11")
+
+	for i := 0; i < 1000; i++ {
+		if node := hash.GetNode("key-" + strconv.Itoa(i)); node != "1" {
+			t.Fatalf("only node 1 left, got %q", node)
+		}
+	}
+}
+
+// 哈希冲突时按节点名称决定先后, 不会互相覆盖
+func TestHashCollision(t *testing.T) {
+	hash := consistence.NewMap(3, func(key []byte) uint64 { return 42 })
+	hash.AddNode("b", "a")
+	if node := hash.GetNode("x"); node != "a" {
+		t.Fatalf("expect a, got %s", node)
+	}
+	hash.RemoveNode("a")
+	if node := hash.GetNode("x"); node != "b" {
+		t.Fatalf("expect b, got %s", node)
+	}
+}
+
+// 性质测试: 无论以什么顺序、分几次添加节点, 所有节点对key的归属判断一致
+func TestOwnershipIndependentOfOrder(t *testing.T) {
+	property := func(input []string, weights []uint8, seed int64) bool {
+		// 同名节点只保留第一个, 否则最终权重取决于添加顺序
+		seen := make(map[string]bool)
+		var names []string
+		for _, name := range input {
+			if !seen[name] {
+				seen[name] = true
+				names = append(names, name)
+			}
+		}
+		if len(names) == 0 {
+			return true
+		}
+		weight := func(i int) int {
+			if len(weights) == 0 {
+				return 1
+			}
+			return int(weights[i%len(weights)]%4) + 1
+		}
+
+		a := consistence.NewMap(10, nil)
+		for i, name := range names {
+			a.AddWeightedNode(name, weight(i))
+		}
+
+		r := rand.New(rand.NewSource(seed))
+		b := consistence.NewMap(10, nil)
+		for _, i := range r.Perm(len(names)) {
+			b.AddWeightedNode(names[i], weight(i))
+		}
+		// 再添加一个随机节点然后删除, 不应影响结果
+		b.AddNode("extra-" + strconv.FormatInt(seed, 10))
+		b.RemoveNode("extra-" + strconv.FormatInt(seed, 10))
+
+		for i := 0; i < 200; i++ {
+			key := strconv.Itoa(r.Int())
+			if a.GetNode(key) != b.GetNode(key) {
+				return false
+			}
+		}
+		return true
+	}
+
+	if err := quick.Check(property, &quick.Config{MaxCount: 200}); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestShares(t *testing.T) {
+	hash := consistence.NewMap(3, labelHash)
+	// 2, 4, 6, 12, 14, 16, 22, 24, 26
+	hash.AddNode("6", "4", "2")
+
+	shares := hash.Shares()
+	var total float64
+	for _, share := range shares {
+		total += share
+	}
+	if math.Abs(total-1) > 1e-9 {
+		t.Fatalf("shares should sum to 1, got %v", total)
+	}
+	// 节点2负责(16,22], (6,12]和跨越0点的(26, 2]
+	if shares["2"] < shares["4"] {
+		t.Fatalf("node 2 should own the wrap-around arc: %v", shares)
+	}
+}
@@ -0,0 +1,118 @@
+package test
+
+import (
+	"context"
+	"errors"
+	"geecache/discovery"
+	"net"
+	"os"
+	"path/filepath"
+	"reflect"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestDiff(t *testing.T) {
+	added, removed := discovery.Diff([]string{"a", "b", "c"}, []string{"b", "c", "d"})
+	if !reflect.DeepEqual(added, []string{"d"}) || !reflect.DeepEqual(removed, []string{"a"}) {
+		t.Fatalf("added %v, removed %v", added, removed)
+	}
+}
+
+func TestKVDiscovery(t *testing.T) {
+	kv := discovery.NewMemoryKV()
+	kv.Put("/geecache/peers/1", "http://localhost:8001")
+	kv.Put("/other/1", "http://localhost:9001")
+
+	pool := newTestPool(t, "http://localhost:8001")
+	cancel, err := pool.Subscribe(discovery.NewKV(kv, "/geecache/peers/"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer cancel()
+	waitPeers(t, pool, []string{"http://localhost:8001"})
+
+	// 短时间内的多次变更合并为一次更新, 节点列表中没有自身时自动加入
+	kv.Put("/geecache/peers/2", "http://localhost:8002")
+	kv.Put("/geecache/peers/3", "http://localhost:8003")
+	kv.Delete("/geecache/peers/1")
+	waitPeers(t, pool, []string{"http://localhost:8001", "http://localhost:8002", "http://localhost:8003"})
+}
+
+// 第一次之后List全部失败的键值存储
+type failingKV struct {
+	*discovery.MemoryKV
+	lists atomic.Int32
+}
+
+func (kv *failingKV) List(ctx context.Context, prefix string) (map[string]string, uint64, error) {
+	if kv.lists.Add(1) > 1 {
+		return nil, 0, errors.New("list unavailable")
+	}
+	return kv.MemoryKV.List(ctx, prefix)
+}
+
+// List失败后与Watch失败一样等待再重试, 不会不停地重试
+func TestKVDiscoveryListBackoff(t *testing.T) {
+	kv := &failingKV{MemoryKV: discovery.NewMemoryKV()}
+	kv.Put("/geecache/peers/1", "http://localhost:8001")
+
+	d := discovery.NewKV(kv, "/geecache/peers/")
+	if err := d.Watch(func([]string) {}); err != nil {
+		t.Fatal(err)
+	}
+	defer d.Close()
+
+	kv.Put("/geecache/peers/2", "http://localhost:8002")
+	time.Sleep(300 * time.Millisecond)
+	if n := kv.lists.Load(); n > 2 {
+		t.Fatalf("list retried %d times without backoff", n)
+	}
+}
+
+func TestFileDiscovery(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "peers")
+	os.WriteFile(path, []byte("# peers\nhttp://localhost:8001\nhttp://localhost:8002\n"), 0644)
+
+	pool := newTestPool(t, "http://localhost:8001")
+	cancel, err := pool.Subscribe(discovery.NewFile(path, 20*time.Millisecond))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer cancel()
+	waitPeers(t, pool, []string{"http://localhost:8001", "http://localhost:8002"})
+
+	os.WriteFile(path, []byte("http://localhost:8002\nhttp://localhost:8003\n"), 0644)
+	waitPeers(t, pool, []string{"http://localhost:8001", "http://localhost:8002", "http://localhost:8003"})
+
+	// 末尾带/的自身地址与自身是同一个节点
+	os.WriteFile(path, []byte("http://localhost:8001/\nhttp://localhost:8002/\n"), 0644)
+	waitPeers(t, pool, []string{"http://localhost:8001", "http://localhost:8002"})
+}
+
+func TestDNSSRVDiscovery(t *testing.T) {
+	var mu sync.Mutex
+	records := []*net.SRV{{Target: "cache-1.example.com.", Port: 8001}}
+
+	d := discovery.NewDNSSRV("geecache", "tcp", "example.com", "http", 20*time.Millisecond)
+	d.Lookup = func(ctx context.Context, service, proto, name string) (string, []*net.SRV, error) {
+		mu.Lock()
+		defer mu.Unlock()
+		return "", records, nil
+	}
+
+	pool := newTestPool(t, "http://cache-1.example.com:8001")
+	cancel, err := pool.Subscribe(d)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer cancel()
+	waitPeers(t, pool, []string{"http://cache-1.example.com:8001"})
+
+	mu.Lock()
+	records = append(records, &net.SRV{Target: "cache-2.example.com.", Port: 8001})
+	mu.Unlock()
+	waitPeers(t, pool, []string{"http://cache-1.example.com:8001", "http://cache-2.example.com:8001"})
+}
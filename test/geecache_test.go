@@ -30,7 +30,8 @@ var db = map[string]string{
 
 func TestGroupGet(t *testing.T) {
 	loadCounts := make(map[string]int, len(db))
-	gee := geecache.NewGroup("scores", 2<<10, geecache.GetterFunc(
+	removeOnCleanup(t, "scores")
+	gee, err := geecache.NewGroup("scores", geecache.GetterFunc(
 		func(key string) ([]byte, error) {
 			log.Println("[SlowDB] search key", key)
 			if v, ok := db[key]; ok {
@@ -41,7 +42,10 @@ func TestGroupGet(t *testing.T) {
 				return []byte(v), nil
 			}
 			return nil, fmt.Errorf("%s not exist", key)
-		}))
+		}), geecache.WithCapacity(2<<10))
+	if err != nil {
+		t.Fatal(err)
+	}
 
 	for k, v := range db {
 		if view, err := gee.GetCacheValue(k); err != nil || view.String() != v {
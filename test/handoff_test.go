@@ -0,0 +1,412 @@
+package test
+
+import (
+	"fmt"
+	"geecache"
+	"geecache/consistence"
+	"geecache/wal"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"reflect"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// 新节点加入后, 在迁移窗口内未命中的key从旧所有者的缓存中读取
+func TestHandoffDualRead(t *testing.T) {
+	old := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte("from-old-owner"))
+	}))
+	defer old.Close()
+
+	self := "http://localhost:9101"
+	pool := newTestPool(t, self)
+	pool.Set(old.URL)
+	pool.EnableHandoff(geecache.HandoffConfig{Window: time.Minute})
+
+	var sourceLoads int
+	removeOnCleanup(t, "handoff-read")
+	g, err := geecache.NewGroup("handoff-read", geecache.GetterFunc(func(key string) ([]byte, error) {
+		sourceLoads++
+		return []byte("from-source"), nil
+	}), geecache.WithCapacity(2<<10))
+	if err != nil {
+		t.Fatal(err)
+	}
+	g.RegisterServer(pool)
+
+	pool.AddPeer(self)
+
+	ring := consistence.NewMap(50, nil)
+	ring.AddNode(old.URL, self)
+	var checked int
+	for i := 0; i < 50; i++ {
+		key := "key-" + strconv.Itoa(i)
+		if ring.GetNode(key) != self {
+			continue
+		}
+		checked++
+		if v, err := g.GetCacheValue(key); err != nil || v.String() != "from-old-owner" {
+			t.Fatalf("%s: expect value from old owner, got %v %v", key, v, err)
+		}
+	}
+	if checked == 0 || sourceLoads != 0 {
+		t.Fatalf("checked %d keys, source loads %d", checked, sourceLoads)
+	}
+}
+
+// 节点启动时自身已在节点列表中, 同样从不包含自身时的所有者双读
+func TestHandoffSelfInInitialPeers(t *testing.T) {
+	old := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte("from-old-owner"))
+	}))
+	defer old.Close()
+
+	setups := map[string]func(pool *geecache.HTTPPool, self string){
+		"enable-after-set": func(pool *geecache.HTTPPool, self string) {
+			pool.Set(self, old.URL)
+			pool.EnableHandoff(geecache.HandoffConfig{Window: time.Minute})
+		},
+		"set-after-enable": func(pool *geecache.HTTPPool, self string) {
+			pool.EnableHandoff(geecache.HandoffConfig{Window: time.Minute})
+			pool.Set(self, old.URL)
+		},
+	}
+	for name, setup := range setups {
+		t.Run(name, func(t *testing.T) {
+			self := "http://localhost:9103"
+			pool := newTestPool(t, self)
+			setup(pool, self)
+
+			var sourceLoads int
+			group := "handoff-initial-" + name
+			removeOnCleanup(t, group)
+			g, err := geecache.NewGroup(group, geecache.GetterFunc(func(key string) ([]byte, error) {
+				sourceLoads++
+				return []byte("from-source"), nil
+			}), geecache.WithCapacity(2<<10))
+			if err != nil {
+				t.Fatal(err)
+			}
+			g.RegisterServer(pool)
+
+			ring := consistence.NewMap(50, nil)
+			ring.AddNode(old.URL, self)
+			var checked int
+			for i := 0; i < 50; i++ {
+				key := "key-" + strconv.Itoa(i)
+				if ring.GetNode(key) != self {
+					continue
+				}
+				checked++
+				if v, err := g.GetCacheValue(key); err != nil || v.String() != "from-old-owner" {
+					t.Fatalf("%s: expect value from old owner, got %v %v", key, v, err)
+				}
+			}
+			if checked == 0 || sourceLoads != 0 {
+				t.Fatalf("checked %d keys, source loads %d", checked, sourceLoads)
+			}
+		})
+	}
+}
+
+// 新节点加入后, 旧所有者将迁移的热点key推送给新节点
+func TestHandoffTransfer(t *testing.T) {
+	var mu sync.Mutex
+	received := make(map[string]string)
+	peer := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method == http.MethodPut && strings.Contains(r.URL.Path, "/handoff-transfer/") {
+			mu.Lock()
+			received[r.URL.Path[strings.LastIndex(r.URL.Path, "/")+1:]] = r.Method
+			mu.Unlock()
+		}
+		w.WriteHeader(http.StatusNoContent)
+	}))
+	defer peer.Close()
+
+	self := "http://localhost:9102"
+	pool := newTestPool(t, self)
+	pool.Set(self)
+
+	removeOnCleanup(t, "handoff-transfer")
+	g, err := geecache.NewGroup("handoff-transfer", geecache.GetterFunc(func(key string) ([]byte, error) {
+		return []byte(key), nil
+	}), geecache.WithCapacity(2<<10))
+	if err != nil {
+		t.Fatal(err)
+	}
+	g.RegisterServer(pool)
+
+	var keys []string
+	for i := 0; i < 20; i++ {
+		key := "key-" + strconv.Itoa(i)
+		keys = append(keys, key)
+		g.GetCacheValue(key)
+	}
+
+	pool.EnableHandoff(geecache.HandoffConfig{Window: time.Minute, TransferKeys: 100, TransferRate: 1000})
+	pool.AddPeer(peer.URL)
+
+	ring := consistence.NewMap(50, nil)
+	ring.AddNode(self, peer.URL)
+	var expect int
+	for _, key := range keys {
+		if ring.GetNode(key) == peer.URL {
+			expect++
+		}
+	}
+
+	deadline := time.Now().Add(2 * time.Second)
+	for time.Now().Before(deadline) {
+		mu.Lock()
+		n := len(received)
+		mu.Unlock()
+		if n == expect {
+			return
+		}
+		time.Sleep(20 * time.Millisecond)
+	}
+	t.Fatalf("transferred %d keys, expect %d", len(received), expect)
+}
+
+// 所有key都选择权重最大的节点, 权重相同时选择地址最小的节点
+//
+// 测试中其他节点的地址比自身小, 权重相同时全部key都属于其他节点.
+type heaviestNodePicker struct {
+	nodes map[string]int
+}
+
+func newHeaviestNodePicker() consistence.Picker {
+	return &heaviestNodePicker{nodes: make(map[string]int)}
+}
+
+func (p *heaviestNodePicker) AddNode(keys ...string) {
+	for _, key := range keys {
+		p.nodes[key] = 1
+	}
+}
+
+func (p *heaviestNodePicker) AddWeightedNode(key string, weight int) { p.nodes[key] = weight }
+
+func (p *heaviestNodePicker) RemoveNode(keys ...string) {
+	for _, key := range keys {
+		delete(p.nodes, key)
+	}
+}
+
+func (p *heaviestNodePicker) GetNode(key string) string {
+	var owner string
+	for _, node := range p.Nodes() {
+		if owner == "" || p.nodes[node] > p.nodes[owner] {
+			owner = node
+		}
+	}
+	return owner
+}
+
+func (p *heaviestNodePicker) Nodes() []string {
+	nodes := make([]string, 0, len(p.nodes))
+	for node := range p.nodes {
+		nodes = append(nodes, node)
+	}
+	sort.Strings(nodes)
+	return nodes
+}
+
+// arena存储有多个分片时, 主动迁移的热点key轮流取自各分片
+func TestHandoffTransferArenaShards(t *testing.T) {
+	var mu sync.Mutex
+	received := make(map[string]bool)
+	peer := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method == http.MethodPut && strings.Contains(r.URL.Path, "/handoff-arena/") {
+			mu.Lock()
+			received[r.URL.Path[strings.LastIndex(r.URL.Path, "/")+1:]] = true
+			mu.Unlock()
+		}
+		w.WriteHeader(http.StatusNoContent)
+	}))
+	defer peer.Close()
+
+	self := "http://localhost:9103"
+	pool := newTestPool(t, self)
+	pool.SetPicker(newHeaviestNodePicker)
+	pool.Set(self)
+
+	const shards, transferKeys = 4, 8
+	g, _ := newArenaGroup(t, "handoff-arena", 0, 1<<20, shards)
+	removeOnCleanup(t, "handoff-arena")
+	g.RegisterServer(pool)
+
+	perShard := make([][]string, shards)
+	for i := 0; i < 40; i++ {
+		key := "key-" + strconv.Itoa(i)
+		g.GetCacheValue(key)
+		idx := consistence.DefaultHash([]byte(key)) % shards
+		perShard[idx] = append(perShard[idx], key)
+	}
+	// 每个分片最近写入的transferKeys/shards个key是热点key
+	expect := make(map[string]bool)
+	for _, keys := range perShard {
+		if len(keys) < transferKeys/shards {
+			t.Fatalf("shard holds %d keys, want at least %d", len(keys), transferKeys/shards)
+		}
+		for _, key := range keys[len(keys)-transferKeys/shards:] {
+			expect[key] = true
+		}
+	}
+
+	pool.EnableHandoff(geecache.HandoffConfig{Window: time.Minute, TransferKeys: transferKeys, TransferRate: 1000})
+	pool.AddPeer(peer.URL)
+
+	deadline := time.Now().Add(2 * time.Second)
+	for time.Now().Before(deadline) {
+		mu.Lock()
+		n := len(received)
+		mu.Unlock()
+		if n == len(expect) {
+			break
+		}
+		time.Sleep(20 * time.Millisecond)
+	}
+
+	mu.Lock()
+	defer mu.Unlock()
+	if !reflect.DeepEqual(received, expect) {
+		t.Fatalf("transferred %v, expect %v", received, expect)
+	}
+}
+
+// 只调整权重时节点成员不变, 不重新开始迁移
+func TestHandoffIgnoresWeightChange(t *testing.T) {
+	var mu sync.Mutex
+	var transferred int
+	peer := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method == http.MethodPut {
+			mu.Lock()
+			transferred++
+			mu.Unlock()
+		}
+		w.WriteHeader(http.StatusNoContent)
+	}))
+	defer peer.Close()
+
+	self := "http://localhost:9104"
+	pool := newTestPool(t, self)
+	pool.SetPicker(newHeaviestNodePicker)
+	pool.SetWeighted(map[string]int{self: 2, peer.URL: 1})
+
+	g := newCacheGroup(t, "handoff-weight", 0, 1)
+	removeOnCleanup(t, "handoff-weight")
+	g.RegisterServer(pool)
+	for i := 0; i < 10; i++ {
+		g.GetCacheValue("key-" + strconv.Itoa(i))
+	}
+
+	pool.EnableHandoff(geecache.HandoffConfig{Window: time.Minute, TransferKeys: 100, TransferRate: 1000})
+	// 全部key的所有者从自身变为对方, 但节点成员没有变化
+	pool.AddWeightedPeer(peer.URL, 3)
+	time.Sleep(200 * time.Millisecond)
+
+	mu.Lock()
+	defer mu.Unlock()
+	if transferred != 0 {
+		t.Fatalf("weight change transferred %d keys", transferred)
+	}
+}
+
+// 迁移的值保留剩余的过期时间; 迁移速率过大时被限制, 不会使发送间隔为0
+func TestHandoffKeepsExpiry(t *testing.T) {
+	queries := make(chan url.Values, 100)
+	peer := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method == http.MethodPut && strings.Contains(r.URL.Path, "/handoff-expiry/") {
+			queries <- r.URL.Query()
+		}
+		w.WriteHeader(http.StatusNoContent)
+	}))
+	defer peer.Close()
+
+	self := "http://localhost:9104"
+	pool := newTestPool(t, self)
+	pool.Set(self)
+
+	removeOnCleanup(t, "handoff-expiry")
+	g, err := geecache.NewGroup("handoff-expiry", geecache.GetterFunc(func(key string) ([]byte, error) {
+		return []byte(key), nil
+	}), geecache.WithCapacity(2<<10), geecache.WithTTL(time.Minute))
+	if err != nil {
+		t.Fatal(err)
+	}
+	g.RegisterServer(pool)
+	for i := 0; i < 20; i++ {
+		g.GetCacheValue("key-" + strconv.Itoa(i))
+	}
+
+	pool.EnableHandoff(geecache.HandoffConfig{Window: time.Minute, TransferKeys: 100, TransferRate: 1 << 40})
+	pool.AddPeer(peer.URL)
+
+	select {
+	case q := <-queries:
+		expireAt, err := strconv.ParseInt(q.Get("expire_at"), 10, 64)
+		if q.Get("handoff") == "" || err != nil {
+			t.Fatalf("transfer sent as a plain set: %v", q)
+		}
+		if now := time.Now().Unix(); expireAt < now || expireAt > now+60 {
+			t.Fatalf("expire_at %d should be within the remaining ttl", expireAt)
+		}
+	case <-time.After(2 * time.Second):
+		t.Fatal("no key transferred")
+	}
+}
+
+// 接收迁移的值时不写预写日志, 已过期的值被丢弃
+func TestHandoffSkipsWAL(t *testing.T) {
+	snapshotDir, walDir := t.TempDir(), t.TempDir()
+	conf := wal.DefaultConfig(walDir)
+	conf.Sync = wal.SyncNever
+	registry := geecache.NewRegistry()
+	g, err := registry.NewGroup("handoff-wal", geecache.GetterFunc(func(key string) ([]byte, error) {
+		return nil, fmt.Errorf("%s not exist", key)
+	}), geecache.WithSnapshotDir(snapshotDir, 0), geecache.WithWAL(conf))
+	if err != nil {
+		t.Fatal(err)
+	}
+	pool := newTestPool(t, "http://localhost:9105", geecache.WithRegistry(registry))
+
+	handoff := func(key string, expireAt int64) {
+		w := httptest.NewRecorder()
+		u := fmt.Sprintf("/_geecache/handoff-wal/%s?handoff=1&expire_at=%d", key, expireAt)
+		pool.ServeHTTP(w, httptest.NewRequest(http.MethodPut, u, strings.NewReader("v")))
+		if w.Code != http.StatusNoContent {
+			t.Fatalf("handoff %s returned %d", key, w.Code)
+		}
+	}
+	handoff("Tom", time.Now().Add(time.Minute).Unix())
+	handoff("Jack", time.Now().Add(-time.Minute).Unix())
+
+	if v, err := g.GetCacheValue("Tom"); err != nil || v.String() != "v" {
+		t.Fatalf("Tom = %v %v", v, err)
+	}
+	if _, err := g.GetCacheValue("Jack"); err == nil {
+		t.Fatal("expired handoff value was cached")
+	}
+
+	// 模拟崩溃后恢复, 迁移的值不在日志中
+	recovered, err := geecache.NewRegistry().NewGroup("handoff-wal", geecache.GetterFunc(func(key string) ([]byte, error) {
+		return nil, fmt.Errorf("%s not exist", key)
+	}), geecache.WithSnapshotDir(snapshotDir, 0), geecache.WithWAL(conf))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer recovered.Close()
+	if err := recovered.Recover(); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := recovered.GetCacheValue("Tom"); err == nil {
+		t.Fatal("handoff value was written to the wal")
+	}
+}
@@ -0,0 +1,170 @@
+package test
+
+import (
+	"geecache"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"strconv"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func waitHealthyPeers(t *testing.T, pool *geecache.HTTPPool, expect []string) {
+	deadline := time.Now().Add(3 * time.Second)
+	for time.Now().Before(deadline) {
+		if reflect.DeepEqual(pool.HealthyPeers(), expect) {
+			return
+		}
+		time.Sleep(20 * time.Millisecond)
+	}
+	t.Fatalf("healthy peers = %v, expect %v", pool.HealthyPeers(), expect)
+}
+
+func TestHealthCheck(t *testing.T) {
+	var down atomic.Bool
+	peer := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if down.Load() {
+			http.Error(w, "down", http.StatusServiceUnavailable)
+			return
+		}
+		w.WriteHeader(http.StatusOK)
+	}))
+	defer peer.Close()
+
+	self := "http://localhost:8001"
+	pool := newTestPool(t, self)
+	pool.Set(self, peer.URL)
+
+	conf := geecache.DefaultHealthConfig()
+	conf.Interval = 20 * time.Millisecond
+	pool.StartHealthCheck(conf)
+	defer pool.StopHealthCheck()
+
+	down.Store(true)
+	waitHealthyPeers(t, pool, []string{self})
+	if peers := pool.Peers(); len(peers) != 2 {
+		t.Fatalf("ejected peer should stay a member, got %v", peers)
+	}
+
+	down.Store(false)
+	waitHealthyPeers(t, pool, pool.Peers())
+}
+
+// 请求错误率达到阈值时被动剔除节点, 之后通过主动探测恢复
+func TestHealthPassiveEjection(t *testing.T) {
+	var down atomic.Bool
+	down.Store(true)
+	var requests atomic.Int32
+	peer := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if down.Load() {
+			if r.URL.Path != "/health" {
+				requests.Add(1)
+			}
+			http.Error(w, "down", http.StatusServiceUnavailable)
+			return
+		}
+		w.Write([]byte("from-peer"))
+	}))
+	defer peer.Close()
+
+	self := "http://localhost:8002"
+	pool := newTestPool(t, self)
+	pool.Set(self, peer.URL)
+
+	conf := geecache.DefaultHealthConfig()
+	conf.Interval = 20 * time.Millisecond
+	conf.UnhealthyThreshold = 1000 // 只通过请求错误率剔除
+	conf.MinRequests = 3
+	conf.ErrorRate = 0.5
+	pool.StartHealthCheck(conf)
+	defer pool.StopHealthCheck()
+
+	removeOnCleanup(t, "health-passive")
+	g, err := geecache.NewGroup("health-passive", echoGetter(), geecache.WithCapacity(2<<10))
+	if err != nil {
+		t.Fatal(err)
+	}
+	g.RegisterServer(pool)
+
+	var keys []string
+	for i := 0; len(keys) < conf.MinRequests; i++ {
+		if key := "key-" + strconv.Itoa(i); pool.Ring(key, 1).Owner == peer.URL {
+			keys = append(keys, key)
+		}
+	}
+	for i, key := range keys {
+		if !reflect.DeepEqual(pool.HealthyPeers(), pool.Peers()) {
+			t.Fatalf("peer ejected after %d of %d failed requests", i, conf.MinRequests)
+		}
+		// 节点请求失败时从数据源加载
+		if v, err := g.GetCacheValue(key); err != nil || v.String() != key {
+			t.Fatalf("%s = %v %v", key, v, err)
+		}
+	}
+	if n := requests.Load(); n != int32(conf.MinRequests) {
+		t.Fatalf("expect %d peer requests, got %d", conf.MinRequests, n)
+	}
+	if healthy := pool.HealthyPeers(); !reflect.DeepEqual(healthy, []string{self}) {
+		t.Fatalf("peer should be ejected by error rate, healthy peers %v", healthy)
+	}
+	if peers := pool.Peers(); len(peers) != 2 {
+		t.Fatalf("ejected peer should stay a member, got %v", peers)
+	}
+
+	down.Store(false)
+	waitHealthyPeers(t, pool, pool.Peers())
+}
+
+// 节点被删除后健康状态一并删除, 重新加入时不沿用之前的失败次数
+func TestHealthPrunedOnRemove(t *testing.T) {
+	peer := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		http.Error(w, "down", http.StatusServiceUnavailable)
+	}))
+	defer peer.Close()
+
+	self := "http://localhost:8003"
+	pool := newTestPool(t, self)
+	pool.Set(self, peer.URL)
+
+	conf := geecache.DefaultHealthConfig()
+	conf.Interval = time.Hour // 只通过请求错误率剔除
+	conf.Window = time.Hour
+	conf.MinRequests = 3
+	pool.StartHealthCheck(conf)
+	defer pool.StopHealthCheck()
+
+	removeOnCleanup(t, "health-prune")
+	g, err := geecache.NewGroup("health-prune", echoGetter(), geecache.WithCapacity(2<<10))
+	if err != nil {
+		t.Fatal(err)
+	}
+	g.RegisterServer(pool)
+
+	var keys []string
+	for i := 0; len(keys) < conf.MinRequests; i++ {
+		if key := "key-" + strconv.Itoa(i); pool.Ring(key, 1).Owner == peer.URL {
+			keys = append(keys, key)
+		}
+	}
+	for _, key := range keys[:conf.MinRequests-1] {
+		g.GetCacheValue(key)
+	}
+
+	pool.RemovePeer(peer.URL)
+	pool.AddPeer(peer.URL)
+	g.GetCacheValue(keys[conf.MinRequests-1])
+	if healthy := pool.HealthyPeers(); !reflect.DeepEqual(healthy, pool.Peers()) {
+		t.Fatalf("re-added peer ejected by stale failures, healthy peers %v", healthy)
+	}
+}
+
+func TestHealthEndpoint(t *testing.T) {
+	pool := newTestPool(t, "http://localhost:8001")
+	w := httptest.NewRecorder()
+	pool.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/health", nil))
+	if w.Code != http.StatusOK {
+		t.Fatalf("health returned %d", w.Code)
+	}
+}
@@ -0,0 +1,228 @@
+package test
+
+import (
+	"errors"
+	"geecache"
+	"geecache/wal"
+	"os"
+	"path/filepath"
+	"runtime"
+	"strconv"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func echoGetter() geecache.Getter {
+	return geecache.GetterFunc(func(key string) ([]byte, error) {
+		return []byte(key), nil
+	})
+}
+
+// 测试结束时从默认注册表中删除group, 重名会导致创建失败, 使用-count=N时需要清理
+func removeOnCleanup(t testing.TB, name string) {
+	t.Cleanup(func() { geecache.RemoveGroup(name) })
+}
+
+func TestGroupDuplicateName(t *testing.T) {
+	removeOnCleanup(t, "lifecycle-dup")
+	g, err := geecache.NewGroup("lifecycle-dup", echoGetter(), geecache.WithCapacity(2<<10))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer g.Close()
+
+	if _, err := geecache.NewGroup("lifecycle-dup", echoGetter(), geecache.WithCapacity(2<<10)); !errors.Is(err, geecache.ErrGroupExists) {
+		t.Fatalf("duplicate NewGroup err = %v, want ErrGroupExists", err)
+	}
+	if geecache.GetCacheGroup("lifecycle-dup") != g {
+		t.Fatal("duplicate NewGroup replaced the existing group")
+	}
+
+	replaced, err := geecache.ReplaceGroup("lifecycle-dup", echoGetter(), geecache.WithCapacity(2<<10))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if geecache.GetCacheGroup("lifecycle-dup") != replaced {
+		t.Fatal("ReplaceGroup did not register the new group")
+	}
+	if _, err := g.GetCacheValue("key"); !errors.Is(err, geecache.ErrGroupClosed) {
+		t.Errorf("replaced group get err = %v, want ErrGroupClosed", err)
+	}
+	if v, err := replaced.GetCacheValue("key"); err != nil || v.String() != "key" {
+		t.Errorf("new group get = %q, %v", v.String(), err)
+	}
+}
+
+// 新的group启动失败时保留原来的group
+func TestReplaceGroupStartFailure(t *testing.T) {
+	registry := geecache.NewRegistry()
+	walDir, snapshotDir := t.TempDir(), t.TempDir()
+	old, err := registry.NewGroup("lifecycle-replace", echoGetter(),
+		geecache.WithSnapshotDir(snapshotDir, 0), geecache.WithWAL(wal.DefaultConfig(walDir)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer old.Close()
+
+	// 两个group同时运行, 不能共用预写日志目录
+	if _, err := registry.ReplaceGroup("lifecycle-replace", echoGetter(),
+		geecache.WithSnapshotDir(t.TempDir(), 0), geecache.WithWAL(wal.DefaultConfig(walDir))); err == nil {
+		t.Fatal("ReplaceGroup should reject the wal dir of the replaced group")
+	}
+
+	// 原来的group关闭时写入的快照会覆盖新group的快照
+	if _, err := registry.ReplaceGroup("lifecycle-replace", echoGetter(), geecache.WithSnapshotDir(snapshotDir, 0)); err == nil {
+		t.Fatal("ReplaceGroup should reject the snapshot dir of the replaced group")
+	}
+
+	// 预写日志目录是文件, 无法打开
+	file := filepath.Join(t.TempDir(), "file")
+	if err := os.WriteFile(file, nil, 0o644); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := registry.ReplaceGroup("lifecycle-replace", echoGetter(),
+		geecache.WithSnapshotDir(t.TempDir(), 0), geecache.WithWAL(wal.DefaultConfig(file))); err == nil {
+		t.Fatal("ReplaceGroup should fail when the new group cannot start")
+	}
+	if registry.Group("lifecycle-replace") != old {
+		t.Fatal("failed ReplaceGroup should keep the old group")
+	}
+	if v, err := old.GetCacheValue("key"); err != nil || v.String() != "key" {
+		t.Fatalf("old group get = %q, %v", v.String(), err)
+	}
+}
+
+// 同名的group并发替换时, 目录检查和替换看到的是同一个原来的group, 不会有两个group打开同一个预写日志目录
+func TestReplaceGroupConcurrent(t *testing.T) {
+	registry := geecache.NewRegistry()
+	walDir := t.TempDir()
+
+	var wg sync.WaitGroup
+	var replaced atomic.Int32
+	for i := 0; i < 32; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			conf := wal.DefaultConfig(walDir)
+			conf.Sync = wal.SyncNever
+			if _, err := registry.ReplaceGroup("lifecycle-replace-concurrent", echoGetter(),
+				geecache.WithSnapshotDir(t.TempDir(), 0), geecache.WithWAL(conf)); err == nil {
+				replaced.Add(1)
+			}
+		}()
+	}
+	wg.Wait()
+	defer registry.RemoveGroup("lifecycle-replace-concurrent")
+
+	if n := replaced.Load(); n != 1 {
+		t.Fatalf("%d groups opened the same wal dir", n)
+	}
+}
+
+func TestGroupClose(t *testing.T) {
+	removeOnCleanup(t, "lifecycle-close")
+	g, err := geecache.NewGroup("lifecycle-close", echoGetter(), geecache.WithCapacity(2<<10))
+	if err != nil {
+		t.Fatal(err)
+	}
+	g.GetCacheValue("key")
+
+	g.Close()
+	g.Close()
+	if geecache.GetCacheGroup("lifecycle-close") != nil {
+		t.Error("closed group is still registered")
+	}
+	if _, err := g.GetCacheValue("key"); !errors.Is(err, geecache.ErrGroupClosed) {
+		t.Errorf("get err = %v, want ErrGroupClosed", err)
+	}
+	if err := g.Set("key", []byte("v")); !errors.Is(err, geecache.ErrGroupClosed) {
+		t.Errorf("set err = %v, want ErrGroupClosed", err)
+	}
+	if stats := g.CacheStats(); stats.Entries != 0 {
+		t.Errorf("closed group still holds %d entries", stats.Entries)
+	}
+
+	// 名称可以重新使用
+	g, err = geecache.NewGroup("lifecycle-close", echoGetter(), geecache.WithCapacity(2<<10))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !geecache.RemoveGroup("lifecycle-close") || geecache.RemoveGroup("lifecycle-close") {
+		t.Error("RemoveGroup should remove the group exactly once")
+	}
+	if _, err := g.GetCacheValue("key"); !errors.Is(err, geecache.ErrGroupClosed) {
+		t.Errorf("removed group get err = %v, want ErrGroupClosed", err)
+	}
+}
+
+// 最后一次快照失败时Close返回错误
+func TestGroupCloseSnapshotError(t *testing.T) {
+	snapshotDir := filepath.Join(t.TempDir(), "snapshots")
+	if err := os.Mkdir(snapshotDir, 0o755); err != nil {
+		t.Fatal(err)
+	}
+	g, err := geecache.NewRegistry().NewGroup("lifecycle-close-error", echoGetter(), geecache.WithSnapshotDir(snapshotDir, 0))
+	if err != nil {
+		t.Fatal(err)
+	}
+	g.GetCacheValue("key")
+
+	if err := os.RemoveAll(snapshotDir); err != nil {
+		t.Fatal(err)
+	}
+	if err := g.Close(); !errors.Is(err, os.ErrNotExist) {
+		t.Fatalf("close err = %v, want the snapshot error", err)
+	}
+	if err := g.Close(); err != nil {
+		t.Fatalf("second close err = %v", err)
+	}
+}
+
+func TestGroupCloseStopsGoroutines(t *testing.T) {
+	before := runtime.NumGoroutine()
+	for i := 0; i < 50; i++ {
+		name := "lifecycle-leak-" + strconv.Itoa(i)
+		g, err := geecache.NewGroup(name, echoGetter(), geecache.WithCapacity(2<<10))
+		if err != nil {
+			t.Fatal(err)
+		}
+		g.GetCacheValue("key")
+		geecache.RemoveGroup(name)
+	}
+
+	deadline := time.Now().Add(time.Second)
+	for runtime.NumGoroutine() > before+5 && time.Now().Before(deadline) {
+		time.Sleep(10 * time.Millisecond)
+	}
+	if n := runtime.NumGoroutine(); n > before+5 {
+		t.Errorf("goroutines grew from %d to %d after closing groups", before, n)
+	}
+}
+
+func TestRegisterServerReplace(t *testing.T) {
+	removeOnCleanup(t, "lifecycle-server")
+	g, err := geecache.NewGroup("lifecycle-server", echoGetter(), geecache.WithCapacity(2<<10))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer g.Close()
+
+	first := &fakeClient{name: "first", values: map[string][]byte{"a": []byte("first")}}
+	second := &fakeClient{name: "second", values: map[string][]byte{"b": []byte("second")}}
+	g.RegisterServer(&fakeReplicaServer{clients: []geecache.NodeClient{first}})
+	if v, _ := g.GetCacheValue("a"); v.String() != "first" {
+		t.Fatalf("get a = %q, want first", v.String())
+	}
+
+	g.RegisterServer(&fakeReplicaServer{clients: []geecache.NodeClient{second}})
+	if v, _ := g.GetCacheValue("b"); v.String() != "second" || first.gets != 1 {
+		t.Errorf("get b = %q after replacing server, first called %d times", v.String(), first.gets)
+	}
+
+	g.RegisterServer(nil)
+	if v, _ := g.GetCacheValue("c"); v.String() != "c" || second.gets != 1 {
+		t.Errorf("get c = %q after unregistering server, second called %d times", v.String(), second.gets)
+	}
+}
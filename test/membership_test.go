@@ -0,0 +1,70 @@
+package test
+
+import (
+	"fmt"
+	"geecache"
+	"geecache/membership"
+	"reflect"
+	"testing"
+	"time"
+)
+
+func newGossipNode(t *testing.T, name string) (*membership.Memberlist, *geecache.HTTPPool) {
+	pool := newTestPool(t, name)
+	conf := membership.DefaultConfig(name, "127.0.0.1:0")
+	conf.ProbeInterval = 50 * time.Millisecond
+	conf.ProbeTimeout = 20 * time.Millisecond
+	conf.SuspicionTimeout = 200 * time.Millisecond
+	conf.OnJoin = func(m membership.Member) { pool.AddPeer(m.Name) }
+	conf.OnLeave = func(m membership.Member) { pool.RemovePeer(m.Name) }
+
+	list, err := membership.Create(conf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return list, pool
+}
+
+func waitPeers(t *testing.T, pool *geecache.HTTPPool, expect []string) {
+	deadline := time.Now().Add(3 * time.Second)
+	for time.Now().Before(deadline) {
+		if reflect.DeepEqual(pool.Peers(), expect) {
+			return
+		}
+		time.Sleep(20 * time.Millisecond)
+	}
+	t.Fatalf("peers = %v, expect %v", pool.Peers(), expect)
+}
+
+func TestGossipMembership(t *testing.T) {
+	var lists []*membership.Memberlist
+	var pools []*geecache.HTTPPool
+	var names []string
+	for i := 1; i <= 3; i++ {
+		name := fmt.Sprintf("http://localhost:800%d", i)
+		list, pool := newGossipNode(t, name)
+		defer list.Shutdown()
+		lists = append(lists, list)
+		pools = append(pools, pool)
+		names = append(names, name)
+	}
+
+	for _, list := range lists[1:] {
+		if err := list.Join(lists[0].Addr()); err != nil {
+			t.Fatal(err)
+		}
+	}
+	for _, pool := range pools {
+		waitPeers(t, pool, names)
+	}
+
+	// 节点3故障, 其他节点通过探测发现并将其移出哈希环
+	lists[2].Shutdown()
+	for _, pool := range pools[:2] {
+		waitPeers(t, pool, names[:2])
+	}
+
+	// 节点2主动离开
+	lists[1].Leave()
+	waitPeers(t, pools[0], names[:1])
+}
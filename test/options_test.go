@@ -0,0 +1,208 @@
+package test
+
+import (
+	"geecache"
+	"geecache/wal"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+func newTestPool(t testing.TB, self string, opts ...geecache.PoolOption) *geecache.HTTPPool {
+	pool, err := geecache.NewHTTPPool(self, opts...)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return pool
+}
+
+type recordingMetrics struct {
+	geecache.NopMetrics
+	mu      sync.Mutex
+	hits    int
+	misses  int
+	sources []string
+	peers   []string
+}
+
+func (m *recordingMetrics) CacheHit(group string)  { m.mu.Lock(); m.hits++; m.mu.Unlock() }
+func (m *recordingMetrics) CacheMiss(group string) { m.mu.Lock(); m.misses++; m.mu.Unlock() }
+
+func (m *recordingMetrics) Load(group, source string, d time.Duration, err error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.sources = append(m.sources, source)
+}
+
+func (m *recordingMetrics) PeerRequest(peer string, ok bool) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.peers = append(m.peers, peer)
+}
+
+type countingTransport struct {
+	mu       sync.Mutex
+	requests int
+}
+
+func (c *countingTransport) RoundTrip(r *http.Request) (*http.Response, error) {
+	c.mu.Lock()
+	c.requests++
+	c.mu.Unlock()
+	return http.DefaultTransport.RoundTrip(r)
+}
+
+type bufferLogger struct {
+	mu    sync.Mutex
+	lines []string
+}
+
+func (l *bufferLogger) Printf(format string, v ...interface{}) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.lines = append(l.lines, format)
+}
+
+func TestGroupOptionValidation(t *testing.T) {
+	cases := map[string][]geecache.GroupOption{
+		"negative capacity": {geecache.WithCapacity(-1)},
+		"short ttl":         {geecache.WithTTL(500 * time.Millisecond)},
+		"zero cleanup":      {geecache.WithCleanupInterval(0)},
+		"zero shards":       {geecache.WithShards(0)},
+		"zero replicas":     {geecache.WithReplication(0, false)},
+		"nil compressor":    {geecache.WithCompression(nil, 0)},
+		"nil logger":        {geecache.WithLogger(nil)},
+		"wal without dir":   {geecache.WithWAL(wal.DefaultConfig(t.TempDir()))},
+		"arena with lru": {
+			geecache.WithStorage(geecache.StorageArena, 0),
+			geecache.WithEvictionPolicy(geecache.EvictLRU),
+		},
+	}
+	for name, opts := range cases {
+		if g, err := geecache.NewGroup("options-invalid", echoGetter(), opts...); err == nil {
+			g.Close()
+			t.Errorf("%s: expected error", name)
+		}
+	}
+	if _, err := geecache.NewGroup("options-nil-getter", nil); err == nil {
+		t.Error("nil getter: expected error")
+	}
+	if geecache.GetCacheGroup("options-invalid") != nil {
+		t.Error("invalid group was registered")
+	}
+}
+
+func TestPoolOptionValidation(t *testing.T) {
+	cases := map[string][]geecache.PoolOption{
+		"relative base path": {geecache.WithBasePath("cache/")},
+		"same paths":         {geecache.WithBasePath("/x/"), geecache.WithAdminPath("/x/")},
+		"zero virtual nodes": {geecache.WithVirtualNodes(0)},
+		"nil hash":           {geecache.WithHashFunc(nil)},
+		"nil transport":      {geecache.WithTransport(nil)},
+		"nil registry":       {geecache.WithRegistry(nil)},
+	}
+	for name, opts := range cases {
+		if _, err := geecache.NewHTTPPool("http://localhost:9501", opts...); err == nil {
+			t.Errorf("%s: expected error", name)
+		}
+	}
+	if _, err := geecache.NewHTTPPool(""); err == nil {
+		t.Error("empty self: expected error")
+	}
+}
+
+func TestGroupTTL(t *testing.T) {
+	loads := 0
+	removeOnCleanup(t, "options-ttl")
+	g, err := geecache.NewGroup("options-ttl", geecache.GetterFunc(func(key string) ([]byte, error) {
+		loads++
+		return []byte(key), nil
+	}), geecache.WithTTL(time.Second))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer g.Close()
+
+	g.GetCacheValue("key")
+	g.GetCacheValue("key")
+	if loads != 1 {
+		t.Fatalf("loaded %d times before expiry, want 1", loads)
+	}
+	time.Sleep(2100 * time.Millisecond)
+	g.GetCacheValue("key")
+	if loads != 2 {
+		t.Errorf("loaded %d times after expiry, want 2", loads)
+	}
+}
+
+func TestGroupFIFOEviction(t *testing.T) {
+	removeOnCleanup(t, "options-fifo")
+	g, err := geecache.NewGroup("options-fifo", echoGetter(),
+		geecache.WithCapacity(3), geecache.WithShards(1), geecache.WithEvictionPolicy(geecache.EvictFIFO))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer g.Close()
+
+	for _, key := range []string{"a", "b", "c", "a", "d"} {
+		g.GetCacheValue(key)
+	}
+	hits := g.CacheStats().Hits
+	g.GetCacheValue("b")
+	g.GetCacheValue("a")
+	// FIFO淘汰最早写入的a, 与读取顺序无关
+	if stats := g.CacheStats(); stats.Hits != hits+1 {
+		t.Errorf("want b kept and a evicted, stats = %+v", stats)
+	}
+}
+
+func TestPoolOptions(t *testing.T) {
+	registry := geecache.NewRegistry()
+	metrics := &recordingMetrics{}
+	logger := &bufferLogger{}
+	g, err := registry.NewGroup("scores", geecache.GetterFunc(func(key string) ([]byte, error) {
+		return []byte("remote-" + key), nil
+	}), geecache.WithLogger(logger), geecache.WithMetrics(metrics))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	peer := httptest.NewServer(newTestPool(t, "http://peer", geecache.WithRegistry(registry), geecache.WithBasePath("/cache/")))
+	defer peer.Close()
+
+	transport := &countingTransport{}
+	self := "http://localhost:9502"
+	pool := newTestPool(t, self,
+		geecache.WithBasePath("/cache/"),
+		geecache.WithTransport(transport),
+		geecache.WithPoolMetrics(metrics),
+		geecache.WithPoolLogger(logger),
+		geecache.WithVirtualNodes(10),
+		geecache.WithRegistry(registry))
+	pool.Set(peer.URL)
+	g.RegisterServer(pool)
+
+	view, err := g.GetCacheValue("Tom")
+	if err != nil || view.String() != "remote-Tom" {
+		t.Fatalf("get Tom = %q, %v", view.String(), err)
+	}
+	g.GetCacheValue("Tom")
+
+	if transport.requests != 1 {
+		t.Errorf("transport handled %d requests, want 1", transport.requests)
+	}
+	metrics.mu.Lock()
+	if metrics.hits != 1 || metrics.misses != 2 || len(metrics.peers) != 1 || metrics.peers[0] != peer.URL {
+		t.Errorf("metrics = hits %d, misses %d, peers %v", metrics.hits, metrics.misses, metrics.peers)
+	}
+	if strings.Join(metrics.sources, ",") != "source,peer" {
+		t.Errorf("load sources = %v, want [source peer]", metrics.sources)
+	}
+	metrics.mu.Unlock()
+	if len(logger.lines) == 0 {
+		t.Error("logger was not used")
+	}
+}
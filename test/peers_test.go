@@ -0,0 +1,120 @@
+package test
+
+import (
+	"encoding/json"
+	"geecache"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"strconv"
+	"sync"
+	"testing"
+)
+
+// 运行时增删节点, 地址末尾的/被去掉, 无效的地址被跳过
+func TestPoolAddRemovePeer(t *testing.T) {
+	self := "http://localhost:9401"
+	pool := newTestPool(t, self+"/")
+	if info := pool.Ring("", 0); info.Self != self {
+		t.Fatalf("self = %q, want %q", info.Self, self)
+	}
+
+	pool.AddPeer(self, "http://10.0.0.1:8001/", "", "10.0.0.2:8001", "http://10.0.0.3:8001")
+	expect := []string{"http://10.0.0.1:8001", "http://10.0.0.3:8001", self}
+	if peers := pool.Peers(); !reflect.DeepEqual(peers, expect) {
+		t.Fatalf("peers = %v, want %v", peers, expect)
+	}
+
+	version := pool.Ring("", 0).Version
+	pool.AddPeer("http://10.0.0.1:8001")
+	if pool.Ring("", 0).Version != version {
+		t.Fatal("adding an existing peer changed the ring")
+	}
+
+	pool.RemovePeer("http://10.0.0.1:8001/", "http://10.0.0.9:8001")
+	expect = []string{"http://10.0.0.3:8001", self}
+	if peers := pool.Peers(); !reflect.DeepEqual(peers, expect) {
+		t.Fatalf("peers = %v, want %v", peers, expect)
+	}
+
+	if _, err := geecache.NewHTTPPool("localhost:9401"); err == nil {
+		t.Fatal("self without scheme: expected error")
+	}
+}
+
+func TestAdminPeers(t *testing.T) {
+	self := "http://localhost:9402"
+	pool := newTestPool(t, self)
+	pool.Set(self)
+
+	do := func(method, query string) (int, []string) {
+		w := httptest.NewRecorder()
+		pool.ServeHTTP(w, httptest.NewRequest(method, "/_geecache_admin/peers"+query, nil))
+		var peers []string
+		if w.Code == http.StatusOK {
+			if err := json.NewDecoder(w.Body).Decode(&peers); err != nil {
+				t.Fatal(err)
+			}
+		}
+		return w.Code, peers
+	}
+
+	if code, peers := do(http.MethodPost, "?peer=http://10.0.0.1:8001/"); code != http.StatusOK ||
+		!reflect.DeepEqual(peers, []string{"http://10.0.0.1:8001", self}) {
+		t.Fatalf("add peer = %d %v", code, peers)
+	}
+	for _, query := range []string{"", "?peer=", "?peer=10.0.0.2:8001", "?peer=ftp://10.0.0.2"} {
+		if code, _ := do(http.MethodPost, query); code != http.StatusBadRequest {
+			t.Errorf("add peer %q = %d, want 400", query, code)
+		}
+	}
+	if code, peers := do(http.MethodDelete, "?peer=http://10.0.0.1:8001"); code != http.StatusOK ||
+		!reflect.DeepEqual(peers, []string{self}) {
+		t.Fatalf("remove peer = %d %v", code, peers)
+	}
+	if code, peers := do(http.MethodGet, ""); code != http.StatusOK || !reflect.DeepEqual(peers, []string{self}) {
+		t.Fatalf("list peers = %d %v", code, peers)
+	}
+	if code, _ := do(http.MethodPut, ""); code != http.StatusMethodNotAllowed {
+		t.Fatalf("put peers = %d, want 405", code)
+	}
+}
+
+// 节点视图整体替换, 并发选择节点时不会看到构建一半的哈希环
+func TestPickNodeClientDuringSwap(t *testing.T) {
+	self := "http://localhost:9403"
+	pool := newTestPool(t, self)
+	pool.Set(self, "http://10.0.0.1:8001")
+
+	stop := make(chan struct{})
+	var wg sync.WaitGroup
+	for w := 0; w < 4; w++ {
+		wg.Add(1)
+		go func(w int) {
+			defer wg.Done()
+			for i := 0; ; i++ {
+				select {
+				case <-stop:
+					return
+				default:
+				}
+				if client, ok := pool.PickNodeClient("key-" + strconv.Itoa(i*4+w)); ok && client == nil {
+					t.Error("picked a nil client")
+					return
+				}
+			}
+		}(w)
+	}
+
+	for i := 0; i < 200; i++ {
+		peer := "http://10.0.0." + strconv.Itoa(2+i%5) + ":8001"
+		pool.AddPeer(peer)
+		pool.RemovePeer(peer)
+	}
+	close(stop)
+	wg.Wait()
+
+	if peers := pool.Peers(); !reflect.DeepEqual(peers, []string{"http://10.0.0.1:8001", self}) {
+		t.Fatalf("peers = %v after concurrent changes", peers)
+	}
+}
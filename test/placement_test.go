@@ -0,0 +1,299 @@
+package test
+
+import (
+	"encoding/json"
+	"fmt"
+	"geecache"
+	"geecache/consistence"
+	"math"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"sync/atomic"
+	"testing"
+)
+
+var placements = []struct {
+	name      string
+	newPicker func() consistence.Picker
+}{
+	{"ring", func() consistence.Picker { return consistence.NewMap(50, nil) }},
+	{"jump", func() consistence.Picker { return consistence.NewJump(nil) }},
+	{"rendezvous", func() consistence.Picker { return consistence.NewRendezvous(nil) }},
+	{"maglev", func() consistence.Picker { return consistence.NewMaglev(0, nil) }},
+	{"bounded", func() consistence.Picker { return consistence.NewBoundedLoad(50, 1.25, nil) }},
+}
+
+// 地址的最后一段都是三位数, 按名称排序与编号顺序一致
+func placementNodes(n int) []string {
+	nodes := make([]string, n)
+	for i := range nodes {
+		nodes[i] = fmt.Sprintf("http://10.0.0.%d:8001", i+101)
+	}
+	return nodes
+}
+
+func assign(picker consistence.Picker, keys int) []string {
+	owners := make([]string, keys)
+	for i := range owners {
+		owners[i] = picker.GetNode("key-" + strconv.Itoa(i))
+		if r, ok := picker.(consistence.LoadReporter); ok {
+			r.Done(owners[i])
+		}
+	}
+	return owners
+}
+
+// 各节点key数量的变异系数(标准差/平均值), 越小越均匀
+func coefficientOfVariation(owners []string, nodes int) float64 {
+	counts := make(map[string]int, nodes)
+	for _, owner := range owners {
+		counts[owner]++
+	}
+	mean := float64(len(owners)) / float64(nodes)
+	var variance float64
+	for _, c := range counts {
+		variance += (float64(c) - mean) * (float64(c) - mean)
+	}
+	return math.Sqrt(variance/float64(nodes)) / mean
+}
+
+func moved(before, after []string) float64 {
+	n := 0
+	for i := range before {
+		if before[i] != after[i] {
+			n++
+		}
+	}
+	return float64(n) / float64(len(before))
+}
+
+// 比较各算法的分布均匀程度, 以及增删节点时迁移的key比例
+func TestPlacementComparison(t *testing.T) {
+	const keys = 50000
+	nodes := placementNodes(11)
+
+	for _, pl := range placements {
+		picker := pl.newPicker()
+		picker.AddNode(nodes[:10]...)
+		before := assign(picker, keys)
+		cv := coefficientOfVariation(before, 10)
+
+		// 在末尾添加一个节点, 理想的迁移比例为1/11
+		picker.AddNode(nodes[10])
+		added := assign(picker, keys)
+		addMoved := moved(before, added)
+
+		// 删除最后添加的节点, 应当恢复原来的分布
+		picker.RemoveNode(nodes[10])
+		removeMoved := moved(added, assign(picker, keys))
+
+		t.Logf("%-10s cv=%.3f moved(add)=%.3f moved(remove)=%.3f", pl.name, cv, addMoved, removeMoved)
+		if addMoved > 2.0/11 {
+			t.Errorf("%s moved too many keys on add: %.3f", pl.name, addMoved)
+		}
+		if cv > 0.3 {
+			t.Errorf("%s distribution too uneven: cv=%.3f", pl.name, cv)
+		}
+	}
+}
+
+// 所有算法在相同的节点集合下应当给出确定的结果
+func TestPlacementDeterministic(t *testing.T) {
+	nodes := placementNodes(5)
+	for _, pl := range placements {
+		if pl.name == "bounded" {
+			continue // 有界负载依赖当前负载
+		}
+		a, b := pl.newPicker(), pl.newPicker()
+		a.AddNode(nodes...)
+		for i := len(nodes) - 1; i >= 0; i-- {
+			b.AddNode(nodes[i])
+		}
+		for i := 0; i < 1000; i++ {
+			key := "key-" + strconv.Itoa(i)
+			if a.GetNode(key) != b.GetNode(key) {
+				t.Fatalf("%s: %s mapped to %s and %s", pl.name, key, a.GetNode(key), b.GetNode(key))
+			}
+		}
+	}
+}
+
+// 有界负载: 未结束的请求使节点超载时, key会溢出到下一个节点
+func TestBoundedLoad(t *testing.T) {
+	picker := consistence.NewBoundedLoad(50, 1.25, nil)
+	picker.AddNode(placementNodes(4)...)
+
+	counts := make(map[string]int)
+	for i := 0; i < 100; i++ {
+		counts[picker.GetNode("hot-key")]++
+	}
+	limit := int(math.Ceil(1.25 * 100 / 4))
+	for node, c := range counts {
+		if c > limit {
+			t.Errorf("%s load %d exceeds bound %d", node, c, limit)
+		}
+	}
+}
+
+func BenchmarkPlacement(b *testing.B) {
+	nodes := placementNodes(50)
+	for _, pl := range placements {
+		picker := pl.newPicker()
+		picker.AddNode(nodes...)
+		b.Run(pl.name, func(b *testing.B) {
+			for i := 0; i < b.N; i++ {
+				node := picker.GetNode("key-" + strconv.Itoa(i))
+				if r, ok := picker.(consistence.LoadReporter); ok {
+					r.Done(node)
+				}
+			}
+		})
+	}
+}
+
+// 记录未结束的选择次数
+type countingPicker struct {
+	*consistence.BoundedLoad
+	outstanding atomic.Int64
+}
+
+func (p *countingPicker) GetNode(key string) string {
+	p.outstanding.Add(1)
+	return p.BoundedLoad.GetNode(key)
+}
+
+func (p *countingPicker) Done(node string) {
+	p.outstanding.Add(-1)
+	p.BoundedLoad.Done(node)
+}
+
+// 所有类型的节点请求结束后都要释放负载
+func TestBoundedLoadReleased(t *testing.T) {
+	peer := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.Method {
+		case http.MethodPut, http.MethodDelete:
+			w.WriteHeader(http.StatusNoContent)
+		case http.MethodPost:
+			var req struct{ Keys []string }
+			json.NewDecoder(r.Body).Decode(&req)
+			values := make(map[string][]byte)
+			for _, key := range req.Keys {
+				values[key] = []byte("remote")
+			}
+			json.NewEncoder(w).Encode(map[string]interface{}{"values": values})
+		default:
+			w.Write([]byte("remote"))
+		}
+	}))
+	defer peer.Close()
+
+	self := "http://localhost:9301"
+	picker := &countingPicker{BoundedLoad: consistence.NewBoundedLoad(50, 1.25, nil)}
+	pool := newTestPool(t, self)
+	pool.Set(self, peer.URL)
+	pool.SetPicker(func() consistence.Picker { return picker })
+
+	g, err := geecache.NewRegistry().NewGroup("bounded-release", geecache.GetterFunc(func(key string) ([]byte, error) {
+		return []byte("local"), nil
+	}))
+	if err != nil {
+		t.Fatal(err)
+	}
+	g.RegisterServer(pool)
+
+	var keys []string
+	for i := 0; i < 20; i++ {
+		key := "key-" + strconv.Itoa(i)
+		keys = append(keys, key)
+		g.Set(key, []byte("v"))
+		g.Delete(key)
+		g.GetCacheValue(key)
+	}
+	for _, key := range keys {
+		g.Delete(key)
+	}
+	g.GetMany(keys)
+
+	if n := picker.outstanding.Load(); n != 0 {
+		t.Fatalf("%d picks never released", n)
+	}
+}
+
+// 查找表大小不是质数时向上取质数, 否则填充查找表时会死循环
+func TestMaglevTableSize(t *testing.T) {
+	nodes := placementNodes(4)
+	for _, size := range []int{1, 2, 100, 1000} {
+		picker := consistence.NewMaglev(size, nil)
+		picker.AddNode(nodes...)
+		if node := picker.GetNode("Tom"); node == "" {
+			t.Fatalf("size %d: no node for Tom", size)
+		}
+	}
+}
+
+func TestHTTPPoolSetPicker(t *testing.T) {
+	nodes := placementNodes(3)
+	pool := newTestPool(t, nodes[0])
+	pool.Set(nodes...)
+	pool.SetPicker(func() consistence.Picker { return consistence.NewMaglev(0, nil) })
+
+	expect := consistence.NewMaglev(0, nil)
+	expect.AddNode(nodes...)
+	for i := 0; i < 100; i++ {
+		key := "key-" + strconv.Itoa(i)
+		_, remote := pool.PickNodeClient(key)
+		if remote != (expect.GetNode(key) != nodes[0]) {
+			t.Fatalf("%s picked by wrong algorithm", key)
+		}
+	}
+}
+
+// 节点来自map遍历, 添加顺序随机, 相同的节点集合应当得到相同的所有者
+func TestHTTPPoolJumpDeterministic(t *testing.T) {
+	nodes := placementNodes(5)
+	pools := make([]*geecache.HTTPPool, 2)
+	for i := range pools {
+		pools[i] = newTestPool(t, nodes[0])
+		pools[i].SetPicker(func() consistence.Picker { return consistence.NewJump(nil) })
+		pools[i].Set(nodes...)
+	}
+	for i := 0; i < 1000; i++ {
+		key := "key-" + strconv.Itoa(i)
+		if a, b := pools[0].Ring(key, 0).Owner, pools[1].Ring(key, 0).Owner; a != b {
+			t.Fatalf("%s owned by %s and %s", key, a, b)
+		}
+	}
+}
+
+func TestRingAdmin(t *testing.T) {
+	nodes := placementNodes(3)
+	pool := newTestPool(t, nodes[0])
+	pool.Set(nodes...)
+
+	w := httptest.NewRecorder()
+	pool.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/_geecache_admin/ring?key=Tom&replicas=2", nil))
+	var info geecache.RingInfo
+	if err := json.NewDecoder(w.Body).Decode(&info); err != nil {
+		t.Fatal(err)
+	}
+
+	ring := consistence.NewMap(50, nil)
+	ring.AddNode(nodes...)
+	if info.Owner != ring.GetNode("Tom") || len(info.Replicas) != 2 || info.Replicas[0] != info.Owner {
+		t.Fatalf("unexpected ownership %+v", info)
+	}
+	var total float64
+	for _, share := range info.Shares {
+		total += share
+	}
+	if math.Abs(total-100) > 1e-6 || len(info.Members) != 3 {
+		t.Fatalf("unexpected ring info %+v", info)
+	}
+
+	version := info.Version
+	pool.AddPeer("http://10.0.0.9:8001")
+	if pool.Ring("", 0).Version != version+1 {
+		t.Fatalf("version should increase on membership change")
+	}
+}
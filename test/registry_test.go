@@ -0,0 +1,51 @@
+package test
+
+import (
+	"geecache"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"testing"
+)
+
+func TestIsolatedRegistries(t *testing.T) {
+	servers := make([]*httptest.Server, 2)
+	registries := make([]*geecache.Registry, 2)
+	for i, value := range []string{"cluster-a", "cluster-b"} {
+		value := value
+		registries[i] = geecache.NewRegistry()
+		if _, err := registries[i].NewGroup("scores", geecache.GetterFunc(func(key string) ([]byte, error) {
+			return []byte(value), nil
+		}), geecache.WithCapacity(2<<10)); err != nil {
+			t.Fatal(err)
+		}
+		servers[i] = httptest.NewServer(newTestPool(t, "http://localhost:940"+strconv.Itoa(i), geecache.WithRegistry(registries[i])))
+		defer servers[i].Close()
+	}
+
+	for i, want := range []string{"cluster-a", "cluster-b"} {
+		res, err := http.Get(servers[i].URL + "/_geecache/scores/Tom")
+		if err != nil {
+			t.Fatal(err)
+		}
+		body, _ := io.ReadAll(res.Body)
+		res.Body.Close()
+		if string(body) != want {
+			t.Errorf("registry %d served %q, want %q", i, body, want)
+		}
+	}
+
+	if geecache.GetCacheGroup("scores") == registries[0].Group("scores") {
+		t.Error("registry group leaked into the default registry")
+	}
+
+	g := registries[0].Group("scores")
+	if groups := registries[0].Groups(); len(groups) != 1 || groups[0] != g {
+		t.Errorf("Groups() = %v, want only scores", groups)
+	}
+	g.Close()
+	if registries[0].Group("scores") != nil || registries[1].Group("scores") == nil {
+		t.Error("Close should only unregister from its own registry")
+	}
+}
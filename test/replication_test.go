@@ -0,0 +1,157 @@
+package test
+
+import (
+	"fmt"
+	"geecache"
+	"geecache/consistence"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"testing"
+)
+
+func TestGetNodes(t *testing.T) {
+	hash := consistence.NewMap(1, labelHash)
+	// 2, 4, 6
+	hash.AddNode("2", "4", "6")
+
+	if nodes := hash.GetNodes("3", 2); !reflect.DeepEqual(nodes, []string{"4", "6"}) {
+		t.Fatalf("GetNodes(3, 2) = %v", nodes)
+	}
+	if nodes := hash.GetNodes("5", 5); !reflect.DeepEqual(nodes, []string{"6", "2", "4"}) {
+		t.Fatalf("GetNodes(5, 5) = %v", nodes)
+	}
+}
+
+// 模拟远程节点
+type fakeClient struct {
+	name   string
+	down   bool
+	values map[string][]byte
+	gets   int
+}
+
+func (c *fakeClient) GetCacheValue(group string, key string) ([]byte, error) {
+	c.gets++
+	if c.down {
+		return nil, fmt.Errorf("%s is down", c.name)
+	}
+	if v, ok := c.values[key]; ok {
+		return v, nil
+	}
+	return nil, fmt.Errorf("%s: %s not found", c.name, key)
+}
+
+func (c *fakeClient) SetCacheValue(group string, key string, value []byte) error {
+	if c.down {
+		return fmt.Errorf("%s is down", c.name)
+	}
+	c.values[key] = value
+	return nil
+}
+
+type fakeReplicaServer struct {
+	clients []geecache.NodeClient
+}
+
+func (s *fakeReplicaServer) PickNodeClient(key string) (geecache.NodeClient, bool) {
+	return s.clients[0], s.clients[0] != nil
+}
+
+func (s *fakeReplicaServer) PickReplicaClients(key string, n int) []geecache.NodeClient {
+	if n > len(s.clients) {
+		n = len(s.clients)
+	}
+	return s.clients[:n]
+}
+
+func TestReplicaReadFallback(t *testing.T) {
+	primary := &fakeClient{name: "primary", down: true, values: map[string][]byte{}}
+	replica := &fakeClient{name: "replica", values: map[string][]byte{"Tom": []byte("630")}}
+
+	var sourceLoads int
+	removeOnCleanup(t, "replication-read")
+	g, err := geecache.NewGroup("replication-read", geecache.GetterFunc(func(key string) ([]byte, error) {
+		sourceLoads++
+		return []byte("source"), nil
+	}), geecache.WithCapacity(2<<10), geecache.WithReplication(3, false))
+	if err != nil {
+		t.Fatal(err)
+	}
+	g.RegisterServer(&fakeReplicaServer{clients: []geecache.NodeClient{primary, replica, nil}})
+
+	if v, err := g.GetCacheValue("Tom"); err != nil || v.String() != "630" {
+		t.Fatalf("expect value from replica, got %v %v", v, err)
+	}
+	if primary.gets != 1 || replica.gets != 1 || sourceLoads != 0 {
+		t.Fatalf("primary=%d replica=%d source=%d", primary.gets, replica.gets, sourceLoads)
+	}
+
+	// 所有远程副本都失败时, 轮到自己从数据源加载
+	if v, err := g.GetCacheValue("Jack"); err != nil || v.String() != "source" || sourceLoads != 1 {
+		t.Fatalf("expect value from source, got %v %v", v, err)
+	}
+}
+
+func TestReplicaWriteThrough(t *testing.T) {
+	primary := &fakeClient{name: "primary", values: map[string][]byte{}}
+	replica := &fakeClient{name: "replica", values: map[string][]byte{}}
+
+	newGroup := func(name string, writeThrough bool) *geecache.CacheGroup {
+		removeOnCleanup(t, name)
+		g, err := geecache.NewGroup(name, geecache.GetterFunc(func(key string) ([]byte, error) {
+			return nil, fmt.Errorf("%s not exist", key)
+		}), geecache.WithCapacity(2<<10), geecache.WithReplication(2, writeThrough))
+		if err != nil {
+			t.Fatal(err)
+		}
+		g.RegisterServer(&fakeReplicaServer{clients: []geecache.NodeClient{primary, replica}})
+		return g
+	}
+
+	newGroup("replication-write", false).Set("Tom", []byte("630"))
+	if _, ok := replica.values["Tom"]; ok || string(primary.values["Tom"]) != "630" {
+		t.Fatalf("without write-through only the primary should be written")
+	}
+
+	newGroup("replication-write-through", true).Set("Sam", []byte("567"))
+	if string(primary.values["Sam"]) != "567" || string(replica.values["Sam"]) != "567" {
+		t.Fatalf("write-through should write all replicas")
+	}
+}
+
+// 其他节点写入的值超过大小上限时返回413, 不会整个读入内存
+func TestPeerSetTooLarge(t *testing.T) {
+	removeOnCleanup(t, "replication-set-large")
+	g, err := geecache.NewGroup("replication-set-large", echoGetter(), geecache.WithCapacity(2<<10))
+	if err != nil {
+		t.Fatal(err)
+	}
+	pool := newTestPool(t, "http://localhost:9406")
+
+	put := func(size int64) int {
+		body := io.LimitReader(zeroReader{}, size)
+		w := httptest.NewRecorder()
+		pool.ServeHTTP(w, httptest.NewRequest(http.MethodPut, "/_geecache/replication-set-large/key", body))
+		return w.Code
+	}
+	if code := put(64 << 20); code != http.StatusRequestEntityTooLarge {
+		t.Fatalf("oversized set returned %d, want 413", code)
+	}
+	if stats := g.CacheStats(); stats.Entries != 0 {
+		t.Fatalf("oversized value was cached, %d entries", stats.Entries)
+	}
+	if code := put(1 << 10); code != http.StatusNoContent {
+		t.Fatalf("set returned %d, want 204", code)
+	}
+}
+
+type zeroReader struct{}
+
+func (zeroReader) Read(p []byte) (int, error) {
+	for i := range p {
+		p[i] = 0
+	}
+	return len(p), nil
+}
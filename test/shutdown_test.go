@@ -0,0 +1,195 @@
+package test
+
+import (
+	"context"
+	"errors"
+	"geecache"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestShutdownAnnounceLeave(t *testing.T) {
+	var a, b *geecache.HTTPPool
+	serverA := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) { a.ServeHTTP(w, r) }))
+	defer serverA.Close()
+	serverB := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) { b.ServeHTTP(w, r) }))
+	defer serverB.Close()
+
+	a = newTestPool(t, serverA.URL)
+	b = newTestPool(t, serverB.URL)
+	a.Set(serverA.URL, serverB.URL)
+	b.Set(serverA.URL, serverB.URL)
+
+	if err := a.Shutdown(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	if !a.Draining() {
+		t.Fatalf("pool should be draining after shutdown")
+	}
+	if peers := b.Peers(); !reflect.DeepEqual(peers, []string{serverB.URL}) {
+		t.Fatalf("peer should remove the leaving node, got %v", peers)
+	}
+
+	res, err := http.Get(serverA.URL + "/health")
+	if err != nil {
+		t.Fatal(err)
+	}
+	res.Body.Close()
+	if res.StatusCode != http.StatusServiceUnavailable {
+		t.Fatalf("health returned %d while draining", res.StatusCode)
+	}
+
+	res, err = http.Get(serverA.URL + "/_geecache/scores/Tom")
+	if err != nil {
+		t.Fatal(err)
+	}
+	res.Body.Close()
+	if res.StatusCode != http.StatusServiceUnavailable {
+		t.Fatalf("peer request returned %d while draining", res.StatusCode)
+	}
+
+	// 重复调用直接返回
+	if err := a.Shutdown(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+}
+
+// 退出通知只接受离开的节点自己发出的请求
+func TestLeaveRequiresPeerOrigin(t *testing.T) {
+	self, peer := "http://localhost:9404", "http://127.0.0.1:9405"
+	pool := newTestPool(t, self)
+	pool.Set(self, peer)
+
+	leave := func(remoteAddr string) int {
+		r := httptest.NewRequest(http.MethodPost, "/_geecache_admin/leave?peer="+peer, nil)
+		r.RemoteAddr = remoteAddr
+		w := httptest.NewRecorder()
+		pool.ServeHTTP(w, r)
+		return w.Code
+	}
+
+	if code := leave("192.0.2.1:40000"); code != http.StatusForbidden {
+		t.Fatalf("leave from another host returned %d, want 403", code)
+	}
+	if peers := pool.Peers(); len(peers) != 2 {
+		t.Fatalf("forged leave removed a peer, got %v", peers)
+	}
+	if code := leave("127.0.0.1:40000"); code != http.StatusNoContent {
+		t.Fatalf("leave from the peer returned %d, want 204", code)
+	}
+	if peers := pool.Peers(); !reflect.DeepEqual(peers, []string{self}) {
+		t.Fatalf("peer should be removed after leaving, got %v", peers)
+	}
+}
+
+// 开启健康检查时, 退出的节点只被剔除, 重启后通过探测重新加入哈希环
+func TestShutdownRestartRejoins(t *testing.T) {
+	var mu sync.RWMutex
+	var a, b *geecache.HTTPPool
+	serverA := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mu.RLock()
+		pool := a
+		mu.RUnlock()
+		pool.ServeHTTP(w, r)
+	}))
+	defer serverA.Close()
+	serverB := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) { b.ServeHTTP(w, r) }))
+	defer serverB.Close()
+
+	a = newTestPool(t, serverA.URL)
+	b = newTestPool(t, serverB.URL)
+	a.Set(serverA.URL, serverB.URL)
+	b.Set(serverA.URL, serverB.URL)
+
+	conf := geecache.DefaultHealthConfig()
+	conf.Interval = 20 * time.Millisecond
+	b.StartHealthCheck(conf)
+	defer b.StopHealthCheck()
+
+	if err := a.Shutdown(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	if healthy := b.HealthyPeers(); !reflect.DeepEqual(healthy, []string{serverB.URL}) {
+		t.Fatalf("leaving node should be ejected, healthy peers %v", healthy)
+	}
+	if peers := b.Peers(); len(peers) != 2 {
+		t.Fatalf("leaving node should stay a member, got %v", peers)
+	}
+
+	// 在同一地址重启
+	restarted := newTestPool(t, serverA.URL)
+	restarted.Set(serverA.URL, serverB.URL)
+	mu.Lock()
+	a = restarted
+	mu.Unlock()
+	waitHealthyPeers(t, b, b.Peers())
+}
+
+func TestShutdownWaitsInflight(t *testing.T) {
+	registry := geecache.NewRegistry()
+	entered, release := make(chan struct{}), make(chan struct{})
+	_, err := registry.NewGroup("shutdown-inflight", geecache.GetterFunc(func(key string) ([]byte, error) {
+		close(entered)
+		<-release
+		return []byte(key), nil
+	}))
+	if err != nil {
+		t.Fatal(err)
+	}
+	pool := newTestPool(t, "http://localhost:8001", geecache.WithRegistry(registry))
+
+	served := make(chan int)
+	go func() {
+		w := httptest.NewRecorder()
+		pool.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/_geecache/shutdown-inflight/Tom", nil))
+		served <- w.Code
+	}()
+	<-entered
+
+	// 请求未完成时超时
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	if err := pool.Shutdown(ctx); !errors.Is(err, context.DeadlineExceeded) {
+		t.Fatalf("expect deadline exceeded, got %v", err)
+	}
+
+	// 正在处理的请求不受影响
+	close(release)
+	if code := <-served; code != http.StatusOK {
+		t.Fatalf("in-flight request returned %d", code)
+	}
+}
+
+func TestShutdownDrains(t *testing.T) {
+	registry := geecache.NewRegistry()
+	entered, release := make(chan struct{}), make(chan struct{})
+	_, err := registry.NewGroup("shutdown-drain", geecache.GetterFunc(func(key string) ([]byte, error) {
+		close(entered)
+		<-release
+		return []byte(key), nil
+	}))
+	if err != nil {
+		t.Fatal(err)
+	}
+	pool := newTestPool(t, "http://localhost:8001", geecache.WithRegistry(registry))
+
+	go pool.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, "/_geecache/shutdown-drain/Tom", nil))
+	<-entered
+
+	done := make(chan error)
+	go func() { done <- pool.Shutdown(context.Background()) }()
+	select {
+	case err := <-done:
+		t.Fatalf("shutdown returned before the in-flight request finished: %v", err)
+	case <-time.After(50 * time.Millisecond):
+	}
+
+	close(release)
+	if err := <-done; err != nil {
+		t.Fatal(err)
+	}
+}
@@ -0,0 +1,165 @@
+package test
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"geecache"
+	"io/fs"
+	"os"
+	"testing"
+	"time"
+)
+
+func newSnapshotGroup(t *testing.T, registry *geecache.Registry, name string, opts ...geecache.GroupOption) (*geecache.CacheGroup, *int) {
+	loads := new(int)
+	g, err := registry.NewGroup(name, geecache.GetterFunc(func(key string) ([]byte, error) {
+		*loads++
+		return nil, fmt.Errorf("%s not exist", key)
+	}), opts...)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return g, loads
+}
+
+func TestSnapshotRestore(t *testing.T) {
+	for _, mode := range []geecache.StorageMode{geecache.StorageLRU, geecache.StorageArena} {
+		t.Run(fmt.Sprint(mode), func(t *testing.T) {
+			src, _ := newSnapshotGroup(t, geecache.NewRegistry(), "snapshot",
+				geecache.WithStorage(mode, 0), geecache.WithTTL(time.Hour),
+				geecache.WithCompression(geecache.GzipCompressor, 16))
+			big := bytes.Repeat([]byte("geecache"), 64)
+			src.Set("Tom", []byte("630"))
+			src.Set("big", big)
+
+			var buf bytes.Buffer
+			if err := src.Snapshot(&buf); err != nil {
+				t.Fatal(err)
+			}
+
+			// 恢复到不压缩的group, 快照中保存的是原始数据
+			dst, loads := newSnapshotGroup(t, geecache.NewRegistry(), "snapshot", geecache.WithStorage(mode, 0))
+			if err := dst.Restore(&buf); err != nil {
+				t.Fatal(err)
+			}
+			if v, err := dst.GetCacheValue("Tom"); err != nil || v.String() != "630" {
+				t.Fatalf("restored Tom = %v %v", v, err)
+			}
+			if v, err := dst.GetCacheValue("big"); err != nil || !v.EqualBytes(big) {
+				t.Fatalf("restored big value mismatch: %v", err)
+			}
+			if *loads != 0 {
+				t.Fatalf("restored values should not hit the source, loads = %d", *loads)
+			}
+		})
+	}
+}
+
+func TestSnapshotKeepsTTL(t *testing.T) {
+	src, _ := newSnapshotGroup(t, geecache.NewRegistry(), "snapshot-ttl", geecache.WithTTL(time.Second))
+	src.Set("Tom", []byte("630"))
+
+	var buf bytes.Buffer
+	if err := src.Snapshot(&buf); err != nil {
+		t.Fatal(err)
+	}
+	snapshot := buf.Bytes()
+
+	// 未过期时按剩余时间恢复, 不使用目标group的ttl
+	dst, _ := newSnapshotGroup(t, geecache.NewRegistry(), "snapshot-ttl", geecache.WithTTL(time.Hour))
+	if err := dst.Restore(bytes.NewReader(snapshot)); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := dst.GetCacheValue("Tom"); err != nil {
+		t.Fatal(err)
+	}
+	time.Sleep(2100 * time.Millisecond)
+	if _, err := dst.GetCacheValue("Tom"); err == nil {
+		t.Fatalf("restored value should keep its remaining ttl")
+	}
+
+	// 快照之后已过期的值不会被恢复
+	late, _ := newSnapshotGroup(t, geecache.NewRegistry(), "snapshot-ttl")
+	if err := late.Restore(bytes.NewReader(snapshot)); err != nil {
+		t.Fatal(err)
+	}
+	if stats := late.CacheStats(); stats.Entries != 0 {
+		t.Fatalf("expired values restored: %d", stats.Entries)
+	}
+}
+
+func TestSnapshotCorrupt(t *testing.T) {
+	src, _ := newSnapshotGroup(t, geecache.NewRegistry(), "snapshot-corrupt")
+	src.Set("Tom", []byte("630"))
+	src.Set("Jack", []byte("589"))
+	var buf bytes.Buffer
+	if err := src.Snapshot(&buf); err != nil {
+		t.Fatal(err)
+	}
+	snapshot := buf.Bytes()
+
+	flipped := append([]byte(nil), snapshot...)
+	flipped[len(flipped)-8] ^= 0xff
+	cases := map[string][]byte{
+		"flipped":   flipped,
+		"truncated": snapshot[:len(snapshot)-3],
+		"empty":     nil,
+	}
+	for name, data := range cases {
+		dst, _ := newSnapshotGroup(t, geecache.NewRegistry(), "snapshot-corrupt")
+		if err := dst.Restore(bytes.NewReader(data)); !errors.Is(err, geecache.ErrSnapshotCorrupt) {
+			t.Fatalf("%s: expect ErrSnapshotCorrupt, got %v", name, err)
+		}
+		// 校验失败时不写入任何值
+		if stats := dst.CacheStats(); stats.Entries != 0 {
+			t.Fatalf("%s: corrupt snapshot partially restored %d entries", name, stats.Entries)
+		}
+	}
+
+	other, _ := newSnapshotGroup(t, geecache.NewRegistry(), "other")
+	if err := other.Restore(bytes.NewReader(snapshot)); err == nil {
+		t.Fatalf("snapshot of another group should be rejected")
+	}
+}
+
+func TestSnapshotFile(t *testing.T) {
+	dir := t.TempDir()
+	registry := geecache.NewRegistry()
+
+	g, _ := newSnapshotGroup(t, registry, "snapshot/file", geecache.WithSnapshotDir(dir, 20*time.Millisecond))
+	if err := g.RestoreFile(dir); !errors.Is(err, fs.ErrNotExist) {
+		t.Fatalf("expect not exist before the first snapshot, got %v", err)
+	}
+
+	g.Set("Tom", []byte("630"))
+	path := geecache.SnapshotPath(dir, "snapshot/file")
+	deadline := time.Now().Add(time.Second)
+	for {
+		if _, err := os.Stat(path); err == nil {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("periodic snapshot not written to %s", path)
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	// 关闭时写入最后一次快照
+	g.Set("Jack", []byte("589"))
+	g.Close()
+
+	restored, _ := newSnapshotGroup(t, registry, "snapshot/file")
+	if err := restored.RestoreFile(dir); err != nil {
+		t.Fatal(err)
+	}
+	for key, expect := range map[string]string{"Tom": "630", "Jack": "589"} {
+		if v, err := restored.GetCacheValue(key); err != nil || v.String() != expect {
+			t.Fatalf("%s = %v %v", key, v, err)
+		}
+	}
+
+	if err := g.Snapshot(&bytes.Buffer{}); !errors.Is(err, geecache.ErrGroupClosed) {
+		t.Fatalf("snapshot of closed group returned %v", err)
+	}
+}
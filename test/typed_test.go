@@ -0,0 +1,82 @@
+package test
+
+import (
+	"errors"
+	"fmt"
+	"geecache"
+	"testing"
+)
+
+type user struct {
+	Name string
+	Age  int
+}
+
+func TestTypedGroup(t *testing.T) {
+	for _, codec := range []geecache.Codec{geecache.JSONCodec, geecache.GobCodec} {
+		loads := 0
+		registry := geecache.NewRegistry()
+		g, err := geecache.NewTypedGroupIn[user](registry, "typed-"+codec.Name(), codec,
+			geecache.TypedGetterFunc[user](func(key string) (user, error) {
+				loads++
+				if key == "missing" {
+					return user{}, fmt.Errorf("%s not exist", key)
+				}
+				return user{Name: key, Age: len(key)}, nil
+			}), geecache.WithCapacity(2<<10))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if registry.Group("typed-"+codec.Name()) != g.Group() || geecache.GetCacheGroup("typed-"+codec.Name()) != nil {
+			t.Fatalf("%s: typed group should only be registered in its own registry", codec.Name())
+		}
+
+		for i := 0; i < 2; i++ {
+			u, err := g.Get("Tom")
+			if err != nil || u != (user{Name: "Tom", Age: 3}) {
+				t.Fatalf("%s: get Tom = %+v, %v", codec.Name(), u, err)
+			}
+		}
+		if loads != 1 {
+			t.Errorf("%s: loaded %d times, want 1", codec.Name(), loads)
+		}
+
+		if err := g.Set("Jack", user{Name: "Jack", Age: 30}); err != nil {
+			t.Fatal(err)
+		}
+		if u, err := g.Get("Jack"); err != nil || u.Age != 30 {
+			t.Errorf("%s: get Jack = %+v, %v", codec.Name(), u, err)
+		}
+		if _, err := g.Get("missing"); err == nil {
+			t.Errorf("%s: missing key should return error", codec.Name())
+		}
+	}
+}
+
+func TestTypedGroupCodecMismatch(t *testing.T) {
+	registry := geecache.NewRegistry()
+	g, err := geecache.NewTypedGroupIn[user](registry, "typed-mismatch", geecache.JSONCodec,
+		geecache.TypedGetterFunc[user](func(key string) (user, error) {
+			return user{Name: key}, nil
+		}), geecache.WithCapacity(2<<10))
+	if err != nil {
+		t.Fatal(err)
+	}
+	g.Group().Set("raw", []byte(`{"Name":"raw"}`))
+
+	if _, err := g.Get("raw"); !errors.Is(err, geecache.ErrCodecMismatch) {
+		t.Errorf("get raw value err = %v, want ErrCodecMismatch", err)
+	}
+
+	gob, err := geecache.NewTypedGroupIn[user](registry, "typed-mismatch-gob", geecache.GobCodec,
+		geecache.TypedGetterFunc[user](func(key string) (user, error) {
+			return user{Name: key}, nil
+		}), geecache.WithCapacity(2<<10))
+	if err != nil {
+		t.Fatal(err)
+	}
+	gob.Group().Set("Tom", []byte("\x04json{\"Name\":\"Tom\"}"))
+	if _, err := gob.Get("Tom"); !errors.Is(err, geecache.ErrCodecMismatch) {
+		t.Errorf("get json value with gob codec err = %v, want ErrCodecMismatch", err)
+	}
+}
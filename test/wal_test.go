@@ -0,0 +1,291 @@
+package test
+
+import (
+	"errors"
+	"fmt"
+	"geecache"
+	"geecache/wal"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+func replayAll(t *testing.T, l *wal.Log) []wal.Record {
+	var records []wal.Record
+	if err := l.Replay(func(rec wal.Record) error {
+		records = append(records, rec)
+		return nil
+	}); err != nil {
+		t.Fatal(err)
+	}
+	return records
+}
+
+func segmentFiles(t *testing.T, dir string) []string {
+	files, err := filepath.Glob(filepath.Join(dir, "*.wal"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return files
+}
+
+func TestWALAppendReplay(t *testing.T) {
+	conf := wal.DefaultConfig(t.TempDir())
+	conf.SegmentSize = 64
+	conf.Sync = wal.SyncAlways
+	l, err := wal.Open(conf)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var expect []wal.Record
+	for i := 0; i < 10; i++ {
+		rec := wal.Record{Op: wal.OpSet, Key: fmt.Sprintf("key%d", i), Value: []byte("value-value-value"), ExpireAt: int64(i)}
+		if i%3 == 0 {
+			rec = wal.Record{Op: wal.OpDelete, Key: rec.Key}
+		}
+		if err := l.Append(rec); err != nil {
+			t.Fatal(err)
+		}
+		expect = append(expect, rec)
+	}
+	if l.Segments() < 2 {
+		t.Fatalf("expect rotation by segment size, got %d segments", l.Segments())
+	}
+	l.Close()
+	if err := l.Append(expect[0]); !errors.Is(err, wal.ErrClosed) {
+		t.Fatalf("append after close returned %v", err)
+	}
+
+	l, err = wal.Open(conf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer l.Close()
+	if records := replayAll(t, l); !reflect.DeepEqual(records, expect) {
+		t.Fatalf("replayed %v, expect %v", records, expect)
+	}
+}
+
+func TestWALTornTail(t *testing.T) {
+	conf := wal.DefaultConfig(t.TempDir())
+	conf.Sync = wal.SyncNever
+	l, err := wal.Open(conf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	l.Append(wal.Record{Op: wal.OpSet, Key: "Tom", Value: []byte("630")})
+	l.Append(wal.Record{Op: wal.OpSet, Key: "Jack", Value: []byte("589")})
+	l.Close()
+
+	// 模拟崩溃时写了一半的记录
+	path := segmentFiles(t, conf.Dir)[0]
+	info, _ := os.Stat(path)
+	if err := os.Truncate(path, info.Size()-2); err != nil {
+		t.Fatal(err)
+	}
+
+	l, err = wal.Open(conf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	l.Append(wal.Record{Op: wal.OpDelete, Key: "Tom"})
+	l.Close()
+
+	l, err = wal.Open(conf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer l.Close()
+	expect := []wal.Record{
+		{Op: wal.OpSet, Key: "Tom", Value: []byte("630")},
+		{Op: wal.OpDelete, Key: "Tom"},
+	}
+	if records := replayAll(t, l); !reflect.DeepEqual(records, expect) {
+		t.Fatalf("replayed %v, expect %v", records, expect)
+	}
+}
+
+func TestWALCorruptSegment(t *testing.T) {
+	conf := wal.DefaultConfig(t.TempDir())
+	l, err := wal.Open(conf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	l.Append(wal.Record{Op: wal.OpSet, Key: "Tom", Value: []byte("630")})
+	if _, err := l.Rotate(); err != nil {
+		t.Fatal(err)
+	}
+	l.Append(wal.Record{Op: wal.OpSet, Key: "Jack", Value: []byte("589")})
+	l.Close()
+
+	// 非最后一个段的损坏不是崩溃造成的, 不能截断
+	path := segmentFiles(t, conf.Dir)[0]
+	b, _ := os.ReadFile(path)
+	b[len(b)-1] ^= 0xff
+	os.WriteFile(path, b, 0o644)
+
+	l, err = wal.Open(conf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer l.Close()
+	if err := l.Replay(func(wal.Record) error { return nil }); !errors.Is(err, wal.ErrCorrupt) {
+		t.Fatalf("expect ErrCorrupt, got %v", err)
+	}
+}
+
+func TestWALCompact(t *testing.T) {
+	conf := wal.DefaultConfig(t.TempDir())
+	l, err := wal.Open(conf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer l.Close()
+
+	l.Append(wal.Record{Op: wal.OpSet, Key: "Tom", Value: []byte("630")})
+	seg, err := l.Rotate()
+	if err != nil {
+		t.Fatal(err)
+	}
+	l.Append(wal.Record{Op: wal.OpSet, Key: "Jack", Value: []byte("589")})
+	if err := l.Compact(seg); err != nil {
+		t.Fatal(err)
+	}
+	if files := segmentFiles(t, conf.Dir); len(files) != 1 {
+		t.Fatalf("expect 1 segment after compaction, got %v", files)
+	}
+	if records := replayAll(t, l); len(records) != 1 || records[0].Key != "Jack" {
+		t.Fatalf("replayed %v after compaction", records)
+	}
+}
+
+// 新段无法打开时拒绝之后的写入, 段列表中不包含新段
+func TestWALRotateFailure(t *testing.T) {
+	conf := wal.DefaultConfig(t.TempDir())
+	conf.Sync = wal.SyncNever
+	l, err := wal.Open(conf)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	l.Append(wal.Record{Op: wal.OpSet, Key: "Tom", Value: []byte("630")})
+	// 同名目录使新段无法创建
+	if err := os.Mkdir(filepath.Join(conf.Dir, fmt.Sprintf("%016x.wal", 2)), 0o755); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := l.Rotate(); err == nil {
+		t.Fatal("rotate succeeded without a writable segment")
+	}
+	if err := l.Append(wal.Record{Op: wal.OpSet, Key: "Jack", Value: []byte("589")}); err == nil {
+		t.Fatal("append succeeded after failed rotation")
+	}
+	if l.Segments() != 1 {
+		t.Fatalf("expect 1 segment after failed rotation, got %d", l.Segments())
+	}
+	if records := replayAll(t, l); len(records) != 1 || records[0].Key != "Tom" {
+		t.Fatalf("replayed %v after failed rotation", records)
+	}
+	if err := l.Compact(2); err != nil {
+		t.Fatal(err)
+	}
+	if err := l.Close(); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestParseSyncPolicy(t *testing.T) {
+	for _, p := range []wal.SyncPolicy{wal.SyncAlways, wal.SyncEverySecond, wal.SyncNever} {
+		if got, err := wal.ParseSyncPolicy(p.String()); err != nil || got != p {
+			t.Fatalf("ParseSyncPolicy(%s) = %v %v", p, got, err)
+		}
+	}
+	if _, err := wal.ParseSyncPolicy("sometimes"); err == nil {
+		t.Fatalf("expect error for unknown policy")
+	}
+}
+
+func newWALGroup(t *testing.T, snapshotDir, walDir string) *geecache.CacheGroup {
+	conf := wal.DefaultConfig(walDir)
+	conf.Sync = wal.SyncNever
+	g, err := geecache.NewRegistry().NewGroup("wal", geecache.GetterFunc(func(key string) ([]byte, error) {
+		return nil, fmt.Errorf("%s not exist", key)
+	}), geecache.WithSnapshotDir(snapshotDir, 0), geecache.WithWAL(conf))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := g.Recover(); err != nil {
+		t.Fatal(err)
+	}
+	return g
+}
+
+func TestGroupWALRecover(t *testing.T) {
+	snapshotDir, walDir := t.TempDir(), t.TempDir()
+
+	g := newWALGroup(t, snapshotDir, walDir)
+	g.Set("Tom", []byte("630"))
+	g.Set("Jack", []byte("589"))
+	// 正常关闭: 写入快照并删除快照之前的日志
+	g.Close()
+	if files := segmentFiles(t, walDir); len(files) != 1 {
+		t.Fatalf("expect wal compacted after snapshot, got %v", files)
+	}
+
+	g = newWALGroup(t, snapshotDir, walDir)
+	g.Set("Sam", []byte("567"))
+	g.Set("Tom", []byte("700"))
+	if err := g.Delete("Jack"); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := g.GetCacheValue("Jack"); err == nil {
+		t.Fatalf("deleted key still cached")
+	}
+	// 模拟崩溃: 不关闭group, 快照中只有Tom和Jack, 之后的写入只在日志中
+
+	recovered := newWALGroup(t, snapshotDir, walDir)
+	defer recovered.Close()
+	for key, expect := range map[string]string{"Tom": "700", "Sam": "567"} {
+		if v, err := recovered.GetCacheValue(key); err != nil || v.String() != expect {
+			t.Fatalf("%s = %v %v", key, v, err)
+		}
+	}
+	if _, err := recovered.GetCacheValue("Jack"); err == nil {
+		t.Fatalf("delete was not replayed")
+	}
+}
+
+func (c *fakeClient) DeleteCacheValue(group string, key string) error {
+	if c.down {
+		return fmt.Errorf("%s is down", c.name)
+	}
+	delete(c.values, key)
+	return nil
+}
+
+func TestDeleteReplicas(t *testing.T) {
+	primary := &fakeClient{name: "primary", values: map[string][]byte{"Tom": []byte("630")}}
+	replica := &fakeClient{name: "replica", values: map[string][]byte{"Tom": []byte("630")}}
+
+	g, err := geecache.NewRegistry().NewGroup("delete-replicas", geecache.GetterFunc(func(key string) ([]byte, error) {
+		return nil, fmt.Errorf("%s not exist", key)
+	}), geecache.WithReplication(3, false))
+	if err != nil {
+		t.Fatal(err)
+	}
+	g.RegisterServer(&fakeReplicaServer{clients: []geecache.NodeClient{primary, replica, nil}})
+
+	// 即使没有开启writeThrough, 删除也要作用于所有副本
+	if err := g.Delete("Tom"); err != nil {
+		t.Fatal(err)
+	}
+	if len(primary.values) != 0 || len(replica.values) != 0 {
+		t.Fatalf("delete should remove all replicas: %v %v", primary.values, replica.values)
+	}
+
+	replica.down = true
+	if err := g.Delete("Tom"); err == nil {
+		t.Fatalf("expect error when a replica is down")
+	}
+}
@@ -0,0 +1,70 @@
+package geecache
+
+import "fmt"
+
+// 返回类型化值的回调接口
+type TypedGetter[T any] interface {
+	Get(key string) (T, error)
+}
+
+type TypedGetterFunc[T any] func(key string) (T, error)
+
+func (f TypedGetterFunc[T]) Get(key string) (T, error) {
+	return f(key)
+}
+
+// 类型化的缓存命名空间, 值经codec编码后存储在CacheGroup中
+type TypedGroup[T any] struct {
+	group *CacheGroup
+	codec Codec
+}
+
+// 在默认注册表中创建类型化的group, 名称已存在时返回ErrGroupExists
+func NewTypedGroup[T any](name string, codec Codec, getter TypedGetter[T], opts ...GroupOption) (*TypedGroup[T], error) {
+	return NewTypedGroupIn(DefaultRegistry, name, codec, getter, opts...)
+}
+
+// 在注册表r中创建类型化的group, 名称已存在时返回ErrGroupExists
+func NewTypedGroupIn[T any](r *Registry, name string, codec Codec, getter TypedGetter[T], opts ...GroupOption) (*TypedGroup[T], error) {
+	if getter == nil {
+		return nil, fmt.Errorf("nil getter for group %s", name)
+	}
+	if codec == nil {
+		codec = JSONCodec
+	}
+
+	g, err := r.NewGroup(name, GetterFunc(func(key string) ([]byte, error) {
+		v, err := getter.Get(key)
+		if err != nil {
+			return nil, err
+		}
+		return encodeValue(codec, v)
+	}), opts...)
+	if err != nil {
+		return nil, err
+	}
+	return &TypedGroup[T]{group: g, codec: codec}, nil
+}
+
+// 底层的CacheGroup, 用于注册节点服务等
+func (t *TypedGroup[T]) Group() *CacheGroup {
+	return t.group
+}
+
+func (t *TypedGroup[T]) Get(key string) (T, error) {
+	var v T
+	view, err := t.group.GetCacheValue(key)
+	if err != nil {
+		return v, err
+	}
+	err = decodeValue(t.codec, view.raw(), &v)
+	return v, err
+}
+
+func (t *TypedGroup[T]) Set(key string, v T) error {
+	b, err := encodeValue(t.codec, v)
+	if err != nil {
+		return err
+	}
+	return t.group.Set(key, b)
+}
@@ -0,0 +1,471 @@
+package wal
+
+import (
+	"bufio"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"hash/crc32"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// fsync策略
+type SyncPolicy int
+
+const (
+	SyncEverySecond SyncPolicy = iota // 默认, 每秒fsync一次, 机器崩溃时最多丢失1秒的写入
+	SyncAlways                        // 每次写入后fsync
+	SyncNever                         // 不主动fsync, 由操作系统决定
+)
+
+func (p SyncPolicy) String() string {
+	switch p {
+	case SyncEverySecond:
+		return "everysec"
+	case SyncAlways:
+		return "always"
+	case SyncNever:
+		return "never"
+	}
+	return fmt.Sprintf("SyncPolicy(%d)", int(p))
+}
+
+// 解析always、everysec、never
+func ParseSyncPolicy(s string) (SyncPolicy, error) {
+	for _, p := range []SyncPolicy{SyncEverySecond, SyncAlways, SyncNever} {
+		if p.String() == s {
+			return p, nil
+		}
+	}
+	return 0, fmt.Errorf("unknown sync policy %q, expect always, everysec or never", s)
+}
+
+// 操作类型
+type Op uint8
+
+const (
+	OpSet    Op = 1
+	OpDelete Op = 2
+)
+
+// 一条日志记录
+type Record struct {
+	Op       Op
+	Key      string
+	Value    []byte // OpSet写入的值
+	ExpireAt int64  // OpSet写入值的过期时间(unix秒), 为0时不过期
+}
+
+// 配置
+type Config struct {
+	Dir         string     // 日志目录, 一个目录只能被一个Log使用
+	SegmentSize int64      // 单个段文件的大小上限, 超过后写入新的段
+	Sync        SyncPolicy // fsync策略
+}
+
+const defaultSegmentSize = 64 << 20
+
+// 默认配置
+func DefaultConfig(dir string) Config {
+	return Config{
+		Dir:         dir,
+		SegmentSize: defaultSegmentSize,
+		Sync:        SyncEverySecond,
+	}
+}
+
+var (
+	ErrCorrupt = errors.New("wal: corrupt record")
+	ErrClosed  = errors.New("wal: closed")
+)
+
+// 记录格式: 长度(4) + CRC32(4) + 内容
+// 内容:     op(1) + 过期时间 + key长度 + key + value, 整数使用varint编码
+const (
+	headerSize    = 8
+	maxRecordSize = 1 << 30
+	segmentExt    = ".wal"
+)
+
+var crcTable = crc32.MakeTable(crc32.Castagnoli)
+
+// 只追加的预写日志, 由多个按编号递增的段文件组成, 只有最后一个段可以写入
+type Log struct {
+	conf Config
+
+	mu       sync.Mutex
+	segments []uint64 // 段编号, 升序, 最后一个为当前写入的段
+	f        *os.File
+	size     int64 // 当前段的大小
+	dirty    bool  // 是否有未fsync的写入
+	closed   bool
+	failed   error // 写入失败且无法回退时记录的错误, 之后拒绝写入
+
+	stopCh chan struct{}
+	done   chan struct{}
+}
+
+// 打开日志目录, 最后一个段末尾不完整或校验失败的记录(崩溃时写了一半)会被截断
+func Open(conf Config) (*Log, error) {
+	if conf.Dir == "" {
+		return nil, fmt.Errorf("wal: dir is required")
+	}
+	if conf.SegmentSize <= 0 {
+		conf.SegmentSize = defaultSegmentSize
+	}
+	if conf.Sync < SyncEverySecond || conf.Sync > SyncNever {
+		return nil, fmt.Errorf("wal: unknown sync policy %d", conf.Sync)
+	}
+	if err := os.MkdirAll(conf.Dir, 0o755); err != nil {
+		return nil, err
+	}
+
+	segments, err := listSegments(conf.Dir)
+	if err != nil {
+		return nil, err
+	}
+	l := &Log{conf: conf, segments: segments}
+	if len(segments) == 0 {
+		l.segments = []uint64{1}
+	} else if err := l.truncateTail(); err != nil {
+		return nil, err
+	}
+
+	if err := l.openActive(l.segments[len(l.segments)-1]); err != nil {
+		return nil, err
+	}
+	if conf.Sync == SyncEverySecond {
+		l.stopCh = make(chan struct{})
+		l.done = make(chan struct{})
+		go l.syncLoop()
+	}
+	return l, nil
+}
+
+func (l *Log) path(id uint64) string {
+	return filepath.Join(l.conf.Dir, fmt.Sprintf("%016x%s", id, segmentExt))
+}
+
+func listSegments(dir string) ([]uint64, error) {
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		return nil, err
+	}
+	var ids []uint64
+	for _, e := range entries {
+		name := e.Name()
+		if e.IsDir() || !strings.HasSuffix(name, segmentExt) {
+			continue
+		}
+		id, err := strconv.ParseUint(strings.TrimSuffix(name, segmentExt), 16, 64)
+		if err != nil {
+			continue
+		}
+		ids = append(ids, id)
+	}
+	sort.Slice(ids, func(i, j int) bool { return ids[i] < ids[j] })
+	return ids, nil
+}
+
+// 从最后一个段中第一条无效记录处截断
+func (l *Log) truncateTail() error {
+	path := l.path(l.segments[len(l.segments)-1])
+	valid, err := scanSegment(path, nil)
+	if err != nil && !errors.Is(err, ErrCorrupt) {
+		return err
+	}
+	if err == nil {
+		return nil
+	}
+	return os.Truncate(path, valid)
+}
+
+func (l *Log) openActive(id uint64) error {
+	f, err := os.OpenFile(l.path(id), os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0o644)
+	if err != nil {
+		return err
+	}
+	info, err := f.Stat()
+	if err != nil {
+		f.Close()
+		return err
+	}
+	l.f, l.size = f, info.Size()
+	return nil
+}
+
+// 追加一条记录, 当前段写满时先切换到新的段
+func (l *Log) Append(rec Record) error {
+	buf := encode(rec)
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if l.closed {
+		return ErrClosed
+	}
+	if l.failed != nil {
+		return l.failed
+	}
+	if l.size > 0 && l.size+int64(len(buf)) > l.conf.SegmentSize {
+		if err := l.rotate(); err != nil {
+			return err
+		}
+	}
+
+	n, err := l.f.Write(buf)
+	if err != nil {
+		// 写了一半的记录会让之后的记录在打开时被当作损坏的尾部截断, 回退到写入前的大小
+		if n > 0 {
+			if terr := l.f.Truncate(l.size); terr != nil {
+				l.failed = fmt.Errorf("wal: rollback partial write: %w", terr)
+			}
+		}
+		return err
+	}
+	l.size += int64(n)
+	if l.conf.Sync == SyncAlways {
+		return l.f.Sync()
+	}
+	l.dirty = true
+	return nil
+}
+
+// 切换到新的段, 返回新段的编号, 之后的记录都写入新段
+//
+// 写入快照前调用, 快照完成后通过Compact(id)删除之前的段.
+func (l *Log) Rotate() (uint64, error) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if l.closed {
+		return 0, ErrClosed
+	}
+	if l.failed != nil {
+		return 0, l.failed
+	}
+	if err := l.rotate(); err != nil {
+		return 0, err
+	}
+	return l.segments[len(l.segments)-1], nil
+}
+
+// 任何一步失败后当前段都不再可写, 记录错误并拒绝之后的写入;
+// 新段打开成功后才加入段列表, 避免Replay和Compact遇到不存在的段
+func (l *Log) rotate() error {
+	if err := l.f.Sync(); err != nil {
+		l.failed = fmt.Errorf("wal: sync segment before rotate: %w", err)
+		return l.failed
+	}
+	err := l.f.Close()
+	l.f, l.dirty = nil, false
+	if err != nil {
+		l.failed = fmt.Errorf("wal: close segment before rotate: %w", err)
+		return l.failed
+	}
+
+	next := l.segments[len(l.segments)-1] + 1
+	if err := l.openActive(next); err != nil {
+		l.failed = fmt.Errorf("wal: open segment %d: %w", next, err)
+		return l.failed
+	}
+	l.segments = append(l.segments, next)
+	return nil
+}
+
+// 删除编号小于before的段, 当前写入的段不会被删除
+func (l *Log) Compact(before uint64) error {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	active := l.segments[len(l.segments)-1]
+	kept := l.segments[:0]
+	var firstErr error
+	for _, id := range l.segments {
+		if id >= before || id == active {
+			kept = append(kept, id)
+			continue
+		}
+		if err := os.Remove(l.path(id)); err != nil && !os.IsNotExist(err) {
+			kept = append(kept, id)
+			if firstErr == nil {
+				firstErr = err
+			}
+		}
+	}
+	l.segments = kept
+	return firstErr
+}
+
+// 按写入顺序读取全部记录, fn返回错误时停止
+func (l *Log) Replay(fn func(rec Record) error) error {
+	l.mu.Lock()
+	segments := append([]uint64(nil), l.segments...)
+	l.mu.Unlock()
+
+	for _, id := range segments {
+		if _, err := scanSegment(l.path(id), fn); err != nil {
+			return fmt.Errorf("%s: %w", filepath.Base(l.path(id)), err)
+		}
+	}
+	return nil
+}
+
+// 段文件数量
+func (l *Log) Segments() int {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return len(l.segments)
+}
+
+func (l *Log) Sync() error {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if l.closed {
+		return ErrClosed
+	}
+	if !l.dirty {
+		return nil
+	}
+	l.dirty = false
+	return l.f.Sync()
+}
+
+func (l *Log) syncLoop() {
+	defer close(l.done)
+	ticker := time.NewTicker(time.Second)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C:
+			l.Sync()
+		case <-l.stopCh:
+			return
+		}
+	}
+}
+
+// fsync并关闭当前段
+func (l *Log) Close() error {
+	l.mu.Lock()
+	if l.closed {
+		l.mu.Unlock()
+		return nil
+	}
+	l.closed = true
+	var err error
+	if l.f != nil {
+		err = l.f.Sync()
+		if cerr := l.f.Close(); err == nil {
+			err = cerr
+		}
+	}
+	l.mu.Unlock()
+
+	if l.stopCh != nil {
+		close(l.stopCh)
+		<-l.done
+	}
+	return err
+}
+
+func encode(rec Record) []byte {
+	buf := make([]byte, headerSize, headerSize+1+3*binary.MaxVarintLen64+len(rec.Key)+len(rec.Value))
+	buf = append(buf, byte(rec.Op))
+	buf = binary.AppendVarint(buf, rec.ExpireAt)
+	buf = binary.AppendUvarint(buf, uint64(len(rec.Key)))
+	buf = append(buf, rec.Key...)
+	buf = append(buf, rec.Value...)
+
+	payload := buf[headerSize:]
+	binary.LittleEndian.PutUint32(buf, uint32(len(payload)))
+	binary.LittleEndian.PutUint32(buf[4:], crc32.Checksum(payload, crcTable))
+	return buf
+}
+
+func decode(payload []byte) (Record, error) {
+	var rec Record
+	if len(payload) == 0 {
+		return rec, ErrCorrupt
+	}
+	rec.Op = Op(payload[0])
+	if rec.Op != OpSet && rec.Op != OpDelete {
+		return rec, ErrCorrupt
+	}
+	p := payload[1:]
+
+	expireAt, n := binary.Varint(p)
+	if n <= 0 {
+		return rec, ErrCorrupt
+	}
+	p = p[n:]
+	keyLen, n := binary.Uvarint(p)
+	if n <= 0 || keyLen > uint64(len(p)-n) {
+		return rec, ErrCorrupt
+	}
+	p = p[n:]
+
+	rec.ExpireAt = expireAt
+	rec.Key = string(p[:keyLen])
+	if rec.Op == OpSet {
+		rec.Value = append([]byte{}, p[keyLen:]...)
+	}
+	return rec, nil
+}
+
+// 依次读取段中的记录, 返回最后一条有效记录的结束位置
+//
+// 遇到不完整或校验失败的记录时返回ErrCorrupt, fn为nil时只做校验.
+func scanSegment(path string, fn func(rec Record) error) (int64, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return 0, err
+	}
+	defer f.Close()
+
+	r := bufio.NewReader(f)
+	var offset int64
+	var header [headerSize]byte
+	for {
+		if _, err := io.ReadFull(r, header[:]); err != nil {
+			if err == io.EOF {
+				return offset, nil
+			}
+			if err == io.ErrUnexpectedEOF {
+				return offset, fmt.Errorf("%w: torn header at %d", ErrCorrupt, offset)
+			}
+			return offset, err
+		}
+
+		size := binary.LittleEndian.Uint32(header[:])
+		if size > maxRecordSize {
+			return offset, fmt.Errorf("%w: record size %d at %d", ErrCorrupt, size, offset)
+		}
+		payload := make([]byte, size)
+		if _, err := io.ReadFull(r, payload); err != nil {
+			if err == io.EOF || err == io.ErrUnexpectedEOF {
+				return offset, fmt.Errorf("%w: torn record at %d", ErrCorrupt, offset)
+			}
+			return offset, err
+		}
+		if crc32.Checksum(payload, crcTable) != binary.LittleEndian.Uint32(header[4:]) {
+			return offset, fmt.Errorf("%w: checksum mismatch at %d", ErrCorrupt, offset)
+		}
+		rec, err := decode(payload)
+		if err != nil {
+			return offset, fmt.Errorf("%w at %d", err, offset)
+		}
+
+		if fn != nil {
+			if err := fn(rec); err != nil {
+				return offset, err
+			}
+		}
+		offset += headerSize + int64(size)
+	}
+}